@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are searched for in the current directory when --config-file is not set
+var configFileNames = []string{
+	"dbmate.yml", "dbmate.yaml", "dbmate.toml",
+	".dbmate.yml", ".dbmate.yaml", ".dbmate.toml",
+}
+
+// loadConfigFile reads global options from path, or from the first of configFileNames
+// which exists if path is empty. Files ending in .toml are read as TOML, and all others
+// as YAML. Keys are global flag names, e.g. "migrations-dir".
+func loadConfigFile(path string) (map[string]interface{}, error) {
+	if path == "" {
+		for _, name := range configFileNames {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		config, err = parseTOML(string(data))
+	} else {
+		err = yaml.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// applyConfig sets each global option from the config file which was not already
+// set by a command line flag or environment variable
+func applyConfig(c *cli.Context, config map[string]interface{}) error {
+	config, err := selectEnvironment(config, c.String("env"))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !isGlobalFlag(c.App, name) || name == "config-file" || name == "env" {
+			return fmt.Errorf("unsupported option in config file: %s", name)
+		}
+		if c.IsSet(name) {
+			continue
+		}
+		// the database url environment variable also takes precedence over the config file
+		if name == "url" && os.Getenv(c.String("env")) != "" {
+			continue
+		}
+
+		values, ok := config[name].([]interface{})
+		if !ok {
+			values = []interface{}{config[name]}
+		}
+		for _, value := range values {
+			if err := c.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("invalid value for %s in config file: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// selectEnvironment returns the top level options in config, overridden by the options of
+// the named environment (if config defines an environment with that name), which also
+// sets the environment option to its name
+func selectEnvironment(config map[string]interface{}, name string) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for key, value := range config {
+		if key != "environments" {
+			out[key] = value
+		}
+	}
+
+	if config["environments"] == nil {
+		return out, nil
+	}
+
+	environments, ok := config["environments"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("environments in config file must be a mapping of names to options")
+	}
+	if environments[name] == nil {
+		return out, nil
+	}
+
+	environment, ok := environments[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("environment %s in config file must be a mapping of options", name)
+	}
+	// the selected environment names the environment, unless it says otherwise
+	out["environment"] = name
+	for key, value := range environment {
+		out[key] = value
+	}
+
+	return out, nil
+}
+
+// isGlobalFlag returns whether name is the name of one of the app's global flags
+func isGlobalFlag(app *cli.App, name string) bool {
+	for _, f := range app.Flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultEnvFiles are loaded (if they exist) before any files given with --env-file
+var defaultEnvFiles = []string{".env", ".env.local"}
+
+// expandableEnvKey matches environment variable names which can be referenced as ${VAR}
+var expandableEnvKey = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+// loadDotEnv loads the default env files and envFiles into the environment. Values in
+// later files override earlier files, but never override existing environment variables.
+func loadDotEnv(envFiles []string) error {
+	files := []string{}
+	for _, name := range defaultEnvFiles {
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	files = append(files, envFiles...)
+	if len(files) == 0 {
+		return nil
+	}
+
+	values, err := readEnvFiles(os.Environ(), files)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readEnvFiles parses files as a single document, preceded by environ, so that ${VAR}
+// references resolve against the existing environment and any earlier file
+func readEnvFiles(environ []string, files []string) (map[string]string, error) {
+	var buf bytes.Buffer
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		// godotenv treats a quote preceded by a backslash as escaped, so values
+		// ending in a backslash cannot be quoted
+		if expandableEnvKey.MatchString(key) && !strings.HasSuffix(value, `\`) {
+			fmt.Fprintf(&buf, "%s=%s\n", key, quoteEnvValue(value))
+		}
+	}
+	seeded := buf.Len()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load env file: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	data := buf.Bytes()
+	values, err := godotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse env files %s: %w", strings.Join(files, ", "), err)
+	}
+
+	// only return values defined by the files themselves
+	defined, err := godotenv.Parse(bytes.NewReader(data[seeded:]))
+	if err != nil {
+		return nil, err
+	}
+	for key := range values {
+		if _, ok := defined[key]; !ok {
+			delete(values, key)
+		}
+	}
+
+	return values, nil
+}
+
+// quoteEnvValue double quotes value so that godotenv parses it literally
+func quoteEnvValue(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// applyEnvVars sets global flags from environment variables which were loaded from
+// env files after the command line was parsed
+func applyEnvVars(c *cli.Context) error {
+	for _, f := range c.App.Flags {
+		envFlag, ok := f.(cli.DocGenerationFlag)
+		if !ok {
+			continue
+		}
+
+		name := f.Names()[0]
+		if c.IsSet(name) {
+			continue
+		}
+
+		for _, key := range envFlag.GetEnvVars() {
+			if value := os.Getenv(key); value != "" {
+				if err := c.Set(name, value); err != nil {
+					return fmt.Errorf("invalid value %q for %s: %w", value, key, err)
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,34 +1,96 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/url"
 	"os"
-	"regexp"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v2"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/clickhouse"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/mysql"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/postgres"
+	"github.com/amacneil/dbmate/v2/pkg/service"
 )
 
 func main() {
-	loadDotEnv()
-
 	app := NewApp()
 	err := app.Run(os.Args)
 
 	if err != nil {
-		errText := redactLogString(fmt.Sprintf("Error: %s\n", err))
+		errText := dbmate.RedactString(fmt.Sprintf("Error: %s\n", err))
 		_, _ = fmt.Fprint(os.Stderr, errText)
-		os.Exit(2)
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes, which allow scripts to distinguish retryable failures from fatal ones.
+// Codes 1 and 6 are not errors, but report what dbmate status --exit-code found, so a
+// readiness check can tell them apart from a status command which failed (drift also
+// uses 1 when the schema differs).
+const (
+	exitPending          = 1 // pending migrations, or schema drift
+	exitError            = 2 // any error not covered below
+	exitConnectionFailed = 3 // the database (or a remote migrations source) could not be reached
+	exitInvalidMigration = 4 // a migration file could not be parsed, or violates a migration policy
+	exitMigrationFailed  = 5 // the SQL in a migration failed to execute
+	exitOrphans          = 6 // applied migrations are missing from the migrations directory
+	exitLocked           = 7 // another process holds the migration lock, or it could not be acquired
+)
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	// net.Error is not used here, since it is also implemented by syscall.Errno
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var parseErr *dbmate.ParseError
+	var migrationErr *dbmate.MigrationError
+
+	switch {
+	case errors.Is(err, dbmate.ErrCantConnect), errors.Is(err, dbmate.ErrCredentials), errors.Is(err, driver.ErrBadConn),
+		errors.As(err, &opErr), errors.As(err, &dnsErr):
+		return exitConnectionFailed
+	case errors.Is(err, dbmate.ErrLocked), errors.Is(err, dbmate.ErrLockFailed):
+		return exitLocked
+	case errors.As(err, &parseErr),
+		errors.Is(err, dbmate.ErrEmptyDownBlock),
+		errors.Is(err, dbmate.ErrMissingDependency),
+		errors.Is(err, dbmate.ErrInvalidDependency),
+		errors.Is(err, dbmate.ErrLintFailed),
+		errors.Is(err, dbmate.ErrMigrationModified),
+		errors.Is(err, dbmate.ErrUnsafeMigration),
+		errors.Is(err, dbmate.ErrImplicitCommit),
+		errors.Is(err, dbmate.ErrDuplicateVersion),
+		errors.Is(err, dbmate.ErrSignatureInvalid),
+		errors.Is(err, dbmate.ErrInvalidDownBlock):
+		return exitInvalidMigration
+	case errors.As(err, &migrationErr):
+		return exitMigrationFailed
+	default:
+		return exitError
 	}
 }
 
+var errCancelled = errors.New("cancelled")
+
+var errConfirmationRequired = errors.New("confirmation required")
+
+var errProductionEnvironment = errors.New("refusing to modify a production environment")
+
+var errResumeSingleDatabase = errors.New("--resume requires --tenant-pattern, --tenants-file or --tenants-query")
+
 // NewApp creates a new command line app
 func NewApp() *cli.App {
 	app := cli.NewApp()
@@ -47,7 +109,19 @@ func NewApp() *cli.App {
 			Name:    "env",
 			Aliases: []string{"e"},
 			Value:   "DATABASE_URL",
-			Usage:   "specify an environment variable containing the database URL",
+			Usage:   "specify an environment from the config file, or an environment variable containing the database URL",
+		},
+		&cli.StringFlag{
+			Name:      "config-file",
+			EnvVars:   []string{"DBMATE_CONFIG_FILE"},
+			Usage:     "specify a config file to read options from (default: dbmate.yml or dbmate.toml in the current directory)",
+			TakesFile: true,
+		},
+		&cli.StringSliceFlag{
+			Name:      "env-file",
+			EnvVars:   []string{"DBMATE_ENV_FILE"},
+			Usage:     "load environment variables from these files, after .env and .env.local",
+			TakesFile: true,
 		},
 		&cli.StringSliceFlag{
 			Name:    "migrations-dir",
@@ -56,6 +130,11 @@ func NewApp() *cli.App {
 			Value:   cli.NewStringSlice(defaultDB.MigrationsDir[0]),
 			Usage:   "specify the directory containing migration files",
 		},
+		&cli.StringFlag{
+			Name:    "migrations-url",
+			EnvVars: []string{"DBMATE_MIGRATIONS_URL", "MIGRATIONS_URL"},
+			Usage:   "specify an HTTP manifest source (http:// or https://) to read migration files from",
+		},
 		&cli.StringFlag{
 			Name:    "migrations-table",
 			EnvVars: []string{"DBMATE_MIGRATIONS_TABLE"},
@@ -69,11 +148,227 @@ func NewApp() *cli.App {
 			Value:   defaultDB.SchemaFile,
 			Usage:   "specify the schema file location",
 		},
+		&cli.StringFlag{
+			Name:    "schema-format",
+			EnvVars: []string{"DBMATE_SCHEMA_FORMAT"},
+			Value:   dbmate.SchemaFormatDefault,
+			Usage:   "specify the level of detail in the schema file (default, full, no-comments or minimal)",
+		},
+		&cli.StringFlag{
+			Name:    "schema-migrations-file",
+			EnvVars: []string{"DBMATE_SCHEMA_MIGRATIONS_FILE"},
+			Usage:   "write the applied migrations to this file, instead of the schema file",
+		},
+		&cli.StringSliceFlag{
+			Name:    "include-schema",
+			EnvVars: []string{"DBMATE_INCLUDE_SCHEMA"},
+			Usage:   "restrict the schema file to these Postgres schemas or MySQL databases",
+		},
+		&cli.StringFlag{
+			Name:    "schema-snapshot-dir",
+			EnvVars: []string{"DBMATE_SCHEMA_SNAPSHOT_DIR"},
+			Usage:   "write a copy of the schema to this directory after each migration is applied",
+		},
+		&cli.StringFlag{
+			Name:    "shard",
+			EnvVars: []string{"DBMATE_SHARD"},
+			Usage:   "label the database as this shard, for migration blocks with a shard option",
+		},
+		&cli.StringFlag{
+			Name:    "tenant-pattern",
+			EnvVars: []string{"DBMATE_TENANT_PATTERN"},
+			Usage:   "migrate every database on the server whose name matches this pattern (e.g. tenant_*)",
+		},
+		&cli.IntFlag{
+			Name:    "tenant-workers",
+			EnvVars: []string{"DBMATE_TENANT_WORKERS"},
+			Value:   1,
+			Usage:   "migrate or dump this many tenant databases at once",
+		},
+		&cli.DurationFlag{
+			Name:    "tenant-interval",
+			EnvVars: []string{"DBMATE_TENANT_INTERVAL"},
+			Usage:   "wait at least this long between starting each tenant database (e.g. 100ms)",
+		},
+		&cli.StringFlag{
+			Name:    "tenant-canary",
+			EnvVars: []string{"DBMATE_TENANT_CANARY"},
+			Usage:   "migrate and verify this tenant database before any other",
+		},
+		&cli.StringFlag{
+			Name:    "tenant-canary-query",
+			EnvVars: []string{"DBMATE_TENANT_CANARY_QUERY"},
+			Usage:   "verify the canary with this query, which fails if it returns any rows",
+		},
+		&cli.StringFlag{
+			Name:    "tenant-canary-command",
+			EnvVars: []string{"DBMATE_TENANT_CANARY_COMMAND"},
+			Usage:   "verify the canary with this shell command, which is run with DATABASE_URL set to the canary",
+		},
+		&cli.BoolFlag{
+			Name:    "tenant-keep-going",
+			EnvVars: []string{"DBMATE_TENANT_KEEP_GOING"},
+			Usage:   "continue migrating the remaining tenant databases after one fails",
+		},
+		&cli.StringFlag{
+			Name:      "tenant-progress-file",
+			EnvVars:   []string{"DBMATE_TENANT_PROGRESS_FILE"},
+			Value:     "./db/tenant_progress.json",
+			Usage:     "record the outcome of migrating each tenant database in this file",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:    "tenant-register-query",
+			EnvVars: []string{"DBMATE_TENANT_REGISTER_QUERY"},
+			Usage:   "register tenants created by 'tenant create' with this statement, which receives the tenant name as a parameter",
+		},
+		&cli.StringFlag{
+			Name:    "tenant-template",
+			EnvVars: []string{"DBMATE_TENANT_TEMPLATE"},
+			Usage:   "create tenant databases as a copy of this database, which is kept migrated",
+		},
+		&cli.StringFlag{
+			Name:      "tenants-file",
+			EnvVars:   []string{"DBMATE_TENANTS_FILE"},
+			Usage:     "migrate the databases listed in this file, one per line",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:    "tenants-query",
+			EnvVars: []string{"DBMATE_TENANTS_QUERY", "TENANTS_QUERY"},
+			Usage:   "migrate the databases returned by this query, run against the database in --url",
+		},
 		&cli.BoolFlag{
 			Name:    "no-dump-schema",
 			EnvVars: []string{"DBMATE_NO_DUMP_SCHEMA"},
 			Usage:   "don't update the schema file on migrate/rollback",
 		},
+		&cli.BoolFlag{
+			Name:    "lock",
+			EnvVars: []string{"DBMATE_LOCK"},
+			Usage:   "hold the migration lock during migrate/rollback, and refuse to run when another process holds it",
+		},
+		&cli.StringSliceFlag{
+			Name:    "protected-url-patterns",
+			EnvVars: []string{"DBMATE_PROTECTED_URL_PATTERNS", "PROTECTED_URL_PATTERNS"},
+			Usage:   "block drop and rollback for database hosts matching these patterns (e.g. *.prod.internal)",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-protected",
+			Usage: "allow drop and rollback for databases matching --protected-url-patterns",
+		},
+		&cli.StringFlag{
+			Name:    "environment",
+			EnvVars: []string{"DBMATE_ENVIRONMENT"},
+			Usage:   "the name of the environment which the database belongs to (defaults to the --env environment in the config file)",
+		},
+		&cli.StringSliceFlag{
+			Name:    "production-environments",
+			EnvVars: []string{"DBMATE_PRODUCTION_ENVIRONMENTS"},
+			Value:   cli.NewStringSlice("production", "prod"),
+			Usage:   "block drop and rollback when --environment is one of these environments",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-production",
+			Usage: "allow drop and rollback in environments listed in --production-environments",
+		},
+		&cli.BoolFlag{
+			Name:    "require-down-block",
+			EnvVars: []string{"DBMATE_REQUIRE_DOWN_BLOCK"},
+			Usage:   "refuse to apply migrations without statements in their down block",
+		},
+		&cli.StringFlag{
+			Name:    "orphans",
+			EnvVars: []string{"DBMATE_ORPHANS"},
+			Value:   dbmate.OrphansIgnore,
+			Usage:   "how to handle applied migrations which are missing from disk (ignore, warn or error)",
+		},
+		&cli.StringFlag{
+			Name:    "implicit-commit",
+			EnvVars: []string{"DBMATE_IMPLICIT_COMMIT"},
+			Value:   dbmate.ImplicitCommitWarn,
+			Usage:   "how to handle MySQL migrations mixing DDL with other statements in a transaction (ignore, warn or error)",
+		},
+		&cli.StringFlag{
+			Name:    "verify-signatures",
+			EnvVars: []string{"DBMATE_VERIFY_SIGNATURES"},
+			Usage:   "refuse to apply or roll back migrations without a valid detached signature, checked with minisign or gpg",
+		},
+		&cli.StringFlag{
+			Name:      "signature-key",
+			EnvVars:   []string{"DBMATE_SIGNATURE_KEY"},
+			Usage:     "the minisign public key, or gpg keyring, used by --verify-signatures",
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:    "strict",
+			EnvVars: []string{"DBMATE_STRICT"},
+			Usage:   "refuse to migrate or rollback if an applied migration file has been modified, and to create out of order migrations",
+		},
+		&cli.BoolFlag{
+			Name:    "no-color",
+			EnvVars: []string{"DBMATE_NO_COLOR"},
+			Usage:   "disable colored output (color is enabled automatically when writing to a terminal)",
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			EnvVars: []string{"DBMATE_LOG_LEVEL"},
+			Value:   dbmate.LogLevelInfo.String(),
+			Usage:   "specify the minimum level of messages to log (debug, info, warn or error)",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			EnvVars: []string{"DBMATE_QUIET"},
+			Usage:   "only log errors (same as --log-level error)",
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			EnvVars: []string{"DBMATE_LOG_FORMAT"},
+			Value:   dbmate.LogFormatText,
+			Usage:   "specify the log format (text or json)",
+		},
+		&cli.StringFlag{
+			Name:    "audit-log",
+			EnvVars: []string{"DBMATE_AUDIT_LOG"},
+			Usage:   "append a record of each command to this file, or post it to this http(s) URL",
+		},
+		&cli.StringFlag{
+			Name:    "notify-webhook",
+			EnvVars: []string{"DBMATE_NOTIFY_WEBHOOK"},
+			Usage:   "post a JSON summary of migrate and rollback runs to this URL",
+		},
+		&cli.StringFlag{
+			Name:    "notify-slack-webhook",
+			EnvVars: []string{"DBMATE_NOTIFY_SLACK_WEBHOOK"},
+			Usage:   "post a summary of migrate and rollback runs to this Slack incoming webhook URL",
+		},
+		&cli.StringFlag{
+			Name:    "metrics-pushgateway",
+			EnvVars: []string{"DBMATE_METRICS_PUSHGATEWAY"},
+			Usage:   "push Prometheus metrics to this Pushgateway URL after the command runs",
+		},
+		&cli.DurationFlag{
+			Name:    "lock-timeout",
+			EnvVars: []string{"DBMATE_LOCK_TIMEOUT"},
+			Usage:   "set lock_timeout for each migration, unless it sets a lock_timeout option (postgres only)",
+		},
+		&cli.DurationFlag{
+			Name:    "statement-timeout",
+			EnvVars: []string{"DBMATE_STATEMENT_TIMEOUT"},
+			Usage:   "set statement_timeout for each migration, unless it sets a statement_timeout option (postgres only)",
+		},
+		&cli.DurationFlag{
+			Name:    "max-migration-duration",
+			EnvVars: []string{"DBMATE_MAX_MIGRATION_DURATION"},
+			Usage:   "cancel a migration, and roll back its transaction, if it runs for longer than this",
+		},
+		&cli.IntFlag{
+			Name:    "transaction-retries",
+			EnvVars: []string{"DBMATE_TRANSACTION_RETRIES"},
+			Value:   5,
+			Usage:   "retry a migration transaction this many times, with exponential backoff, if CockroachDB asks for it to be restarted",
+		},
 		&cli.BoolFlag{
 			Name:    "wait",
 			EnvVars: []string{"DBMATE_WAIT"},
@@ -87,16 +382,161 @@ func NewApp() *cli.App {
 		},
 	}
 
+	app.Before = func(c *cli.Context) error {
+		if err := loadDotEnv(c.StringSlice("env-file")); err != nil {
+			return err
+		}
+
+		// flags were parsed before env files were loaded
+		return applyEnvVars(c)
+	}
+
 	app.Commands = []*cli.Command{
 		{
 			Name:    "new",
 			Aliases: []string{"n"},
 			Usage:   "Generate a new migration file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "up",
+					Usage: "SQL to write to the up block",
+				},
+				&cli.StringFlag{
+					Name:  "down",
+					Usage: "SQL to write to the down block",
+				},
+				&cli.StringFlag{
+					Name:      "up-file",
+					Usage:     "read the up block from a file",
+					TakesFile: true,
+				},
+				&cli.StringFlag{
+					Name:      "down-file",
+					Usage:     "read the down block from a file",
+					TakesFile: true,
+				},
+				&cli.BoolFlag{
+					Name:    "git",
+					EnvVars: []string{"DBMATE_GIT"},
+					Usage:   "warn if an unmerged git branch contains a later migration",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.NArg() > 1 {
+					return fmt.Errorf("unexpected arguments %v (flags must precede the migration name)", c.Args().Tail())
+				}
+				db.GitCheck = c.Bool("git")
 				name := c.Args().First()
-				return db.NewMigration(name)
+				up, err := flagOrFile(c, "up", "up-file")
+				if err != nil {
+					return err
+				}
+				down, err := flagOrFile(c, "down", "down-file")
+				if err != nil {
+					return err
+				}
+				return db.NewMigrationWithContents(name, up, down)
+			}),
+		},
+		{
+			Name:  "generate",
+			Usage: "Generate a new migration file which brings the database schema up to date",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "from-schema",
+					Usage: "generate the changes needed to match the schema file",
+				},
+				&cli.StringFlag{
+					Name:  "from-url",
+					Usage: "generate the changes needed to match the schema of another database",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.NArg() > 1 {
+					return fmt.Errorf("unexpected arguments %v (flags must precede the migration name)", c.Args().Tail())
+				}
+
+				var desired []byte
+				var err error
+				switch {
+				case c.Bool("from-schema") && c.IsSet("from-url"):
+					return errors.New("--from-schema and --from-url cannot be used together")
+				case c.Bool("from-schema"):
+					desired, err = os.ReadFile(db.SchemaFile)
+				case c.IsSet("from-url"):
+					var u *url.URL
+					if u, err = url.Parse(c.String("from-url")); err == nil {
+						source := dbmate.New(u)
+						source.Log = db.Log
+						source.MigrationsTableName = db.MigrationsTableName
+						desired, err = source.CurrentSchema()
+					}
+				default:
+					return errors.New("please specify --from-schema or --from-url")
+				}
+				if err != nil {
+					return err
+				}
+
+				return db.GenerateMigration(c.Args().First(), desired)
 			}),
 		},
+		{
+			Name:  "fixtures",
+			Usage: "Manage test fixtures",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "load",
+					Usage:     "Replace table contents with a set of fixture files",
+					ArgsUsage: "<set>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "fixtures-dir",
+							EnvVars: []string{"DBMATE_FIXTURES_DIR"},
+							Value:   "./db/fixtures",
+							Usage:   "specify the directory containing fixture sets",
+						},
+					},
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("please specify the name of one fixture set")
+						}
+						db.FixturesDir = c.String("fixtures-dir")
+						return db.LoadFixtures(c.Args().First())
+					}),
+				},
+			},
+		},
+		{
+			Name:  "tenant",
+			Usage: "Manage tenant databases",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "create",
+					Usage:     "Create and migrate a tenant database, then register it in the tenants source",
+					ArgsUsage: "<name>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "seeds",
+							Usage: "load this fixture set into the new tenant",
+						},
+						&cli.StringFlag{
+							Name:    "fixtures-dir",
+							EnvVars: []string{"DBMATE_FIXTURES_DIR"},
+							Value:   "./db/fixtures",
+							Usage:   "specify the directory containing fixture sets",
+						},
+					},
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("please specify the name of one tenant")
+						}
+						db.FixturesDir = c.String("fixtures-dir")
+						return db.CreateTenant(c.Args().First(), c.String("seeds"))
+					}),
+				},
+			},
+		},
 		{
 			Name:  "up",
 			Usage: "Create database (if necessary) and migrate to the latest version",
@@ -105,11 +545,52 @@ func NewApp() *cli.App {
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print each statement with its result and execution time",
+				},
+				&cli.BoolFlag{
+					Name:    "progress",
+					EnvVars: []string{"DBMATE_PROGRESS"},
+					Usage:   "print the number of applied migrations and estimated time remaining",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tags",
+					EnvVars: []string{"DBMATE_TAGS"},
+					Usage:   "only apply migrations tagged with any of these tags",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip-tags",
+					EnvVars: []string{"DBMATE_SKIP_TAGS"},
+					Usage:   "don't apply migrations tagged with any of these tags",
+				},
+				&cli.BoolFlag{
+					Name:  "resume",
+					Usage: "only migrate the tenant databases which were not migrated successfully by the previous run",
+				},
+				&cli.BoolFlag{
+					Name:    "preflight",
+					EnvVars: []string{"DBMATE_PREFLIGHT"},
+					Usage:   "check that every target database can be migrated and locked before applying any migrations",
+				},
+				&cli.BoolFlag{
+					Name:    "check-down",
+					EnvVars: []string{"DBMATE_CHECK_DOWN"},
+					Usage:   "syntax check the down block of each migration after applying it, failing the migration if it is invalid",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				db.Verbose = c.Bool("verbose")
+				db.Tags = c.StringSlice("tags")
+				db.SkipTags = c.StringSlice("skip-tags")
+				db.Progress = c.Bool("progress")
+				db.TenantResume = c.Bool("resume")
+				db.Preflight = c.Bool("preflight")
+				db.CheckDownBlocks = c.Bool("check-down")
+				if db.MultiTenant() {
+					return db.MigrateTenants()
+				}
+				if db.TenantResume {
+					return errResumeSingleDatabase
+				}
 				return db.CreateAndMigrate()
 			}),
 		},
@@ -123,7 +604,19 @@ func NewApp() *cli.App {
 		{
 			Name:  "drop",
 			Usage: "Drop database (if it exists)",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"yes", "y"},
+					EnvVars: []string{"DBMATE_FORCE"},
+					Usage:   "don't ask for confirmation before dropping the database",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				prompt := fmt.Sprintf("Drop database %s?", db.DatabaseURL.Redacted())
+				if err := requireConfirmation(c, os.Stdin, os.Stdout, prompt); err != nil {
+					return err
+				}
 				return db.Drop()
 			}),
 		},
@@ -135,14 +628,144 @@ func NewApp() *cli.App {
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print each statement with its result and execution time",
+				},
+				&cli.BoolFlag{
+					Name:    "progress",
+					EnvVars: []string{"DBMATE_PROGRESS"},
+					Usage:   "print the number of applied migrations and estimated time remaining",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tags",
+					EnvVars: []string{"DBMATE_TAGS"},
+					Usage:   "only apply migrations tagged with any of these tags",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip-tags",
+					EnvVars: []string{"DBMATE_SKIP_TAGS"},
+					Usage:   "don't apply migrations tagged with any of these tags",
+				},
+				&cli.BoolFlag{
+					Name:  "resume",
+					Usage: "only migrate the tenant databases which were not migrated successfully by the previous run",
+				},
+				&cli.BoolFlag{
+					Name:    "preflight",
+					EnvVars: []string{"DBMATE_PREFLIGHT"},
+					Usage:   "check that every target database can be migrated and locked before applying any migrations",
+				},
+				&cli.BoolFlag{
+					Name:    "check-down",
+					EnvVars: []string{"DBMATE_CHECK_DOWN"},
+					Usage:   "syntax check the down block of each migration after applying it, failing the migration if it is invalid",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				db.Verbose = c.Bool("verbose")
+				db.Tags = c.StringSlice("tags")
+				db.SkipTags = c.StringSlice("skip-tags")
+				db.Progress = c.Bool("progress")
+				db.TenantResume = c.Bool("resume")
+				db.Preflight = c.Bool("preflight")
+				db.CheckDownBlocks = c.Bool("check-down")
+				if db.MultiTenant() {
+					return db.MigrateTenants()
+				}
+				if db.TenantResume {
+					return errResumeSingleDatabase
+				}
 				return db.Migrate()
 			}),
 		},
+		{
+			Name:  "watch",
+			Usage: "Apply new migrations as they are created, until interrupted",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print each statement with its result and execution time",
+				},
+				&cli.DurationFlag{
+					Name:    "interval",
+					EnvVars: []string{"DBMATE_WATCH_INTERVAL"},
+					Value:   dbmate.DefaultWatchInterval,
+					Usage:   "how often to check for new migrations where changes cannot be watched (e.g. --migrations-url)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if db.MultiTenant() {
+					return errors.New("watch does not support multiple tenant databases")
+				}
+				db.Verbose = c.Bool("verbose")
+				ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				return db.Watch(ctx, c.Duration("interval"))
+			}),
+		},
+		{
+			Name:  "serve",
+			Usage: "Serve the gRPC migration service (see pkg/service/dbmate.proto), until interrupted",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "listen",
+					EnvVars: []string{"DBMATE_SERVE_LISTEN"},
+					Value:   ":50051",
+					Usage:   "the address to listen on",
+				},
+				&cli.StringFlag{
+					Name:      "tls-cert",
+					EnvVars:   []string{"DBMATE_SERVE_TLS_CERT"},
+					Usage:     "the TLS certificate file (gRPC requires HTTP/2, which is only served over TLS)",
+					TakesFile: true,
+					Required:  true,
+				},
+				&cli.StringFlag{
+					Name:      "tls-key",
+					EnvVars:   []string{"DBMATE_SERVE_TLS_KEY"},
+					Usage:     "the TLS private key file",
+					TakesFile: true,
+					Required:  true,
+				},
+				&cli.StringFlag{
+					Name:      "tls-client-ca",
+					EnvVars:   []string{"DBMATE_SERVE_TLS_CLIENT_CA"},
+					Usage:     "the CA certificates file which client certificates must be signed by",
+					TakesFile: true,
+					Required:  true,
+				},
+				&cli.DurationFlag{
+					Name:    "shutdown-timeout",
+					EnvVars: []string{"DBMATE_SERVE_SHUTDOWN_TIMEOUT"},
+					Value:   time.Minute,
+					Usage:   "how long to wait for running calls to finish when interrupted",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if db.MultiTenant() {
+					return errors.New("serve does not support multiple tenant databases")
+				}
+				db.ReuseConnections = true
+				defer func() { _ = db.Close() }()
+
+				ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				server := service.NewServer(db)
+				// the service can roll back, so it is subject to the same check as the
+				// rollback command
+				server.RollbackRefused = productionError(c, "serve rollbacks")
+				files := service.TLSFiles{
+					CertFile:     c.String("tls-cert"),
+					KeyFile:      c.String("tls-key"),
+					ClientCAFile: c.String("tls-client-ca"),
+				}
+
+				fmt.Fprintf(db.Log, "Serving: %s\n", c.String("listen"))
+				return service.ListenAndServeTLS(ctx, c.String("listen"), files, server,
+					c.Duration("shutdown-timeout"))
+			}),
+		},
 		{
 			Name:    "rollback",
 			Aliases: []string{"down"},
@@ -152,11 +775,24 @@ func NewApp() *cli.App {
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print each statement with its result and execution time",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"yes", "y"},
+					EnvVars: []string{"DBMATE_FORCE"},
+					Usage:   "don't ask for confirmation before rolling back in a production environment",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				db.Verbose = c.Bool("verbose")
+				if isProductionEnvironment(c) {
+					prompt := fmt.Sprintf("Roll back the most recent migration in %s (%s)?",
+						c.String("environment"), db.DatabaseURL.Redacted())
+					if err := requireConfirmation(c, os.Stdin, os.Stdout, prompt); err != nil {
+						return err
+					}
+				}
 				return db.Rollback()
 			}),
 		},
@@ -166,12 +802,33 @@ func NewApp() *cli.App {
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
 					Name:  "exit-code",
-					Usage: "return 1 if there are pending migrations",
+					Usage: "return 1 if there are pending migrations, or 6 if applied migrations are missing from disk",
 				},
 				&cli.BoolFlag{
 					Name:  "quiet",
 					Usage: "don't output any text (implies --exit-code)",
 				},
+				&cli.BoolFlag{
+					Name:  "pending",
+					Usage: "only list pending migrations",
+				},
+				&cli.BoolFlag{
+					Name:  "applied",
+					Usage: "only list applied migrations",
+				},
+				&cli.BoolFlag{
+					Name:  "wide",
+					Usage: "also show the ticket, author and tags of each migration",
+				},
+				&cli.BoolFlag{
+					Name:  "tenants",
+					Usage: "summarize the status of each tenant database",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Value: dbmate.StatusFormatTable,
+					Usage: "the format of the tenants summary (table or json)",
+				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				setExitCode := c.Bool("exit-code")
@@ -180,23 +837,270 @@ func NewApp() *cli.App {
 					setExitCode = true
 				}
 
-				pending, err := db.Status(quiet)
+				if c.Bool("tenants") {
+					pending, err := db.StatusTenants(c.String("format"))
+					if err != nil {
+						return err
+					}
+					if setExitCode && pending > 0 {
+						return cli.Exit("", exitPending)
+					}
+					return nil
+				}
+				if c.IsSet("format") {
+					return errors.New("--format can only be used with --tenants")
+				}
+
+				db.StatusApplied = c.Bool("applied")
+				db.StatusPending = c.Bool("pending")
+				db.StatusWide = c.Bool("wide")
+				report, err := db.StatusExtended()
 				if err != nil {
 					return err
 				}
+				if err := db.PrintStatus(report, quiet); err != nil {
+					return err
+				}
+
+				if !setExitCode {
+					return nil
+				}
 
-				if pending > 0 && setExitCode {
-					return cli.Exit("", 1)
+				// orphaned migrations take precedence, since they usually indicate a bad checkout
+				if len(report.Missing) > 0 {
+					return cli.Exit("", exitOrphans)
+				}
+				if len(report.Pending) > 0 {
+					return cli.Exit("", exitPending)
 				}
 
 				return nil
 			}),
 		},
+		{
+			Name:  "plan",
+			Usage: "Write the SQL for all pending migrations, without applying them",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:      "out",
+					Aliases:   []string{"o"},
+					Usage:     "write the plan to a file instead of stdout",
+					TakesFile: true,
+				},
+				&cli.StringSliceFlag{
+					Name:    "tags",
+					EnvVars: []string{"DBMATE_TAGS"},
+					Usage:   "only include migrations tagged with any of these tags",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip-tags",
+					EnvVars: []string{"DBMATE_SKIP_TAGS"},
+					Usage:   "don't include migrations tagged with any of these tags",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.Tags = c.StringSlice("tags")
+				db.SkipTags = c.StringSlice("skip-tags")
+
+				path := c.String("out")
+				if path == "" {
+					// keep log messages out of the plan
+					db.Log = os.Stderr
+					return db.Plan(os.Stdout)
+				}
+
+				var buf bytes.Buffer
+				if err := db.Plan(&buf); err != nil {
+					return err
+				}
+				fmt.Fprintf(db.Log, "Writing: %s\n", path)
+				return os.WriteFile(path, buf.Bytes(), 0o644)
+			}),
+		},
+		{
+			Name:  "lint",
+			Usage: "Check migration files for errors",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "git",
+					EnvVars: []string{"DBMATE_GIT"},
+					Usage:   "report migrations which git history shows were merged after a later migration",
+				},
+				&cli.StringFlag{
+					Name:    "git-base",
+					EnvVars: []string{"DBMATE_GIT_BASE"},
+					Usage:   "with --git, only report migrations merged after this git revision (e.g. origin/main)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.GitCheck = c.Bool("git")
+				db.GitBase = c.String("git-base")
+				return db.Lint()
+			}),
+		},
+		{
+			Name:  "safety",
+			Usage: "Check pending migrations for operations which lock or rewrite postgres tables",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Safety()
+			}),
+		},
 		{
 			Name:  "dump",
 			Usage: "Write the database schema to disk",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "data",
+					Usage: "write the schema and data to --out or stdout, instead of the schema file",
+				},
+				&cli.StringFlag{
+					Name:      "anonymize",
+					Usage:     "replace column values in the data using the rules in this YAML file",
+					TakesFile: true,
+				},
+				&cli.StringFlag{
+					Name:      "out",
+					Aliases:   []string{"o"},
+					Usage:     "write the data dump to a file instead of stdout",
+					TakesFile: true,
+				},
+				&cli.BoolFlag{
+					Name:  "tenants",
+					Usage: "write the schema of each tenant database to its own schema file",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.Bool("tenants") {
+					if c.Bool("data") {
+						return errors.New("--data cannot be used with --tenants")
+					}
+					return db.DumpTenantSchemas()
+				}
+				if !c.Bool("data") {
+					if c.IsSet("anonymize") || c.IsSet("out") {
+						return errors.New("--anonymize and --out require --data")
+					}
+					return db.DumpSchema()
+				}
+
+				var rules dbmate.AnonymizeRules
+				if path := c.String("anonymize"); path != "" {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						return err
+					}
+					if rules, err = dbmate.ParseAnonymizeRules(data); err != nil {
+						return err
+					}
+				}
+
+				path := c.String("out")
+				if path == "" {
+					return db.DumpData(os.Stdout, rules)
+				}
+
+				var buf bytes.Buffer
+				if err := db.DumpData(&buf, rules); err != nil {
+					return err
+				}
+				fmt.Fprintf(db.Log, "Writing: %s\n", path)
+				return os.WriteFile(path, buf.Bytes(), 0o644)
+			}),
+		},
+		{
+			Name:  "docs",
+			Usage: "Write Markdown documentation of the database schema",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "diagram",
+					Usage: "include a Mermaid entity relationship diagram",
+				},
+				&cli.StringFlag{
+					Name:      "out",
+					Aliases:   []string{"o"},
+					Usage:     "write the documentation to a file instead of stdout",
+					TakesFile: true,
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
-				return db.DumpSchema()
+				path := c.String("out")
+				if path == "" {
+					return db.GenerateDocs(os.Stdout, c.Bool("diagram"))
+				}
+
+				var buf bytes.Buffer
+				if err := db.GenerateDocs(&buf, c.Bool("diagram")); err != nil {
+					return err
+				}
+				fmt.Fprintf(db.Log, "Writing: %s\n", path)
+				return os.WriteFile(path, buf.Bytes(), 0o644)
+			}),
+		},
+		{
+			Name:  "schema:verify",
+			Usage: "Check that the schema file matches the database schema exactly",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.VerifySchema()
+			}),
+		},
+		{
+			Name:  "drift",
+			Usage: "Compare the database schema with the schema file, and print any differences",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				diff, err := db.Drift()
+				if err != nil {
+					return err
+				}
+
+				if diff != "" {
+					fmt.Fprint(os.Stdout, diff)
+					return cli.Exit("", exitPending)
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "doctor",
+			Usage: "Check the configuration and database connection for problems",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Doctor()
+			}),
+		},
+		{
+			Name:  "console",
+			Usage: "Open the native command line client (psql, mysql or sqlite3) for the database",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				err := db.Console()
+
+				// pass through the client exit status rather than reporting an error
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					return cli.Exit("", exitErr.ExitCode())
+				}
+
+				return err
+			}),
+		},
+		{
+			Name:  "unlock",
+			Usage: "Show which process holds the migration lock, and release it with --force",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "terminate the database session which holds the lock",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.Bool("force") {
+					return db.ForceUnlock()
+				}
+
+				err := db.CheckLock()
+				if errors.Is(err, dbmate.ErrLocked) {
+					return fmt.Errorf("%w (pass --force to terminate its session)", err)
+				}
+				return err
 			}),
 		},
 		{
@@ -211,39 +1115,338 @@ func NewApp() *cli.App {
 	return app
 }
 
-// load environment variables from .env file
-func loadDotEnv() {
-	if _, err := os.Stat(".env"); err != nil {
-		return
-	}
-
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %s", err.Error())
-	}
-}
-
 // action wraps a cli.ActionFunc with dbmate initialization logic
 func action(f func(*dbmate.DB, *cli.Context) error) cli.ActionFunc {
 	return func(c *cli.Context) error {
+		config, err := loadConfigFile(c.String("config-file"))
+		if err != nil {
+			return err
+		}
+		if err := applyConfig(c, config); err != nil {
+			return err
+		}
+		if err := checkProduction(c); err != nil {
+			return err
+		}
+		if err := validateURLs(c); err != nil {
+			return err
+		}
+
 		u, err := getDatabaseURL(c)
 		if err != nil {
 			return err
 		}
 		db := dbmate.New(u)
 		db.AutoDumpSchema = !c.Bool("no-dump-schema")
+		db.LockMigrations = c.Bool("lock")
 		db.MigrationsDir = c.StringSlice("migrations-dir")
+		if value := c.String("migrations-url"); value != "" {
+			migrationsURL, err := url.Parse(value)
+			if err != nil {
+				// the parse error includes the URL, which may contain credentials
+				var urlErr *url.Error
+				if errors.As(err, &urlErr) {
+					err = urlErr.Err
+				}
+				return fmt.Errorf("invalid --migrations-url: %w", err)
+			}
+			remoteFS, err := dbmate.NewRemoteFS(migrationsURL, "")
+			if err != nil {
+				return err
+			}
+			db.FS = remoteFS
+			db.MigrationsDir = []string{"."}
+		}
 		db.MigrationsTableName = c.String("migrations-table")
+		db.ProtectedURLPatterns = c.StringSlice("protected-url-patterns")
+		db.AllowProtected = c.Bool("allow-protected")
+		db.RequireDownBlock = c.Bool("require-down-block")
+		db.Strict = c.Bool("strict")
+		db.OrphanPolicy = c.String("orphans")
+		db.ImplicitCommitPolicy = c.String("implicit-commit")
+		db.VerifySignatures = c.String("verify-signatures")
+		db.SignatureKey = c.String("signature-key")
+		db.MaxMigrationDuration = c.Duration("max-migration-duration")
+		db.TransactionRetries = c.Int("transaction-retries")
+		db.LockTimeout = c.Duration("lock-timeout")
+		db.StatementTimeout = c.Duration("statement-timeout")
 		db.SchemaFile = c.String("schema-file")
+		db.SchemaFormat = c.String("schema-format")
+		db.SchemaMigrationsFile = c.String("schema-migrations-file")
+		db.IncludeSchemas = c.StringSlice("include-schema")
+		db.SchemaSnapshotDir = c.String("schema-snapshot-dir")
+		db.Shard = c.String("shard")
+		db.TenantPattern = c.String("tenant-pattern")
+		db.TenantWorkers = c.Int("tenant-workers")
+		db.TenantInterval = c.Duration("tenant-interval")
+		db.TenantCanary = c.String("tenant-canary")
+		db.TenantCanaryQuery = c.String("tenant-canary-query")
+		db.TenantCanaryCommand = c.String("tenant-canary-command")
+		db.TenantKeepGoing = c.Bool("tenant-keep-going")
+		db.TenantProgressFile = c.String("tenant-progress-file")
+		db.TenantRegisterQuery = c.String("tenant-register-query")
+		db.TenantTemplate = c.String("tenant-template")
+		db.TenantsFile = c.String("tenants-file")
+		db.TenantsQuery = c.String("tenants-query")
 		db.WaitBefore = c.Bool("wait")
+		db.LogLevel, err = dbmate.ParseLogLevel(c.String("log-level"))
+		if err != nil {
+			return err
+		}
+		if c.Bool("quiet") {
+			db.LogLevel = dbmate.LogLevelError
+		}
 		waitTimeout := c.Duration("wait-timeout")
 		if waitTimeout != 0 {
 			db.WaitTimeout = waitTimeout
 		}
+		if gateway := c.String("metrics-pushgateway"); gateway != "" {
+			f = withMetrics(db, gateway, f)
+		}
+		if auditLog := c.String("audit-log"); auditLog != "" {
+			f = withAudit(db, auditLog, f)
+		}
+		if notifyCommands[c.Command.Name] && (c.String("notify-webhook") != "" || c.String("notify-slack-webhook") != "") {
+			f = withNotifications(db, f)
+		}
 
-		return f(db, c)
+		switch format := c.String("log-format"); format {
+		case dbmate.LogFormatText:
+			db.Color = useColor(c, os.Stdout)
+			return textAction(db, c, f)
+		case dbmate.LogFormatJSON:
+			return jsonAction(db, c, f)
+		default:
+			return fmt.Errorf("unsupported log format: %s", format)
+		}
 	}
 }
 
+// withMetrics wraps f to push metrics about the migrations it applies to a Prometheus
+// Pushgateway. Failing to push metrics does not fail the command.
+func withMetrics(db *dbmate.DB, gateway string, f func(*dbmate.DB, *cli.Context) error) func(*dbmate.DB, *cli.Context) error {
+	metrics := dbmate.NewMetrics()
+	db.Hooks = dbmate.MultiHooks(db.Hooks, metrics.Hooks())
+
+	return func(db *dbmate.DB, c *cli.Context) error {
+		err := f(db, c)
+
+		if status, healthErr := db.HealthCheck(c.Context); healthErr == nil {
+			metrics.SetPending(status.Pending)
+		}
+		if pushErr := metrics.Push(gateway); pushErr != nil {
+			_, _ = fmt.Fprintln(os.Stderr, dbmate.RedactString(fmt.Sprintf("Warning: %s", pushErr)))
+		}
+
+		return err
+	}
+}
+
+// withAudit wraps f to append a record of the command, and the migrations it touched, to
+// an audit log. If the command succeeds but the record cannot be written, the command fails.
+func withAudit(db *dbmate.DB, dest string, f func(*dbmate.DB, *cli.Context) error) func(*dbmate.DB, *cli.Context) error {
+	versions := []string{}
+	db.Hooks = dbmate.MultiHooks(db.Hooks, dbmate.Hooks{
+		BeforeMigration: func(event dbmate.MigrationEvent) {
+			versions = append(versions, event.Version)
+		},
+	})
+
+	return func(db *dbmate.DB, c *cli.Context) error {
+		err := f(db, c)
+
+		record := dbmate.NewAuditRecord(c.Command.Name, db.DatabaseURL, versions, err)
+		if auditErr := dbmate.WriteAuditRecord(dest, record); auditErr != nil {
+			auditErr = fmt.Errorf("unable to write audit log: %w", auditErr)
+			if err == nil {
+				return auditErr
+			}
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", auditErr)
+		}
+
+		return err
+	}
+}
+
+// notifyCommands are the commands which send notifications
+var notifyCommands = map[string]bool{"up": true, "migrate": true, "rollback": true}
+
+// withNotifications wraps f to post a summary of the migrations it applies or rolls back
+// to the configured webhooks. Failing to send a notification does not fail the command.
+func withNotifications(db *dbmate.DB, f func(*dbmate.DB, *cli.Context) error) func(*dbmate.DB, *cli.Context) error {
+	notifier := dbmate.NewNotifier()
+	db.Hooks = dbmate.MultiHooks(db.Hooks, notifier.Hooks())
+
+	return func(db *dbmate.DB, c *cli.Context) error {
+		err := f(db, c)
+		if notifier.Empty() && err == nil {
+			// nothing to report
+			return nil
+		}
+
+		notification := notifier.Notification(c.Command.Name, db.DatabaseURL, err)
+		if webhook := c.String("notify-webhook"); webhook != "" {
+			if notifyErr := dbmate.SendWebhook(webhook, notification); notifyErr != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", notifyErr)
+			}
+		}
+		if webhook := c.String("notify-slack-webhook"); webhook != "" {
+			if notifyErr := dbmate.SendSlack(webhook, notification); notifyErr != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", notifyErr)
+			}
+		}
+
+		return err
+	}
+}
+
+// textAction runs f, printing errors in red when writing to a terminal
+func textAction(db *dbmate.DB, c *cli.Context, f func(*dbmate.DB, *cli.Context) error) error {
+	err := f(db, c)
+
+	var exitErr cli.ExitCoder
+	if err == nil || errors.As(err, &exitErr) || !useColor(c, os.Stderr) {
+		return err
+	}
+
+	errText := dbmate.RedactString(fmt.Sprintf("Error: %s", err))
+	_, _ = fmt.Fprintln(os.Stderr, dbmate.Colorize(dbmate.ColorRed, errText))
+	return cli.Exit("", exitCode(err))
+}
+
+// useColor returns whether colored output should be written to f
+func useColor(c *cli.Context, f *os.File) bool {
+	// see https://no-color.org
+	if c.Bool("no-color") || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	return isTerminal(f)
+}
+
+// isTerminal returns whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// requireConfirmation asks the user to confirm prompt unless --force is set. Since nobody
+// can answer when in is not a terminal, it then refuses to continue without --force.
+func requireConfirmation(c *cli.Context, in *os.File, out io.Writer, prompt string) error {
+	if c.Bool("force") {
+		return nil
+	}
+	if !isTerminal(in) {
+		return fmt.Errorf("%w: stdin is not a terminal, pass --force to %s without confirmation",
+			errConfirmationRequired, c.Command.Name)
+	}
+
+	ok, err := confirm(in, out, prompt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errCancelled
+	}
+
+	return nil
+}
+
+// confirm asks the user a yes/no question, defaulting to no
+func confirm(in io.Reader, out io.Writer, prompt string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", prompt)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// jsonAction runs f with all output, including errors, written as JSON log entries
+func jsonAction(db *dbmate.DB, c *cli.Context, f func(*dbmate.DB, *cli.Context) error) error {
+	logWriter := dbmate.NewJSONLogWriter(os.Stdout, "info")
+	db.Log = logWriter
+
+	err := f(db, c)
+	if closeErr := logWriter.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	var exitErr cli.ExitCoder
+	if err == nil || errors.As(err, &exitErr) {
+		return err
+	}
+
+	_ = dbmate.WriteJSONLog(os.Stderr, "error", dbmate.RedactString(err.Error()))
+	return cli.Exit("", exitCode(err))
+}
+
+// productionCommands are refused in production environments unless --allow-production
+// is set
+var productionCommands = map[string]bool{"drop": true, "rollback": true}
+
+// checkProduction refuses to run a productionCommand when --environment is one of the
+// --production-environments
+func checkProduction(c *cli.Context) error {
+	if !productionCommands[c.Command.Name] {
+		return nil
+	}
+
+	return productionError(c, c.Command.Name)
+}
+
+// productionError returns an error refusing action when --environment is one of the
+// --production-environments and --allow-production is not set
+func productionError(c *cli.Context, action string) error {
+	if c.Bool("allow-production") || !isProductionEnvironment(c) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s is a production environment, pass --allow-production to %s anyway",
+		errProductionEnvironment, c.String("environment"), action)
+}
+
+// isProductionEnvironment returns whether --environment is one of the
+// --production-environments
+func isProductionEnvironment(c *cli.Context) bool {
+	environment := c.String("environment")
+	for _, name := range c.StringSlice("production-environments") {
+		if environment != "" && strings.EqualFold(environment, strings.TrimSpace(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// offlineCommands do not connect to a database, so the database url is not validated
+// before they run
+var offlineCommands = map[string]bool{"new": true, "lint": true, "doctor": true}
+
+// validateURLs checks every database url used by the command before it starts, and
+// reports all invalid urls at once
+func validateURLs(c *cli.Context) error {
+	if offlineCommands[c.Command.Name] {
+		return nil
+	}
+
+	urls := map[string]string{"--from-url": c.String("from-url")}
+	if value := c.String("url"); value != "" {
+		urls["--url"] = value
+	} else {
+		urls[c.String("env")] = os.Getenv(c.String("env"))
+	}
+
+	return dbmate.ValidateURLs(urls)
+}
+
 // getDatabaseURL returns the current database url from cli flag or environment variable
 func getDatabaseURL(c *cli.Context) (u *url.URL, err error) {
 	// check --url flag first
@@ -257,9 +1460,16 @@ func getDatabaseURL(c *cli.Context) (u *url.URL, err error) {
 	return url.Parse(value)
 }
 
-// redactLogString attempts to redact passwords from errors
-func redactLogString(in string) string {
-	re := regexp.MustCompile("([a-zA-Z]+://[^:]+:)[^@]+@")
+// flagOrFile returns the value of a string flag, or the contents of the file named by fileFlag
+func flagOrFile(c *cli.Context, flag, fileFlag string) (string, error) {
+	if c.IsSet(flag) && c.IsSet(fileFlag) {
+		return "", fmt.Errorf("--%s and --%s cannot be used together", flag, fileFlag)
+	}
+
+	if path := c.String(fileFlag); path != "" {
+		data, err := os.ReadFile(path)
+		return string(data), err
+	}
 
-	return re.ReplaceAllString(in, "${1}********@")
+	return c.String(flag), nil
 }
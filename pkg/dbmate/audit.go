@@ -0,0 +1,81 @@
+package dbmate
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRecord is a structured record of a dbmate operation
+type AuditRecord struct {
+	Time     string   `json:"time"`
+	Command  string   `json:"command"`
+	Database string   `json:"database"`
+	Versions []string `json:"versions"`
+	User     string   `json:"user"`
+	Host     string   `json:"host"`
+	Outcome  string   `json:"outcome"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// NewAuditRecord returns a record of command, which touched the migrations with the
+// given versions, for the current user and host. Credentials are removed from the
+// database URL and error message.
+func NewAuditRecord(command string, databaseURL *url.URL, versions []string, err error) AuditRecord {
+	record := AuditRecord{
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Command:  command,
+		Versions: append([]string{}, versions...),
+		Outcome:  AuditSuccess,
+	}
+	if databaseURL != nil {
+		record.Database = databaseURL.Redacted()
+	}
+	if err != nil {
+		record.Outcome = AuditFailure
+		record.Error = RedactString(err.Error())
+	}
+
+	if u, err := user.Current(); err == nil {
+		record.User = u.Username
+	} else {
+		record.User = os.Getenv("USER")
+	}
+	record.Host, _ = os.Hostname()
+
+	return record
+}
+
+// WriteAuditRecord appends record to the audit log at dest, which is either a file path
+// (written as one JSON object per line) or an http(s) URL which the record is posted to
+func WriteAuditRecord(dest string, record AuditRecord) error {
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		return postJSON(dest, record)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,43 @@
+package dbmate
+
+import (
+	"context"
+	"io/fs"
+	"net/url"
+)
+
+// AutoMigrate creates the database (if necessary) and applies all pending migrations read
+// from fsys, for applications which migrate their own database at startup. It waits for
+// the database to become available, and if the driver supports advisory locks, holds a
+// lock while migrating so that instances starting at the same time do not conflict.
+// Schema dumps are disabled unless re-enabled with WithAutoDumpSchema.
+func AutoMigrate(ctx context.Context, databaseURL *url.URL, fsys fs.FS, opts ...Option) error {
+	opts = append([]Option{WithFS(fsys), WithAutoDumpSchema(false)}, opts...)
+	db := NewWithOptions(databaseURL, opts...)
+	// the lock is held (waiting for other instances) around the whole run instead
+	db.LockMigrations = false
+
+	if err := db.WaitContext(ctx); err != nil {
+		return err
+	}
+
+	drv, err := db.driver(ctx)
+	if err != nil {
+		return err
+	}
+
+	// create database if it does not already exist, allowing for another instance
+	// creating it at the same time
+	exists, err := drv.DatabaseExists()
+	if err == nil && !exists {
+		if err := drv.CreateDatabase(); err != nil {
+			if exists, _ := drv.DatabaseExists(); !exists {
+				return err
+			}
+		}
+	}
+
+	return db.withLock(ctx, drv, func() error {
+		return db.MigrateContext(ctx)
+	})
+}
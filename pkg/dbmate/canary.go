@@ -0,0 +1,88 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrCanaryFailed is returned by MigrateTenants when the canary tenant fails to migrate
+// or fails verification, in which case no other tenants are migrated
+var ErrCanaryFailed = errors.New("canary failed, no other tenants were migrated")
+
+// withoutCanary returns the tenants other than canary, and whether canary was found
+func withoutCanary(tenants []string, canary string) ([]string, bool) {
+	rest := []string{}
+	found := false
+	for _, tenant := range tenants {
+		if tenant == canary {
+			found = true
+		} else {
+			rest = append(rest, tenant)
+		}
+	}
+
+	return rest, found
+}
+
+// verifyCanary runs TenantCanaryQuery and TenantCanaryCommand (if set) against the
+// database. The query fails verification if it returns any rows, and the command fails
+// if it exits with a non-zero status.
+func (db *DB) verifyCanary(ctx context.Context) error {
+	if db.TenantCanaryQuery != "" {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Verifying: %s\n", db.TenantCanaryQuery)
+		count, err := db.countRows(ctx, db.TenantCanaryQuery)
+		if err != nil {
+			return fmt.Errorf("canary query: %w", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("canary query returned %d rows", count)
+		}
+	}
+
+	if db.TenantCanaryCommand != "" {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Verifying: %s\n", db.TenantCanaryCommand)
+		cmd := exec.CommandContext(ctx, "sh", "-c", db.TenantCanaryCommand)
+		cmd.Env = append(os.Environ(),
+			"DATABASE_URL="+db.DatabaseURL.String(),
+			"DBMATE_TENANT="+db.TenantName())
+		cmd.Stdout = db.Log
+		cmd.Stderr = db.Log
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("canary command: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// countRows returns the number of rows returned by query
+func (db *DB) countRows(ctx context.Context, query string) (int, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return 0, err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	rows, err := sqlDB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer dbutil.MustClose(rows)
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+
+	return count, rows.Err()
+}
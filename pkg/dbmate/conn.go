@@ -0,0 +1,73 @@
+package dbmate
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// connCache holds the connection pool kept open between operations by ReuseConnections
+type connCache struct {
+	mu    sync.Mutex
+	sqlDB *sql.DB
+	// url is the database URL which sqlDB is connected to
+	url string
+}
+
+// open returns a connection pool for drv, and a function which must be called when the
+// caller has finished with it. If ReuseConnections is set, the pool is kept open and
+// shared with later operations until Close is called.
+func (db *DB) open(drv Driver) (*sql.DB, func(), error) {
+	// pools cannot refresh short-lived credentials, so are never reused
+	if !db.ReuseConnections || db.Credentials != nil || db.conn == nil {
+		sqlDB, err := drv.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return sqlDB, func() { dbutil.MustClose(sqlDB) }, nil
+	}
+
+	db.conn.mu.Lock()
+	defer db.conn.mu.Unlock()
+
+	url := db.DatabaseURL.String()
+	if db.conn.sqlDB != nil && db.conn.url == url {
+		return db.conn.sqlDB, func() {}, nil
+	}
+
+	// the database URL has changed since the pool was opened
+	if db.conn.sqlDB != nil {
+		dbutil.MustClose(db.conn.sqlDB)
+		db.conn.sqlDB = nil
+	}
+
+	sqlDB, err := drv.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	db.conn.sqlDB = sqlDB
+	db.conn.url = url
+
+	return sqlDB, func() {}, nil
+}
+
+// Close closes any connections kept open by ReuseConnections. The DB can still be used
+// after it is closed, and opens new connections as required.
+func (db *DB) Close() error {
+	if db.conn == nil {
+		return nil
+	}
+
+	db.conn.mu.Lock()
+	defer db.conn.mu.Unlock()
+
+	if db.conn.sqlDB == nil {
+		return nil
+	}
+
+	err := db.conn.sqlDB.Close()
+	db.conn.sqlDB = nil
+	return err
+}
@@ -0,0 +1,42 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// CredentialProvider supplies the credentials used to connect to the database, so that
+// short-lived credentials (such as Vault dynamic secrets or IAM tokens) can be fetched
+// when they are needed, instead of being stored in the database URL
+type CredentialProvider interface {
+	// Credentials returns the user name and password to connect to databaseURL with.
+	// It is called each time dbmate connects, including between attempts while waiting
+	// for the database to become available.
+	Credentials(ctx context.Context, databaseURL *url.URL) (*url.Userinfo, error)
+}
+
+// CredentialProviderFunc adapts an ordinary function to a CredentialProvider
+type CredentialProviderFunc func(ctx context.Context, databaseURL *url.URL) (*url.Userinfo, error)
+
+// Credentials calls f(ctx, databaseURL)
+func (f CredentialProviderFunc) Credentials(ctx context.Context, databaseURL *url.URL) (*url.Userinfo, error) {
+	return f(ctx, databaseURL)
+}
+
+// connectionURL returns the database URL to connect with, including credentials from
+// db.Credentials if it is set
+func (db *DB) connectionURL(ctx context.Context) (*url.URL, error) {
+	if db.Credentials == nil {
+		return db.DatabaseURL, nil
+	}
+
+	user, err := db.Credentials.Credentials(ctx, db.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCredentials, err)
+	}
+
+	u := *db.DatabaseURL
+	u.User = user
+	return &u, nil
+}
@@ -0,0 +1,226 @@
+package dbmate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrDataDumpUnsupported = errors.New("driver does not support data dumps")
+	ErrAnonymizeRule       = errors.New("invalid anonymize rule")
+)
+
+// Anonymize strategies, which replace column values in data dumps
+const (
+	// AnonymizeNull replaces values with NULL
+	AnonymizeNull = "null"
+	// AnonymizeMask replaces each character of a value with '*'
+	AnonymizeMask = "mask"
+	// AnonymizeHash replaces values with a hash, so that equal values remain equal
+	AnonymizeHash = "hash"
+	// AnonymizeEmail replaces values with a fake email address derived from a hash
+	AnonymizeEmail = "email"
+)
+
+// AnonymizeRules maps table names to the anonymize strategy for each of their columns
+type AnonymizeRules map[string]map[string]string
+
+// ParseAnonymizeRules parses anonymize rules from YAML, in the form:
+//
+//	users:
+//	  email: email
+//	  name: mask
+func ParseAnonymizeRules(data []byte) (AnonymizeRules, error) {
+	rules := AnonymizeRules{}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	for table, columns := range rules {
+		for column, strategy := range columns {
+			switch strategy {
+			case "":
+				// an unquoted null in YAML
+				columns[column] = AnonymizeNull
+			case AnonymizeNull, AnonymizeMask, AnonymizeHash, AnonymizeEmail:
+			default:
+				return nil, fmt.Errorf("%w: unknown strategy `%s` for %s.%s",
+					ErrAnonymizeRule, strategy, table, column)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// columns returns the rules for table, which may be named with or without its schema
+func (rules AnonymizeRules) columns(table string) (map[string]string, string) {
+	if columns, ok := rules[table]; ok {
+		return columns, table
+	}
+
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		name := table[i+1:]
+		if columns, ok := rules[name]; ok {
+			return columns, name
+		}
+	}
+
+	return nil, ""
+}
+
+// DumpData writes the database schema to w, followed by INSERT statements for the data
+// in each table (except the migrations table). Columns with a rule in rules are replaced
+// using its strategy. An error is returned, before anything is written, if a rule does
+// not match any table or column, so that a typo cannot leak the data it should replace.
+func (db *DB) DumpData(w io.Writer, rules AnonymizeRules) error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	lister, ok := drv.(TableLister)
+	if !ok {
+		return ErrDataDumpUnsupported
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	schema, migrations, err := db.dumpSchema(drv, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	tables, err := lister.Tables(sqlDB)
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	buf.Write(schema)
+	buf.Write(migrations)
+
+	matched := map[string]bool{}
+	for _, table := range tables {
+		if table == db.MigrationsTableName || strings.HasSuffix(table, "."+db.MigrationsTableName) {
+			continue
+		}
+
+		columns, name := rules.columns(table)
+		if columns != nil {
+			matched[name] = true
+		}
+		if err := dumpTableData(&buf, sqlDB, table, columns); err != nil {
+			return err
+		}
+	}
+
+	for table := range rules {
+		if !matched[table] {
+			return fmt.Errorf("%w: table %s does not exist", ErrAnonymizeRule, table)
+		}
+	}
+
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+// dumpTableData writes an INSERT statement for each row in table, replacing values in
+// columns which have an anonymize strategy
+func dumpTableData(w *strings.Builder, db dbutil.Transaction, table string, strategies map[string]string) error {
+	rows, err := db.Query("SELECT * FROM " + table)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+	for _, column := range columns {
+		found[column] = true
+	}
+	missing := []string{}
+	for column := range strategies {
+		if !found[column] {
+			missing = append(missing, table+"."+column)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: column %s does not exist", ErrAnonymizeRule, strings.Join(missing, ", "))
+	}
+
+	fmt.Fprintf(w, "\n--\n-- Data for %s\n--\n\n", table)
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	literals := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			literals[i] = sqlLiteral(anonymize(dataValue(value), strategies[columns[i]]))
+		}
+		fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES (%s);\n",
+			table, strings.Join(columns, ", "), strings.Join(literals, ", "))
+	}
+
+	return rows.Err()
+}
+
+// dataValue converts a value scanned from the database to one which sqlLiteral can format
+func dataValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999999Z07:00")
+	default:
+		return v
+	}
+}
+
+// anonymize replaces value using strategy, or returns it unchanged if strategy is empty.
+// NULL values are never replaced.
+func anonymize(value interface{}, strategy string) interface{} {
+	if value == nil || strategy == "" {
+		return value
+	}
+
+	text := fmt.Sprint(value)
+	sum := sha256.Sum256([]byte(text))
+	hash := hex.EncodeToString(sum[:])
+
+	switch strategy {
+	case AnonymizeMask:
+		return strings.Repeat("*", len([]rune(text)))
+	case AnonymizeHash:
+		return hash[:16]
+	case AnonymizeEmail:
+		return "user-" + hash[:12] + "@example.com"
+	default:
+		return nil
+	}
+}
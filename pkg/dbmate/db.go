@@ -1,17 +1,23 @@
 package dbmate
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 )
@@ -28,6 +34,27 @@ var (
 	ErrMigrationDirNotFound  = errors.New("could not find migrations directory")
 	ErrMigrationNotFound     = errors.New("can't find migration file")
 	ErrCreateDirectory       = errors.New("unable to create directory")
+	ErrEmptyDownBlock        = errors.New("dbmate requires each migration to define statements in its '-- migrate:down' block")
+	ErrLintFailed            = errors.New("lint failed")
+	ErrSchemaUnsupported     = errors.New("driver does not support the schema option")
+	ErrMissingDependency     = errors.New("required migration has not been applied")
+	ErrInvalidDependency     = errors.New("required migration does not precede this migration")
+	ErrProtectedURL          = errors.New("refusing to modify a protected database")
+	ErrConsoleUnsupported    = errors.New("driver does not support the console command")
+	ErrCredentials           = errors.New("unable to fetch database credentials")
+	ErrLockFailed            = errors.New("unable to acquire migration lock")
+	ErrOrphanedMigrations    = errors.New("applied migrations are missing from the migrations directory")
+	ErrDuplicateVersion      = errors.New("multiple migrations share the same version")
+	ErrVersionOutOfOrder     = errors.New("new migration version is out of order")
+	ErrMergedOutOfOrder      = errors.New("migration was merged after a migration with a later version")
+)
+
+// Orphan policies, which control how applied migrations without a migration file are
+// handled by Migrate and Status
+const (
+	OrphansIgnore = "ignore"
+	OrphansWarn   = "warn"
+	OrphansError  = "error"
 )
 
 // migrationFileRegexp pattern for valid migration files
@@ -35,21 +62,172 @@ var migrationFileRegexp = regexp.MustCompile(`^(\d+).*\.sql$`)
 
 // DB allows dbmate actions to be performed on a specified database
 type DB struct {
+	// AllowProtected permits destructive actions against databases matching ProtectedURLPatterns
+	AllowProtected bool
 	// AutoDumpSchema generates schema.sql after each action
 	AutoDumpSchema bool
+	// CheckDownBlocks syntax checks the down block of each migration after applying its up
+	// block, if the driver supports it, so that a broken rollback fails the deploy rather
+	// than an emergency revert
+	CheckDownBlocks bool
+	// Color highlights applied, pending and failed migrations in output
+	Color bool
+	// Credentials, if set, supplies the user name and password each time dbmate connects,
+	// replacing any credentials in DatabaseURL
+	Credentials CredentialProvider
 	// DatabaseURL is the database connection string
 	DatabaseURL *url.URL
 	// FS specifies the filesystem, or nil for OS filesystem
 	FS fs.FS
+	// FixturesDir specifies the directory containing fixture sets loaded by LoadFixtures
+	FixturesDir string
+	// GitCheck uses git to find migration version conflicts between branches: NewMigration
+	// warns if an unmerged branch contains a later migration, and Lint reports migrations
+	// which were merged after a migration with a later version
+	GitCheck bool
+	// GitBase, if set, limits the merged migrations reported by GitCheck to those added
+	// after this git revision (e.g. "origin/main")
+	GitBase string
+	// Source provides migration files, or nil to read them from FS
+	Source MigrationSource
+	// Tracker records applied migrations, or nil to record them in the migrations table
+	// of the target database
+	Tracker Tracker
+	// Hooks are called before and after each migration is applied or rolled back
+	Hooks Hooks
 	// Log is the interface to write stdout
 	Log io.Writer
+	// ImplicitCommitPolicy controls how Migrate and Rollback handle migration blocks which
+	// run in a transaction, but contain statements which commit it implicitly (one of the
+	// ImplicitCommit constants)
+	ImplicitCommitPolicy string
+	// LockTimeout, if set, limits how long each statement in a migration waits for a lock
+	// (postgres only), unless the migration block sets a lock_timeout option
+	LockTimeout time.Duration
+	// LockMigrations holds the migration lock while Migrate or Rollback runs, if the
+	// driver supports locking. If another process holds the lock, they fail with ErrLocked,
+	// which describes the holder, rather than waiting for it.
+	LockMigrations bool
+	// LogLevel is the minimum level of messages written to Log
+	LogLevel LogLevel
+	// MaxMigrationDuration, if set, is the longest a migration may run for, after which
+	// its running statement is cancelled and its transaction rolled back
+	MaxMigrationDuration time.Duration
+	// MigrationFilter, if set, is called for each pending migration, and excludes it from
+	// being applied if it returns false
+	MigrationFilter func(fileName string, meta Metadata) bool
 	// MigrationsDir specifies the directory or directories to find migration files
 	MigrationsDir []string
 	// MigrationsTableName specifies the database table to record migrations in
 	MigrationsTableName string
+	// OrphanPolicy controls how Migrate and Status handle versions recorded as applied
+	// which have no migration file (one of the Orphans constants)
+	OrphanPolicy string
+	// OnProgress is called after each migration is applied by Migrate
+	OnProgress func(MigrationProgress)
+	// Progress prints the number of applied migrations and estimated time remaining
+	Progress bool
+	// Preflight runs CheckPreflight against every target database (including each tenant
+	// database) before Migrate applies any migrations, so that permission errors are
+	// reported before the first migration rather than part way through a run
+	Preflight bool
+	// ReuseConnections keeps the connection pool open between operations, for long-running
+	// processes which use the same DB repeatedly. Call Close when the DB is no longer needed.
+	// Connections are not reused if Credentials is set.
+	ReuseConnections bool
+	// ProtectedURLPatterns are glob patterns (e.g. "*.prod.internal") matched against the
+	// database host, which block Drop and Rollback unless AllowProtected is set
+	ProtectedURLPatterns []string
+	// RequireDownBlock refuses to apply migrations which do not define a down block
+	RequireDownBlock bool
+	// StatementTimeout, if set, limits how long each statement in a migration runs for
+	// (postgres only), unless the migration block sets a statement_timeout option
+	StatementTimeout time.Duration
+	// Strict records the checksum of each applied migration, and refuses to migrate or
+	// rollback if the file of an applied migration has been modified since. It also makes
+	// NewMigration fail, rather than warn, if the new version is out of order.
+	Strict bool
 	// SchemaFile specifies the location for schema.sql file
 	SchemaFile string
-	// Verbose prints the result of each statement execution
+	// SchemaFormat controls the level of detail in schema dumps (one of the SchemaFormat
+	// constants), or "" for SchemaFormatDefault
+	SchemaFormat string
+	// IncludeSchemas lists Postgres schemas (in addition to those in the search_path) or
+	// MySQL databases (in addition to the one in the URL) to restrict schema dumps to
+	IncludeSchemas []string
+	// SchemaMigrationsFile, if set, is where the migrations table data is written when the
+	// schema is dumped, instead of appending it to SchemaFile
+	SchemaMigrationsFile string
+	// SchemaSnapshotDir, if set, is a directory where a copy of the schema is written after
+	// each migration is applied, named after the migration version (e.g. 20151127184807.sql)
+	SchemaSnapshotDir string
+	// StatusApplied restricts status output to applied migrations
+	StatusApplied bool
+	// StatusPending restricts status output to pending migrations
+	StatusPending bool
+	// StatusWide adds ticket, author and tags columns to status output
+	StatusWide bool
+	// SkipTags excludes migrations tagged with any of these tags from being applied
+	SkipTags []string
+	// Tags restricts applied migrations to those tagged with any of these tags
+	Tags []string
+	// SignatureKey is the public key file used by minisign, or the keyring used by gpg,
+	// to verify migration signatures
+	SignatureKey string
+	// Shard labels the shard which the database belongs to, so that migration blocks with
+	// a shard option are only executed against matching databases. If empty, the "shard"
+	// parameter of DatabaseURL is used.
+	Shard string
+	// TenantPattern is a glob pattern (e.g. "tenant_*") matching the names of the tenant
+	// databases on the server, which are migrated by MigrateTenants
+	TenantPattern string
+	// TenantTemplate is a database which CreateTenant keeps migrated, and copies to create
+	// new tenants
+	TenantTemplate string
+	// TenantWorkers is the number of tenant databases which MigrateTenants migrates (and
+	// DumpTenantSchemas dumps) at once (default 1)
+	TenantWorkers int
+	// TenantInterval is the minimum time between starting to migrate successive tenants,
+	// to limit the load on the database server
+	TenantInterval time.Duration
+	// TenantCanary is a tenant which MigrateTenants migrates and verifies before any
+	// other tenant
+	TenantCanary string
+	// TenantCanaryCommand is a shell command which verifies the canary after it is
+	// migrated, and fails verification if it exits with a non-zero status. It is run with
+	// DATABASE_URL set to the canary database URL.
+	TenantCanaryCommand string
+	// TenantCanaryQuery is a query which verifies the canary after it is migrated, and
+	// fails verification if it returns any rows
+	TenantCanaryQuery string
+	// TenantKeepGoing continues migrating the remaining tenants after a tenant fails
+	TenantKeepGoing bool
+	// TenantProgressFile records the outcome of migrating each tenant, so that a failed
+	// run can be resumed
+	TenantProgressFile string
+	// TenantRegisterQuery is run against the database in DatabaseURL by CreateTenant, with
+	// the tenant name as its only parameter, to register a new tenant
+	TenantRegisterQuery string
+	// TenantResume only migrates the tenants which TenantProgressFile does not record as
+	// migrated successfully
+	TenantResume bool
+	// TenantsFile is a file listing the names of the tenant databases, one per line
+	TenantsFile string
+	// TenantsQuery is a query, run against the database in DatabaseURL, which returns the
+	// names of the tenant databases
+	TenantsQuery string
+	// TransactionRetries is the number of times a migration transaction is retried if
+	// the driver reports a retryable error, such as a CockroachDB "restart transaction"
+	// error. Migrations which do not run in a transaction are never retried.
+	TransactionRetries int
+	// TransactionRetryInterval is the delay before the first retry of a migration
+	// transaction, which doubles after each retry
+	TransactionRetryInterval time.Duration
+	// VerifySignatures, if set, is the tool (one of the Signature constants) used to
+	// verify the detached signature of each migration file before it is applied or rolled
+	// back. Unsigned migrations are refused.
+	VerifySignatures string
+	// Verbose prints each executed statement with its result and execution time
 	Verbose bool
 	// WaitBefore will wait for database to become available before running any actions
 	WaitBefore bool
@@ -57,6 +235,42 @@ type DB struct {
 	WaitInterval time.Duration
 	// WaitTimeout specifies maximum time for connection attempts
 	WaitTimeout time.Duration
+
+	// conn is shared between operations by ReuseConnections
+	conn *connCache
+	// migrations caches migration files while a command runs
+	migrations *migrationCache
+}
+
+// MigrationProgress describes the progress of a Migrate run
+type MigrationProgress struct {
+	// Migration is the migration which was just applied
+	Migration Migration
+	// Applied is the number of migrations applied so far in this run
+	Applied int
+	// Total is the number of migrations being applied in this run
+	Total int
+	// Elapsed is the time since the run started applying migrations
+	Elapsed time.Duration
+	// ETA is the estimated time remaining, based on the average time per migration
+	ETA time.Duration
+}
+
+// MigrateResult reports the outcome of a Migrate run
+type MigrateResult struct {
+	// Applied lists the migrations which were applied, in order
+	Applied []MigrationResult
+	// Skipped is the number of pending migrations excluded by Tags, SkipTags or MigrationFilter
+	Skipped int
+	// Elapsed is the total time spent applying migrations
+	Elapsed time.Duration
+}
+
+// MigrationResult describes a single migration which was applied or rolled back
+type MigrationResult struct {
+	Version  string
+	FileName string
+	Duration time.Duration
 }
 
 // StatusResult represents an available migration status
@@ -68,89 +282,147 @@ type StatusResult struct {
 // New initializes a new dbmate database
 func New(databaseURL *url.URL) *DB {
 	return &DB{
-		AutoDumpSchema:      true,
-		DatabaseURL:         databaseURL,
-		FS:                  nil,
-		Log:                 os.Stdout,
-		MigrationsDir:       []string{"./db/migrations"},
-		MigrationsTableName: "schema_migrations",
-		SchemaFile:          "./db/schema.sql",
-		Verbose:             false,
-		WaitBefore:          false,
-		WaitInterval:        time.Second,
-		WaitTimeout:         60 * time.Second,
+		AutoDumpSchema:           true,
+		DatabaseURL:              databaseURL,
+		FS:                       nil,
+		FixturesDir:              "./db/fixtures",
+		ImplicitCommitPolicy:     ImplicitCommitWarn,
+		Log:                      os.Stdout,
+		LogLevel:                 LogLevelInfo,
+		MigrationsDir:            []string{"./db/migrations"},
+		MigrationsTableName:      "schema_migrations",
+		OrphanPolicy:             OrphansIgnore,
+		SchemaFile:               "./db/schema.sql",
+		TransactionRetries:       5,
+		TransactionRetryInterval: 100 * time.Millisecond,
+		Verbose:                  false,
+		WaitBefore:               false,
+		WaitInterval:             time.Second,
+		WaitTimeout:              60 * time.Second,
+		conn:                     &connCache{},
 	}
 }
 
 // Driver initializes the appropriate database driver
 func (db *DB) Driver() (Driver, error) {
+	return db.driver(context.Background())
+}
+
+func (db *DB) driver(ctx context.Context) (Driver, error) {
+	drv, err := db.newDriver(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.WaitBefore {
+		if drv, err = db.wait(ctx, drv); err != nil {
+			return nil, err
+		}
+	}
+
+	return drv, nil
+}
+
+// newDriver initializes the driver for DatabaseURL, with credentials from db.Credentials
+func (db *DB) newDriver(ctx context.Context) (Driver, error) {
 	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
 		return nil, ErrInvalidURL
 	}
 
-	driverFunc := drivers[db.DatabaseURL.Scheme]
-	if driverFunc == nil {
+	driverFunc, ok := GetDriverFunc(db.DatabaseURL.Scheme)
+	if !ok {
 		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, db.DatabaseURL.Scheme)
 	}
 
-	config := DriverConfig{
-		DatabaseURL:         db.DatabaseURL,
-		Log:                 db.Log,
-		MigrationsTableName: db.MigrationsTableName,
+	u, err := db.connectionURL(ctx)
+	if err != nil {
+		return nil, err
 	}
-	drv := driverFunc(config)
 
-	if db.WaitBefore {
-		if err := db.wait(drv); err != nil {
-			return nil, err
-		}
+	config := DriverConfig{
+		DatabaseURL:         withoutShard(u),
+		Log:                 db.logger(LogLevelInfo),
+		MigrationsTableName: db.MigrationsTableName,
+		SchemaFormat:        db.SchemaFormat,
+		IncludeSchemas:      db.IncludeSchemas,
 	}
 
-	return drv, nil
+	return driverFunc(config), nil
 }
 
-func (db *DB) wait(drv Driver) error {
+// wait blocks until drv can connect to the database server, and returns the driver which
+// connected. Credentials are refreshed before each attempt if db.Credentials is set.
+func (db *DB) wait(ctx context.Context, drv Driver) (Driver, error) {
 	// attempt connection to database server
 	err := drv.Ping()
 	if err == nil {
 		// connection successful
-		return nil
+		return drv, nil
 	}
 
-	fmt.Fprint(db.Log, "Waiting for database")
+	log := db.logger(LogLevelInfo)
+	fmt.Fprint(log, "Waiting for database")
 	for i := 0 * time.Second; i < db.WaitTimeout; i += db.WaitInterval {
-		fmt.Fprint(db.Log, ".")
-		time.Sleep(db.WaitInterval)
+		fmt.Fprint(log, ".")
+		flushLog(log)
+		select {
+		case <-ctx.Done():
+			fmt.Fprint(log, "\n")
+			return nil, ctx.Err()
+		case <-time.After(db.WaitInterval):
+		}
+
+		// short-lived credentials may have expired while waiting
+		if db.Credentials != nil {
+			next, credErr := db.newDriver(ctx)
+			if credErr != nil {
+				err = credErr
+				continue
+			}
+			drv = next
+		}
 
 		// attempt connection to database server
 		err = drv.Ping()
 		if err == nil {
 			// connection successful
-			fmt.Fprint(db.Log, "\n")
-			return nil
+			fmt.Fprint(log, "\n")
+			return drv, nil
 		}
 	}
 
 	// if we find outselves here, we could not connect within the timeout
-	fmt.Fprint(db.Log, "\n")
-	return fmt.Errorf("%w: %s", ErrCantConnect, err)
+	fmt.Fprint(log, "\n")
+	return nil, fmt.Errorf("%w: %w", ErrCantConnect, err)
 }
 
 // Wait blocks until the database server is available. It does not verify that
 // the specified database exists, only that the host is ready to accept connections.
 func (db *DB) Wait() error {
-	drv, err := db.Driver()
+	return db.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done
+func (db *DB) WaitContext(ctx context.Context) error {
+	drv, err := db.driver(ctx)
 	if err != nil {
 		return err
 	}
 
 	// if db.WaitBefore is true, wait() will get called twice, no harm
-	return db.wait(drv)
+	_, err = db.wait(ctx, drv)
+	return err
 }
 
 // CreateAndMigrate creates the database (if necessary) and runs migrations
 func (db *DB) CreateAndMigrate() error {
-	drv, err := db.Driver()
+	return db.CreateAndMigrateContext(context.Background())
+}
+
+// CreateAndMigrateContext is like CreateAndMigrate, but stops applying migrations when
+// ctx is done
+func (db *DB) CreateAndMigrateContext(ctx context.Context) error {
+	drv, err := db.driver(ctx)
 	if err != nil {
 		return err
 	}
@@ -166,7 +438,7 @@ func (db *DB) CreateAndMigrate() error {
 	}
 
 	// migrate
-	return db.Migrate()
+	return db.MigrateContext(ctx)
 }
 
 // Create creates the current database
@@ -181,14 +453,68 @@ func (db *DB) Create() error {
 
 // Drop drops the current database (if it exists)
 func (db *DB) Drop() error {
+	if err := db.checkProtected(); err != nil {
+		return err
+	}
+
 	drv, err := db.Driver()
 	if err != nil {
 		return err
 	}
 
+	// connections kept open by ReuseConnections would prevent the database being dropped
+	if err := db.Close(); err != nil {
+		return err
+	}
+
 	return drv.DropDatabase()
 }
 
+// checkProtected returns an error if the database host matches any of the ProtectedURLPatterns
+func (db *DB) checkProtected() error {
+	if db.AllowProtected || db.DatabaseURL == nil {
+		return nil
+	}
+
+	host := strings.ToLower(db.DatabaseURL.Hostname())
+	for _, pattern := range db.ProtectedURLPatterns {
+		matched, err := path.Match(strings.ToLower(pattern), host)
+		if err != nil {
+			return fmt.Errorf("invalid protected url pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return fmt.Errorf("%w: %s matches %q", ErrProtectedURL, host, pattern)
+		}
+	}
+
+	return nil
+}
+
+// Console opens the native command line client for the current database,
+// attached to the current process stdin, stdout and stderr
+func (db *DB) Console() error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	consoler, ok := drv.(Consoler)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrConsoleUnsupported, db.DatabaseURL.Scheme)
+	}
+
+	cmd, err := consoler.ConsoleCommand()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
 // DumpSchema writes the current database schema to a file
 func (db *DB) DumpSchema() error {
 	drv, err := db.Driver()
@@ -196,26 +522,85 @@ func (db *DB) DumpSchema() error {
 		return err
 	}
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	sqlDB, release, err := db.openDatabaseForMigration(drv)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer release()
+
+	return db.writeSchema(drv, sqlDB)
+}
 
-	schema, err := drv.DumpSchema(sqlDB)
+// writeSchema writes the schema file (and schema migrations file, if set) using sqlDB,
+// which allows a command to dump the schema on the connections it already has open
+func (db *DB) writeSchema(drv Driver, sqlDB *sql.DB) error {
+	schema, migrations, err := db.dumpSchema(drv, sqlDB)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(db.Log, "Writing: %s\n", db.SchemaFile)
+	if err := db.writeSchemaFile(db.SchemaFile, schema); err != nil {
+		return err
+	}
+
+	if db.SchemaMigrationsFile == "" {
+		return nil
+	}
+
+	return db.writeSchemaFile(db.SchemaMigrationsFile, migrations)
+}
+
+// writeSchemaFile writes data to the file at path, unless the file already contains it,
+// so that its modification time only changes when the schema does
+func (db *DB) writeSchemaFile(path string, data []byte) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Unchanged: %s\n", path)
+		return nil
+	}
+
+	fmt.Fprintf(db.logger(LogLevelInfo), "Writing: %s\n", path)
 
 	// ensure schema directory exists
-	if err = ensureDir(filepath.Dir(db.SchemaFile)); err != nil {
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// writeSchemaSnapshot writes the current schema to SchemaSnapshotDir, after the migration
+// with the given version has been applied
+func (db *DB) writeSchemaSnapshot(drv Driver, sqlDB *sql.DB, version string) error {
+	schema, _, err := db.dumpSchema(drv, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDir(db.SchemaSnapshotDir); err != nil {
 		return err
 	}
 
-	// write schema to file
-	return os.WriteFile(db.SchemaFile, schema, 0o644)
+	path := filepath.Join(db.SchemaSnapshotDir, version+".sql")
+	fmt.Fprintf(db.logger(LogLevelInfo), "Writing: %s\n", path)
+	return os.WriteFile(path, schema, 0o644)
+}
+
+// CurrentSchema returns the current database schema, in the same format as the schema file
+// (so without the migrations table data, if SchemaMigrationsFile is set)
+func (db *DB) CurrentSchema() ([]byte, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	schema, _, err := db.dumpSchema(drv, sqlDB)
+	return schema, err
 }
 
 // ensureDir creates a directory if it does not already exist
@@ -227,10 +612,15 @@ func ensureDir(dir string) error {
 	return nil
 }
 
-const migrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
+const migrationTemplate = "-- migrate:up\n%s\n\n-- migrate:down\n%s\n"
 
 // NewMigration creates a new migration file
 func (db *DB) NewMigration(name string) error {
+	return db.NewMigrationWithContents(name, "", "")
+}
+
+// NewMigrationWithContents creates a new migration file, prefilling the up and down blocks
+func (db *DB) NewMigrationWithContents(name, up, down string) error {
 	// new migration name
 	timestamp := time.Now().UTC().Format("20060102150405")
 	if name == "" {
@@ -243,9 +633,16 @@ func (db *DB) NewMigration(name string) error {
 		return err
 	}
 
+	if err := db.checkNewVersion(timestamp); err != nil {
+		return err
+	}
+	if db.GitCheck {
+		db.checkBranchVersions(timestamp)
+	}
+
 	// check file does not already exist
 	path := filepath.Join(db.MigrationsDir[0], name)
-	fmt.Fprintf(db.Log, "Creating migration: %s\n", path)
+	fmt.Fprintf(db.logger(LogLevelInfo), "Creating migration: %s\n", path)
 
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		return ErrMigrationAlreadyExist
@@ -258,18 +655,50 @@ func (db *DB) NewMigration(name string) error {
 	}
 
 	defer dbutil.MustClose(file)
-	_, err = file.WriteString(migrationTemplate)
+	_, err = fmt.Fprintf(file, migrationTemplate, strings.TrimSpace(up), strings.TrimSpace(down))
 	return err
 }
 
-func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
-	tx, err := sqlDB.Begin()
+// checkNewVersion warns (or in strict mode, fails) if the version of a new migration does
+// not sort after every existing migration, which usually means that the system clock is
+// wrong. The new migration would be applied out of order, or not at all by databases
+// which have already applied a later migration.
+func (db *DB) checkNewVersion(version string) error {
+	migrations, err := db.listMigrationFiles()
+	if err != nil {
+		return nil
+	}
+
+	// versions which are not timestamps cannot be compared
+	var newest *Migration
+	for i, migration := range migrations {
+		if len(migration.Version) == len(version) && (newest == nil || migration.Version > newest.Version) {
+			newest = &migrations[i]
+		}
+	}
+	if newest == nil || newest.Version < version {
+		return nil
+	}
+
+	err = fmt.Errorf("%w: %s does not sort after the newest migration %s, check the system clock",
+		ErrVersionOutOfOrder, version, newest.FileName)
+	if db.Strict {
+		return err
+	}
+	fmt.Fprintf(db.logger(LogLevelWarn), "Warning: %s\n", err)
+
+	return nil
+}
+
+func doTransaction(ctx context.Context, sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	if err := txFunc(tx); err != nil {
-		if err1 := tx.Rollback(); err1 != nil {
+	if err := txFunc(contextTransaction{ctx, tx}); err != nil {
+		// the transaction has already been rolled back if ctx is done
+		if err1 := tx.Rollback(); err1 != nil && !errors.Is(err1, sql.ErrTxDone) {
 			return err1
 		}
 
@@ -279,153 +708,697 @@ func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
 	return tx.Commit()
 }
 
-func (db *DB) openDatabaseForMigration(drv Driver) (*sql.DB, error) {
-	sqlDB, err := drv.Open()
+// doConnection runs a function on a single connection from the pool, so that session
+// state (such as the current schema) is shared between statements. If the function
+// fails, the connection is discarded rather than returned to the pool, since its session
+// state may not have been restored.
+func doConnection(ctx context.Context, sqlDB *sql.DB, connFunc func(dbutil.Transaction) error) error {
+	conn, err := sqlDB.Conn(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if err := connFunc(contextTransaction{ctx, conn}); err != nil {
+		// returning driver.ErrBadConn from Raw closes the underlying connection
+		_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		return err
+	}
+
+	return conn.Close()
+}
+
+// contextConn is implemented by both *sql.Conn and *sql.Tx
+type contextConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// contextTransaction adapts a database connection or transaction to the dbutil.Transaction
+// interface, so that each statement is cancelled when ctx is done
+type contextTransaction struct {
+	ctx  context.Context
+	conn contextConn
+}
+
+func (c contextTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c contextTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+// switchSchema switches the default schema for the remainder of a migration block if the
+// block specifies a schema option, and returns a function which restores the previous
+// schema. The function only restores it once, so it can be deferred to restore the schema
+// if the block fails, as well as called to check for errors once the block succeeds.
+func switchSchema(drv Driver, tx dbutil.Transaction, options ParsedMigrationOptions) (func() error, error) {
+	schema := options.Schema()
+	if schema == "" {
+		return func() error { return nil }, nil
 	}
 
-	if err := drv.CreateMigrationsTable(sqlDB); err != nil {
-		dbutil.MustClose(sqlDB)
+	switcher, ok := drv.(SchemaSwitcher)
+	if !ok {
+		return nil, ErrSchemaUnsupported
+	}
+
+	restore, err := switcher.SwitchSchema(tx, schema)
+	if err != nil {
 		return nil, err
 	}
 
-	return sqlDB, nil
+	return restoreOnce(restore), nil
+}
+
+// restoreOnce wraps a function which restores session state, so that it only runs the
+// first time it is called
+func restoreOnce(restore func() error) func() error {
+	done := false
+	return func() error {
+		if done {
+			return nil
+		}
+		done = true
+		return restore()
+	}
+}
+
+// tracker returns the Tracker which records applied migrations
+func (db *DB) tracker(drv Driver) Tracker {
+	if db.Tracker != nil {
+		return db.Tracker
+	}
+
+	return drv
+}
+
+func (db *DB) openDatabaseForMigration(drv Driver) (*sql.DB, func(), error) {
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := db.tracker(drv).CreateMigrationsTable(sqlDB); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return sqlDB, release, nil
 }
 
 // Migrate migrates database to the latest version
 func (db *DB) Migrate() error {
-	drv, err := db.Driver()
+	return db.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate, but stops applying migrations when ctx is done. A
+// migration which is interrupted is rolled back if it runs inside a transaction.
+func (db *DB) MigrateContext(ctx context.Context) error {
+	_, err := db.MigrateWithResult(ctx)
+	return err
+}
+
+// MigrateWithResult is like MigrateContext, but also returns a report of the migrations
+// which were applied. If a migration fails, the result lists the migrations which were
+// applied before it.
+func (db *DB) MigrateWithResult(ctx context.Context) (*MigrateResult, error) {
+	db = db.withMigrationCache()
+	drv, err := db.driver(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if db.Preflight {
+		if err := db.CheckPreflight(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.LockMigrations {
+		unlock, err := db.lock(ctx, drv, false)
+		if err != nil {
+			return nil, err
+		}
+		// the lock is also released when its connection is closed, so errors are ignored
+		defer func() { _ = unlock() }()
 	}
 
-	migrations, err := db.FindMigrations()
+	// the same connections are used for the whole command, including the schema dump
+	sqlDB, release, err := db.open(drv)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer release()
+
+	migrations, orphans, err := db.findMigrationsWith(drv, sqlDB)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(migrations) == 0 {
-		return ErrNoMigrationFiles
+		return nil, ErrNoMigrationFiles
+	}
+
+	if err := db.checkOrphans(orphans); err != nil {
+		return nil, err
 	}
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	// parse and check all pending migrations before applying any of them
+	pending, err := db.pendingMigrations(migrations)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, migration := range pending {
+		parsed := migration.parsed
+		if err := db.checkImplicitCommit(drv, migration.FileName, parsed.Up, parsed.UpOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	signed := make([]Migration, len(pending))
+	for i, migration := range pending {
+		signed[i] = migration.Migration
+	}
+	if err := db.verifySignatures(signed); err != nil {
+		return nil, err
 	}
-	defer dbutil.MustClose(sqlDB)
 
+	result := &MigrateResult{Applied: []MigrationResult{}}
 	for _, migration := range migrations {
-		if migration.Applied {
-			continue
+		if !migration.Applied {
+			result.Skipped++
 		}
+	}
+	result.Skipped -= len(pending)
 
-		fmt.Fprintf(db.Log, "Applying: %s\n", migration.FileName)
+	if err := db.tracker(drv).CreateMigrationsTable(sqlDB); err != nil {
+		return result, err
+	}
 
-		parsed, err := migration.Parse()
-		if err != nil {
-			return err
+	if db.Strict {
+		if err := db.verifyChecksums(drv, sqlDB, migrations); err != nil {
+			return result, err
+		}
+	}
+
+	start := time.Now()
+	for i, migration := range pending {
+		if err := ctx.Err(); err != nil {
+			return result, err
 		}
 
+		fmt.Fprintln(db.logger(LogLevelInfo), db.colorize(ColorGreen, "Applying: "+migration.FileName))
+		event := MigrationEvent{Version: migration.Version, FileName: migration.FileName, Direction: DirectionUp}
+		db.Hooks.before(event)
+		migrationStart := time.Now()
+
+		parsed := migration.parsed
+		migrationCtx, guard := db.guardMigration(ctx, drv, sqlDB)
 		execMigration := func(tx dbutil.Transaction) error {
-			// run actual migration
-			result, err := tx.Exec(parsed.Up)
+			if err := guard.watch(tx); err != nil {
+				return err
+			}
+
+			// the session must be restored even if the migration fails, since a connection
+			// used outside of a transaction is returned to the pool
+			restoreSchema, err := switchSchema(drv, tx, parsed.UpOptions)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = restoreSchema() }()
+
+			restoreTimeouts, err := db.setTimeouts(drv, tx, parsed.UpOptions)
 			if err != nil {
 				return err
-			} else if db.Verbose {
-				db.printVerbose(result)
+			}
+			defer func() { _ = restoreTimeouts() }()
+
+			// run actual migration
+			if !db.matchesShard(parsed.UpOptions) {
+				fmt.Fprintf(db.logger(LogLevelInfo), "Skipping: %s\n", skippedShardMessage(migration.FileName, parsed.UpOptions))
+			} else if parsed.UpOptions.Stream() && migration.goMigration == nil {
+				if err := db.execStreamedMigration(drv, tx, migration.Migration); err != nil {
+					return &MigrationError{FileName: migration.FileName, Err: err}
+				}
+			} else if err := db.runMigration(tx, migration.Migration, parsed.Up, DirectionUp); err != nil {
+				return &MigrationError{FileName: migration.FileName, Err: err}
+			}
+
+			if err := restoreTimeouts(); err != nil {
+				return err
+			}
+			if err := restoreSchema(); err != nil {
+				return err
+			}
+
+			if db.CheckDownBlocks {
+				if err := db.checkDownBlock(drv, tx, migration.Migration, parsed); err != nil {
+					if parsed.UpOptions.Transaction() {
+						return err
+					}
+					// the up block has been applied, and cannot be rolled back
+					fmt.Fprintf(db.logger(LogLevelWarn), "Warning: %s\n", err)
+				}
+			}
+
+			if db.Strict {
+				if err := db.recordChecksum(drv, tx, migration.Migration); err != nil {
+					return err
+				}
 			}
 
 			// record migration
-			return drv.InsertMigration(tx, migration.Version)
+			return db.tracker(drv).InsertMigration(tx, migration.Version)
 		}
 
 		if parsed.UpOptions.Transaction() {
 			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
+			err = db.retryTransaction(migrationCtx, drv, sqlDB, execMigration)
 		} else {
 			// run outside of transaction
-			err = execMigration(sqlDB)
+			err = doConnection(migrationCtx, sqlDB, execMigration)
 		}
+		err = guard.stop(err)
 
+		event.Duration = time.Since(migrationStart)
+		db.Hooks.after(event, err)
+		result.Elapsed = time.Since(start)
 		if err != nil {
-			return err
+			return result, err
+		}
+
+		result.Applied = append(result.Applied, MigrationResult{
+			Version:  migration.Version,
+			FileName: migration.FileName,
+			Duration: event.Duration,
+		})
+		db.reportProgress(migration.Migration, i+1, len(pending), time.Since(start))
+
+		if db.SchemaSnapshotDir != "" {
+			if err := db.writeSchemaSnapshot(drv, sqlDB, migration.Version); err != nil {
+				fmt.Fprintf(db.logger(LogLevelWarn), "Warning: unable to write schema snapshot: %s\n", err)
+			}
 		}
 	}
 
 	// automatically update schema file, silence errors
-	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
+	if db.AutoDumpSchema && len(result.Applied) > 0 {
+		_ = db.writeSchema(drv, sqlDB)
 	}
 
-	return nil
+	return result, nil
 }
 
-func (db *DB) printVerbose(result sql.Result) {
-	lastInsertID, err := result.LastInsertId()
-	if err == nil {
-		fmt.Fprintf(db.Log, "Last insert ID: %d\n", lastInsertID)
+// reportProgress prints and reports progress after a migration is applied
+func (db *DB) reportProgress(migration Migration, applied, total int, elapsed time.Duration) {
+	if !db.Progress && db.OnProgress == nil {
+		return
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err == nil {
-		fmt.Fprintf(db.Log, "Rows affected: %d\n", rowsAffected)
+
+	progress := MigrationProgress{
+		Migration: migration,
+		Applied:   applied,
+		Total:     total,
+		Elapsed:   elapsed,
+		ETA:       elapsed / time.Duration(applied) * time.Duration(total-applied),
 	}
-}
 
-func (db *DB) readMigrationsDir(dir string) ([]fs.DirEntry, error) {
-	path := filepath.Clean(dir)
+	if db.Progress {
+		line := fmt.Sprintf("Progress: %d/%d applied", progress.Applied, progress.Total)
+		if progress.Applied < progress.Total {
+			line += fmt.Sprintf(", ETA %s", progress.ETA.Round(time.Second))
+		}
+		fmt.Fprintln(db.logger(LogLevelInfo), line)
+	}
 
-	// We use nil instead of os.DirFS() because DirFS cannot support both relative and absolute
-	// directory paths - it must be anchored at either "." or "/", which we do not know in advance.
-	// See: https://github.com/amacneil/dbmate/issues/403
-	if db.FS == nil {
-		return os.ReadDir(path)
+	if db.OnProgress != nil {
+		db.OnProgress(progress)
 	}
+}
 
-	return fs.ReadDir(db.FS, path)
+// pendingMigration is a parsed migration which is about to be applied
+type pendingMigration struct {
+	Migration
+	parsed *ParsedMigration
 }
 
-// FindMigrations lists all available migrations
-func (db *DB) FindMigrations() ([]Migration, error) {
+// pendingMigrations parses and checks the migrations which have not been applied and
+// pass the Tags, SkipTags and MigrationFilter filters
+func (db *DB) pendingMigrations(migrations []Migration) ([]pendingMigration, error) {
+	pending := []pendingMigration{}
+	for _, migration := range migrations {
+		if migration.Applied {
+			continue
+		}
+
+		parsed, err := migration.Parse()
+		if err != nil {
+			return nil, err
+		}
+
+		if !db.matchesTags(parsed.upTags()) || !db.matchesFilter(migration, parsed) {
+			fmt.Fprintln(db.logger(LogLevelInfo), db.colorize(ColorYellow, "Skipping: "+migration.FileName))
+			continue
+		}
+
+		if err := db.checkMigration(migration, parsed); err != nil {
+			return nil, fmt.Errorf("%s: %w", migration.FileName, err)
+		}
+
+		pending = append(pending, pendingMigration{Migration: migration, parsed: parsed})
+	}
+
+	if err := checkDependencies(migrations, pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// checkDependencies verifies that the prerequisites declared by each pending migration
+// are either already applied, or will be applied earlier in the same run
+func checkDependencies(migrations []Migration, pending []pendingMigration) error {
+	available := map[string]bool{}
+	for _, migration := range migrations {
+		if migration.Applied {
+			available[migration.Version] = true
+		}
+	}
+
+	for _, migration := range pending {
+		for _, version := range migration.parsed.Metadata.Requires {
+			if !available[version] {
+				return fmt.Errorf("%s: %w: %s", migration.FileName, ErrMissingDependency, version)
+			}
+		}
+		available[migration.Version] = true
+	}
+
+	return nil
+}
+
+// checkMigration enforces migration policies on a parsed migration
+func (db *DB) checkMigration(migration Migration, parsed *ParsedMigration) error {
+	if migration.goMigration != nil {
+		if db.RequireDownBlock && migration.goMigration.down == nil {
+			return ErrEmptyDownBlock
+		}
+		return nil
+	}
+
+	if db.RequireDownBlock && !blockHasStatements(parsed.Down) {
+		return ErrEmptyDownBlock
+	}
+
+	for _, options := range []ParsedMigrationOptions{parsed.UpOptions, parsed.DownOptions} {
+		if _, err := db.blockTimeouts(options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lint parses all migration files and checks them against migration policies,
+// without connecting to the database
+func (db *DB) Lint() error {
+	migrations, err := db.findMigrationFiles()
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		return ErrNoMigrationFiles
+	}
+
+	merged := map[string]error{}
+	if db.GitCheck {
+		if merged, err = db.mergedOutOfOrder(); err != nil {
+			return err
+		}
+	}
+
+	problems := 0
+	versions := map[string]bool{}
+	for _, migration := range migrations {
+		parsed, err := migration.Parse()
+		if err == nil {
+			err = db.checkMigration(migration, parsed)
+		}
+		if err == nil {
+			err = merged[migration.FileName]
+		}
+		if err == nil {
+			// prerequisites must sort before the migration that requires them
+			for _, version := range parsed.Metadata.Requires {
+				if !versions[version] {
+					err = fmt.Errorf("%w: %s", ErrInvalidDependency, version)
+					break
+				}
+			}
+		}
+		versions[migration.Version] = true
+
+		if err != nil {
+			location := migration.FilePath
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) && parseErr.Line > 0 {
+				location = fmt.Sprintf("%s:%d", location, parseErr.Line)
+			}
+			fmt.Fprintln(db.logger(LogLevelError), db.colorize(ColorRed, fmt.Sprintf("%s: %s", location, err)))
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: %d problem(s) found", ErrLintFailed, problems)
+	}
+
+	return nil
+}
+
+// matchesFilter returns whether a migration passes the MigrationFilter
+func (db *DB) matchesFilter(migration Migration, parsed *ParsedMigration) bool {
+	if db.MigrationFilter == nil {
+		return true
+	}
+
+	return db.MigrationFilter(migration.FileName, parsed.Metadata)
+}
+
+// matchesTags returns whether a migration with the given tags passes the Tags and SkipTags filters
+func (db *DB) matchesTags(tags []string) bool {
+	if len(db.Tags) > 0 && !containsAny(tags, db.Tags) {
+		return false
+	}
+
+	return !containsAny(tags, db.SkipTags)
+}
+
+func containsAny(values []string, search []string) bool {
+	for _, v := range values {
+		for _, s := range search {
+			if v == s {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// runMigration runs the function of a Go migration in the given direction, or executes
+// block for a migration file
+func (db *DB) runMigration(tx dbutil.Transaction, migration Migration, block, direction string) error {
+	if migration.goMigration != nil {
+		return migration.goMigration.exec(tx, direction)
+	}
+
+	return db.execMigration(tx, block)
+}
+
+// execMigration executes the SQL in a migration block. In verbose mode, the block is
+// echoed, followed by its result and execution time. The block is executed whole in both
+// modes, since splitting it into statements is not safe for every driver (e.g. MySQL
+// conditional comments), and verbose mode must not change which SQL runs.
+func (db *DB) execMigration(tx dbutil.Transaction, block string) error {
+	block, err := db.expandTenant(block)
+	if err != nil {
+		return err
+	}
+
+	if db.Verbose {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Executing: %s\n", strings.TrimSpace(block))
+	}
+	start := time.Now()
+	result, err := tx.Exec(block)
+	if err != nil {
+		return err
+	}
+	if db.Verbose {
+		db.printVerbose(result, time.Since(start))
+	}
+
+	return nil
+}
+
+func (db *DB) printVerbose(result sql.Result, elapsed time.Duration) {
+	lastInsertID, err := result.LastInsertId()
+	if err == nil {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Last insert ID: %d\n", lastInsertID)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err == nil {
+		fmt.Fprintf(db.logger(LogLevelInfo), "Rows affected: %d\n", rowsAffected)
+	}
+	fmt.Fprintf(db.logger(LogLevelInfo), "Duration: %s\n", elapsed.Round(time.Microsecond))
+}
+
+// source returns the MigrationSource which migrations are read from
+func (db *DB) source() MigrationSource {
+	if db.Source != nil {
+		return db.Source
+	}
+
+	return NewFSSource(db.FS)
+}
+
+// FindMigrations lists all available migrations
+func (db *DB) FindMigrations() ([]Migration, error) {
+	migrations, _, err := db.findMigrations()
+	return migrations, err
+}
+
+// checkOrphans applies OrphanPolicy to the versions recorded as applied which have no
+// migration file
+func (db *DB) checkOrphans(orphans []string) error {
+	switch db.OrphanPolicy {
+	case "", OrphansIgnore:
+		return nil
+	case OrphansWarn:
+		if len(orphans) > 0 {
+			fmt.Fprintf(db.logger(LogLevelWarn), "Warning: %s: %s (check for a bad checkout or the wrong branch)\n",
+				ErrOrphanedMigrations, strings.Join(orphans, ", "))
+		}
+		return nil
+	case OrphansError:
+		if len(orphans) > 0 {
+			return fmt.Errorf("%w: %s (check for a bad checkout or the wrong branch)",
+				ErrOrphanedMigrations, strings.Join(orphans, ", "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported orphan policy: %s", db.OrphanPolicy)
+	}
+}
+
+// FindOrphanedMigrations lists the versions recorded as applied in the database
+// which have no corresponding migration file
+func (db *DB) FindOrphanedMigrations() ([]string, error) {
+	_, orphans, err := db.findMigrations()
+	return orphans, err
+}
+
+// findMigrations lists all available migrations, along with any applied versions
+// which are missing from the migrations directory
+func (db *DB) findMigrations() ([]Migration, []string, error) {
 	drv, err := db.Driver()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	sqlDB, err := drv.Open()
+	sqlDB, release, err := db.open(drv)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer release()
+
+	return db.findMigrationsWith(drv, sqlDB)
+}
 
+// findMigrationsWith is like findMigrations, but reads the applied migrations using sqlDB
+func (db *DB) findMigrationsWith(drv Driver, sqlDB *sql.DB) ([]Migration, []string, error) {
 	// find applied migrations
 	appliedMigrations := map[string]bool{}
-	migrationsTableExists, err := drv.MigrationsTableExists(sqlDB)
+	tracker := db.tracker(drv)
+	migrationsTableExists, err := tracker.MigrationsTableExists(sqlDB)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if migrationsTableExists {
-		appliedMigrations, err = drv.SelectMigrations(sqlDB, -1)
+		appliedMigrations, err = tracker.SelectMigrations(sqlDB, -1)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+	}
+
+	migrations, err := db.findMigrationFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := map[string]bool{}
+	for i := range migrations {
+		if ok := appliedMigrations[migrations[i].Version]; ok {
+			migrations[i].Applied = true
 		}
+		found[migrations[i].Version] = true
 	}
 
+	orphans := []string{}
+	for version := range appliedMigrations {
+		if !found[version] {
+			orphans = append(orphans, version)
+		}
+	}
+	sort.Strings(orphans)
+
+	return migrations, orphans, nil
+}
+
+// findMigrationFiles lists the migration files in all migrations directories, without
+// connecting to the database, and checks that their versions are unique
+func (db *DB) findMigrationFiles() ([]Migration, error) {
+	if db.migrations != nil {
+		return db.migrations.list(db.findUncachedMigrationFiles)
+	}
+
+	return db.findUncachedMigrationFiles()
+}
+
+func (db *DB) findUncachedMigrationFiles() ([]Migration, error) {
+	migrations, err := db.listMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDuplicateVersions(migrations); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// listMigrationFiles lists the migration files in each migrations directory, and the
+// registered Go migrations, sorted by file name
+func (db *DB) listMigrationFiles() ([]Migration, error) {
 	migrations := []Migration{}
 	for _, dir := range db.MigrationsDir {
 		// find filesystem migrations
-		files, err := db.readMigrationsDir(dir)
+		files, err := db.source().List(dir)
 		if err != nil {
 			return nil, fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir)
 		}
 
 		for _, file := range files {
-			if file.IsDir() {
-				continue
-			}
-
-			matches := migrationFileRegexp.FindStringSubmatch(file.Name())
+			matches := migrationFileRegexp.FindStringSubmatch(file)
 			if len(matches) < 2 {
 				continue
 			}
@@ -435,16 +1408,16 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 				FileName: matches[0],
 				FilePath: filepath.Join(dir, matches[0]),
 				FS:       db.FS,
+				Source:   db.Source,
 				Version:  matches[1],
 			}
-			if ok := appliedMigrations[migration.Version]; ok {
-				migration.Applied = true
-			}
 
 			migrations = append(migrations, migration)
 		}
 	}
 
+	migrations = append(migrations, findGoMigrations()...)
+
 	sort.Slice(migrations, func(i, j int) bool {
 		return migrations[i].FileName < migrations[j].FileName
 	})
@@ -452,24 +1425,76 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 	return migrations, nil
 }
 
+// checkDuplicateVersions returns an error listing the files of each version which is used
+// by more than one migration, since only one of them could ever be applied
+func checkDuplicateVersions(migrations []Migration) error {
+	paths := map[string][]string{}
+	versions := []string{}
+	for _, migration := range migrations {
+		if len(paths[migration.Version]) == 0 {
+			versions = append(versions, migration.Version)
+		}
+		paths[migration.Version] = append(paths[migration.Version], migration.FilePath)
+	}
+
+	duplicates := []string{}
+	for _, version := range versions {
+		if len(paths[version]) > 1 {
+			duplicates = append(duplicates, fmt.Sprintf("%s: %s", version, strings.Join(paths[version], ", ")))
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w:\n  %s\nrename all but one of each to a new version", ErrDuplicateVersion,
+			strings.Join(duplicates, "\n  "))
+	}
+
+	return nil
+}
+
 // Rollback rolls back the most recent migration
 func (db *DB) Rollback() error {
-	drv, err := db.Driver()
+	return db.RollbackContext(context.Background())
+}
+
+// RollbackContext is like Rollback, but cancels the rollback when ctx is done
+func (db *DB) RollbackContext(ctx context.Context) error {
+	_, err := db.RollbackWithResult(ctx)
+	return err
+}
+
+// RollbackWithResult is like RollbackContext, but also returns the migration which was
+// rolled back
+func (db *DB) RollbackWithResult(ctx context.Context) (*MigrationResult, error) {
+	if err := db.checkProtected(); err != nil {
+		return nil, err
+	}
+
+	drv, err := db.driver(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	if db.LockMigrations {
+		unlock, err := db.lock(ctx, drv, false)
+		if err != nil {
+			return nil, err
+		}
+		// the lock is also released when its connection is closed, so errors are ignored
+		defer func() { _ = unlock() }()
+	}
+
+	sqlDB, release, err := db.openDatabaseForMigration(drv)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer release()
 
 	// find last applied migration
 	var latest *Migration
-	migrations, err := db.FindMigrations()
+	migrations, _, err := db.findMigrationsWith(drv, sqlDB)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for i, migration := range migrations {
@@ -479,77 +1504,265 @@ func (db *DB) Rollback() error {
 	}
 
 	if latest == nil {
-		return ErrNoRollback
+		return nil, ErrNoRollback
 	}
 
-	fmt.Fprintf(db.Log, "Rolling back: %s\n", latest.FileName)
+	if db.Strict {
+		if err := db.verifyChecksums(drv, sqlDB, migrations); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintln(db.logger(LogLevelInfo), db.colorize(ColorYellow, "Rolling back: "+latest.FileName))
 
 	parsed, err := latest.Parse()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := db.checkImplicitCommit(drv, latest.FileName, parsed.Down, parsed.DownOptions); err != nil {
+		return nil, err
+	}
+	if err := db.verifySignatures([]Migration{*latest}); err != nil {
+		return nil, err
 	}
 
+	event := MigrationEvent{Version: latest.Version, FileName: latest.FileName, Direction: DirectionDown}
+	db.Hooks.before(event)
+	start := time.Now()
+
+	migrationCtx, guard := db.guardMigration(ctx, drv, sqlDB)
 	execMigration := func(tx dbutil.Transaction) error {
-		// rollback migration
-		result, err := tx.Exec(parsed.Down)
+		if err := guard.watch(tx); err != nil {
+			return err
+		}
+
+		restoreSchema, err := switchSchema(drv, tx, parsed.DownOptions)
 		if err != nil {
 			return err
-		} else if db.Verbose {
-			db.printVerbose(result)
+		}
+		defer func() { _ = restoreSchema() }()
+
+		restoreTimeouts, err := db.setTimeouts(drv, tx, parsed.DownOptions)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = restoreTimeouts() }()
+
+		// rollback migration
+		if !db.matchesShard(parsed.DownOptions) {
+			fmt.Fprintf(db.logger(LogLevelInfo), "Skipping: %s\n", skippedShardMessage(latest.FileName, parsed.DownOptions))
+		} else if err := db.runMigration(tx, *latest, parsed.Down, DirectionDown); err != nil {
+			return &MigrationError{FileName: latest.FileName, Err: err}
+		}
+
+		if err := restoreTimeouts(); err != nil {
+			return err
+		}
+		if err := restoreSchema(); err != nil {
+			return err
+		}
+
+		if db.Strict {
+			tracker, err := db.checksumTracker(drv)
+			if err != nil {
+				return err
+			}
+			if err := tracker.DeleteChecksum(tx, latest.Version); err != nil {
+				return err
+			}
 		}
 
 		// remove migration record
-		return drv.DeleteMigration(tx, latest.Version)
+		return db.tracker(drv).DeleteMigration(tx, latest.Version)
 	}
 
 	if parsed.DownOptions.Transaction() {
 		// begin transaction
-		err = doTransaction(sqlDB, execMigration)
+		err = db.retryTransaction(migrationCtx, drv, sqlDB, execMigration)
 	} else {
 		// run outside of transaction
-		err = execMigration(sqlDB)
+		err = doConnection(migrationCtx, sqlDB, execMigration)
 	}
+	err = guard.stop(err)
 
+	event.Duration = time.Since(start)
+	db.Hooks.after(event, err)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// automatically update schema file, silence errors
 	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
+		_ = db.writeSchema(drv, sqlDB)
 	}
 
-	return nil
+	return &MigrationResult{
+		Version:  latest.Version,
+		FileName: latest.FileName,
+		Duration: event.Duration,
+	}, nil
 }
 
-// Status shows the status of all migrations
+// StatusReport describes the status of all migrations
+type StatusReport struct {
+	// Migrations lists all migrations in version order, including their metadata
+	Migrations []Migration `json:"migrations"`
+	// Pending lists the migrations which have not been applied, in version order
+	Pending []Migration `json:"pending"`
+	// Missing lists the versions recorded as applied which have no migration file
+	Missing []string `json:"missing"`
+	// Checksums holds the checksum state (ChecksumOK, ChecksumModified or ChecksumUnknown)
+	// of each applied migration, keyed by version
+	Checksums map[string]string `json:"checksums"`
+}
+
+// StatusExtended returns the status of all migrations as structured data, for use by
+// external tools such as dashboards. Migrations which cannot be parsed are included
+// without their metadata.
+func (db *DB) StatusExtended() (*StatusReport, error) {
+	db = db.withMigrationCache()
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	migrations, orphans, err := db.findMigrationsWith(drv, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StatusReport{
+		Migrations: migrations,
+		Pending:    []Migration{},
+		Missing:    orphans,
+		Checksums:  db.checksumStates(drv, sqlDB, migrations),
+	}
+	// parse errors are reported when the migration is applied, not here
+	parseMigrations(migrations)
+	for i := range migrations {
+		// the returned migrations outlive the command, so must read their files again
+		migrations[i].cache = nil
+		if !migrations[i].Applied {
+			report.Pending = append(report.Pending, migrations[i])
+		}
+	}
+
+	return report, nil
+}
+
+// Status shows the status of all migrations, and returns the number of pending migrations
 func (db *DB) Status(quiet bool) (int, error) {
-	results, err := db.FindMigrations()
+	report, err := db.StatusExtended()
 	if err != nil {
 		return -1, err
 	}
 
-	var totalApplied int
-	var line string
+	return len(report.Pending), db.PrintStatus(report, quiet)
+}
 
-	for _, res := range results {
+// PrintStatus writes report as a table (unless quiet), then applies OrphanPolicy to its
+// missing migrations
+func (db *DB) PrintStatus(report *StatusReport, quiet bool) error {
+	header := []string{"VERSION", "NAME", "STATUS", "CHECKSUM", "DESCRIPTION"}
+	if db.StatusWide {
+		header = append(header, "TICKET", "AUTHOR", "TAGS")
+	}
+	rows := [][]string{header}
+	colors := []string{""}
+
+	for _, res := range report.Migrations {
+		status, color, checksum := "pending", ColorYellow, "-"
 		if res.Applied {
-			line = fmt.Sprintf("[X] %s", res.FileName)
-			totalApplied++
-		} else {
-			line = fmt.Sprintf("[ ] %s", res.FileName)
+			status, color, checksum = "applied", ColorGreen, report.Checksums[res.Version]
 		}
-		if !quiet {
-			fmt.Fprintln(db.Log, line)
+		if !db.statusShows(res) {
+			continue
 		}
+
+		row := []string{res.Version, migrationName(res), status, checksum, res.Description}
+		if db.StatusWide {
+			row = append(row, res.Metadata.Ticket, res.Metadata.Author, strings.Join(res.Metadata.Tags, ","))
+		}
+		rows = append(rows, row)
+		colors = append(colors, color)
 	}
 
-	totalPending := len(results) - totalApplied
+	// applied migrations which are missing from disk
+	if db.StatusApplied || !db.StatusPending {
+		for _, version := range report.Missing {
+			row := []string{version, "", "missing", "-", "file not found"}
+			if db.StatusWide {
+				row = append(row, "", "", "")
+			}
+			rows = append(rows, row)
+			colors = append(colors, ColorRed)
+		}
+	}
+
+	totalPending := len(report.Pending)
 	if !quiet {
+		for i, line := range formatTable(rows) {
+			if colors[i] != "" {
+				line = db.colorize(colors[i], line)
+			}
+			fmt.Fprintln(db.Log, line)
+		}
+
 		fmt.Fprintln(db.Log)
-		fmt.Fprintf(db.Log, "Applied: %d\n", totalApplied)
+		fmt.Fprintf(db.Log, "Applied: %d\n", len(report.Migrations)-totalPending)
 		fmt.Fprintf(db.Log, "Pending: %d\n", totalPending)
+		if len(report.Missing) > 0 {
+			fmt.Fprintf(db.Log, "Missing: %d\n", len(report.Missing))
+		}
+	}
+
+	return db.checkOrphans(report.Missing)
+}
+
+// migrationName returns the name of a migration, i.e. its file name without the
+// version prefix and extension
+func migrationName(m Migration) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(m.FileName, m.Version), ".sql")
+	return strings.TrimLeft(name, "_-")
+}
+
+// formatTable pads each cell to the width of its column, so that columns are aligned
+func formatTable(rows [][]string) []string {
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			cells[j] = cell + strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+		}
+		lines[i] = strings.TrimRight(strings.Join(cells, "  "), " ")
+	}
+
+	return lines
+}
+
+// statusShows returns whether a migration passes the StatusApplied and StatusPending filters
+func (db *DB) statusShows(m Migration) bool {
+	if !db.StatusApplied && !db.StatusPending {
+		return true
 	}
 
-	return totalPending, nil
+	return (m.Applied && db.StatusApplied) || (!m.Applied && db.StatusPending)
 }
@@ -0,0 +1,148 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ErrDocsUnsupported is returned by DescribeSchema for drivers which cannot describe tables
+var ErrDocsUnsupported = errors.New("driver does not support schema documentation")
+
+// mermaidUnsafeRegexp matches characters which cannot be used in Mermaid identifiers
+var mermaidUnsafeRegexp = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// DescribeSchema returns a description of each table in the database, except the
+// migrations table
+func (db *DB) DescribeSchema() ([]TableDescription, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	describer, ok := drv.(SchemaDescriber)
+	if !ok {
+		return nil, ErrDocsUnsupported
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	tables, err := describer.Tables(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []TableDescription{}
+	for _, table := range tables {
+		if table == db.MigrationsTableName || strings.HasSuffix(table, "."+db.MigrationsTableName) {
+			continue
+		}
+
+		description, err := describer.DescribeTable(sqlDB, table)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, description)
+	}
+
+	return result, nil
+}
+
+// GenerateDocs writes Markdown documentation of the database schema to w, listing the
+// columns, indexes and foreign keys of each table. If diagram is true, it begins with a
+// Mermaid entity relationship diagram.
+func (db *DB) GenerateDocs(w io.Writer, diagram bool) error {
+	tables, err := db.DescribeSchema()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Database Schema\n")
+
+	if diagram && len(tables) > 0 {
+		b.WriteString("\n```mermaid\nerDiagram\n")
+		for _, table := range tables {
+			fmt.Fprintf(&b, "    %s {\n", mermaidName(table.Name))
+			for _, column := range table.Columns {
+				typ := mermaidName(column.Type)
+				if typ == "" {
+					typ = "unknown"
+				}
+				fmt.Fprintf(&b, "        %s %s\n", typ, mermaidName(column.Name))
+			}
+			b.WriteString("    }\n")
+		}
+		for _, table := range tables {
+			for _, fk := range table.ForeignKeys {
+				fmt.Fprintf(&b, "    %s ||--o{ %s : %q\n",
+					mermaidName(fk.ReferencedTable), mermaidName(table.Name), strings.Join(fk.Columns, ", "))
+			}
+		}
+		b.WriteString("```\n")
+	}
+
+	for _, table := range tables {
+		fmt.Fprintf(&b, "\n## %s\n", table.Name)
+		if table.Comment != "" {
+			fmt.Fprintf(&b, "\n%s\n", table.Comment)
+		}
+
+		b.WriteString("\n| Column | Type | Nullable | Default | Comment |\n")
+		b.WriteString("| ------ | ---- | -------- | ------- | ------- |\n")
+		for _, column := range table.Columns {
+			writeMarkdownRow(&b, column.Name, column.Type, yesNo(column.Nullable), column.Default, column.Comment)
+		}
+
+		if len(table.Indexes) > 0 {
+			b.WriteString("\n**Indexes**\n\n")
+			b.WriteString("| Name | Columns | Unique |\n")
+			b.WriteString("| ---- | ------- | ------ |\n")
+			for _, index := range table.Indexes {
+				writeMarkdownRow(&b, index.Name, strings.Join(index.Columns, ", "), yesNo(index.Unique))
+			}
+		}
+
+		if len(table.ForeignKeys) > 0 {
+			b.WriteString("\n**Foreign keys**\n\n")
+			b.WriteString("| Name | Columns | References |\n")
+			b.WriteString("| ---- | ------- | ---------- |\n")
+			for _, fk := range table.ForeignKeys {
+				writeMarkdownRow(&b, fk.Name, strings.Join(fk.Columns, ", "),
+					fmt.Sprintf("%s (%s)", fk.ReferencedTable, strings.Join(fk.ReferencedColumns, ", ")))
+			}
+		}
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeMarkdownRow writes a row of a Markdown table, escaping each cell
+func writeMarkdownRow(b *strings.Builder, cells ...string) {
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", `\|`)
+		cells[i] = strings.Join(strings.Fields(cell), " ")
+	}
+
+	fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+}
+
+// mermaidName replaces characters which Mermaid does not allow in identifiers
+func mermaidName(name string) string {
+	return strings.Trim(mermaidUnsafeRegexp.ReplaceAllString(name, "_"), "_")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,167 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ErrDoctorFailed is returned by Doctor when one or more checks fail
+var ErrDoctorFailed = errors.New("doctor found problems")
+
+// doctor accumulates the results of diagnostic checks
+type doctor struct {
+	db       *DB
+	w        io.Writer
+	problems int
+}
+
+func (d *doctor) ok(check, detail string) {
+	line := fmt.Sprintf("[ok]   %s: %s", check, detail)
+	fmt.Fprintln(d.w, d.db.colorize(ColorGreen, line))
+}
+
+func (d *doctor) warn(check, detail, fix string) {
+	line := fmt.Sprintf("[warn] %s: %s", check, detail)
+	fmt.Fprintln(d.w, d.db.colorize(ColorYellow, line))
+	fmt.Fprintf(d.w, "       fix: %s\n", fix)
+}
+
+func (d *doctor) fail(check, detail, fix string) {
+	line := fmt.Sprintf("[fail] %s: %s", check, detail)
+	fmt.Fprintln(d.w, d.db.colorize(ColorRed, line))
+	fmt.Fprintf(d.w, "       fix: %s\n", fix)
+	d.problems++
+}
+
+// Doctor checks the configuration and database connection, printing a suggested
+// fix for each problem found
+func (db *DB) Doctor() error {
+	d := &doctor{db: db, w: &redactWriter{w: db.Log}}
+
+	db.doctorMigrationsDirs(d)
+	db.doctorDatabase(d)
+
+	if d.problems > 0 {
+		return fmt.Errorf("%w: %d problem(s) found", ErrDoctorFailed, d.problems)
+	}
+
+	return nil
+}
+
+func (db *DB) doctorMigrationsDirs(d *doctor) {
+	for _, dir := range db.MigrationsDir {
+		files, err := db.source().List(dir)
+		if err != nil {
+			d.fail("migrations dir", fmt.Sprintf("%s: %s", dir, err),
+				"create the directory, or point --migrations-dir at your migration files")
+			continue
+		}
+
+		count := 0
+		for _, file := range files {
+			if migrationFileRegexp.MatchString(file) {
+				count++
+			}
+		}
+		d.ok("migrations dir", fmt.Sprintf("%s (%d migration files)", dir, count))
+	}
+}
+
+func (db *DB) doctorDatabase(d *doctor) {
+	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
+		d.fail("database url", "not set",
+			"pass --url, or set DATABASE_URL (or the variable named by --env) in your environment or .env file")
+		return
+	}
+	d.ok("database url", db.DatabaseURL.Redacted())
+
+	if _, ok := GetDriverFunc(db.DatabaseURL.Scheme); !ok {
+		d.fail("driver", fmt.Sprintf("unsupported scheme %q", db.DatabaseURL.Scheme),
+			fmt.Sprintf("use one of the supported schemes: %s", strings.Join(driverSchemes(), ", ")))
+		return
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		d.fail("driver", err.Error(), "check that the database server is running and reachable")
+		return
+	}
+	d.ok("driver", db.DatabaseURL.Scheme)
+
+	if dumper, ok := drv.(DumpCommander); ok {
+		name := dumper.DumpCommand()
+		if path, err := exec.LookPath(name); err != nil {
+			fix := "install the client tools for your database (e.g. postgresql-client, mysql-client or sqlite3), or pass --no-dump-schema"
+			if db.AutoDumpSchema {
+				d.fail("dump command", fmt.Sprintf("%s not found in PATH", name), fix)
+			} else {
+				d.warn("dump command", fmt.Sprintf("%s not found in PATH", name), fix)
+			}
+		} else {
+			d.ok("dump command", path)
+		}
+	}
+
+	if err := drv.Ping(); err != nil {
+		d.fail("connection", err.Error(),
+			"check the host, port and credentials in your database url, and that the server is running (try dbmate wait)")
+		return
+	}
+	d.ok("connection", "server is reachable")
+
+	exists, err := drv.DatabaseExists()
+	if err != nil {
+		d.fail("database", err.Error(), "check that the database user has permission to list databases")
+		return
+	}
+	if !exists {
+		d.fail("database", "does not exist",
+			"run dbmate create (or dbmate up, which creates the database before migrating)")
+		return
+	}
+	d.ok("database", "exists")
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		d.fail("migrations table", err.Error(), "check the connection options in your database url")
+		return
+	}
+	defer release()
+
+	tracker := db.tracker(drv)
+	tableExists, err := tracker.MigrationsTableExists(sqlDB)
+	if err != nil {
+		d.fail("migrations table", err.Error(), "check that the database user has permission to read the schema")
+		return
+	}
+	if !tableExists {
+		d.warn("migrations table", fmt.Sprintf("%s does not exist", db.MigrationsTableName),
+			"it will be created by dbmate migrate or dbmate up")
+		return
+	}
+
+	if _, err := tracker.SelectMigrations(sqlDB, 1); err != nil {
+		d.fail("migrations table", fmt.Sprintf("%s: %s", db.MigrationsTableName, err),
+			"check that --migrations-table names a dbmate migrations table with a version column")
+		return
+	}
+	d.ok("migrations table", db.MigrationsTableName)
+}
+
+// driverSchemes returns the sorted URL schemes of all registered drivers
+func driverSchemes() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
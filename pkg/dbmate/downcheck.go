@@ -0,0 +1,53 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrInvalidDownBlock is returned when CheckDownBlocks is set, and the down block of a
+// migration which is being applied fails its syntax check
+var ErrInvalidDownBlock = errors.New("down block failed its syntax check")
+
+// checkDownBlock syntax checks each statement in the down block of migration on the
+// session tx, after its up block has been applied, so that statements referring to
+// objects created by the up block can be checked. Nothing is checked for Go migrations,
+// blocks skipped on this shard, or drivers which do not implement SyntaxChecker.
+func (db *DB) checkDownBlock(drv Driver, tx dbutil.Transaction, migration Migration, parsed *ParsedMigration) error {
+	checker, ok := drv.(SyntaxChecker)
+	if !ok || migration.goMigration != nil || !db.matchesShard(parsed.DownOptions) ||
+		!blockHasStatements(parsed.Down) {
+		return nil
+	}
+
+	block, err := db.expandTenant(parsed.Down)
+	if err != nil {
+		return err
+	}
+
+	// each statement must be checked separately, since checking a block could execute
+	// every statement after the first
+	statements, ok := dbutil.SplitStatementsWith(block, splitOptions(drv))
+	if !ok {
+		fmt.Fprintf(db.logger(LogLevelWarn), "Warning: the down block of %s cannot be split into statements, "+
+			"and was not checked\n", migration.FileName)
+		return nil
+	}
+
+	restoreSchema, err := switchSchema(drv, tx, parsed.DownOptions)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = restoreSchema() }()
+
+	for _, statement := range statements {
+		if err := checker.CheckSyntax(tx, statement); err != nil {
+			return fmt.Errorf("%w: %s: %w (in statement: %s)", ErrInvalidDownBlock, migration.FileName, err,
+				statement)
+		}
+	}
+
+	return restoreSchema()
+}
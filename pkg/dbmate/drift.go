@@ -0,0 +1,80 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// ErrSchemaOutdated is returned by VerifySchema when the schema file does not match the database
+var ErrSchemaOutdated = errors.New("schema file is out of date")
+
+// VerifySchema dumps the database schema, and checks that its checksum matches the schema
+// file. Unlike Drift, the comparison is exact, so it fails if the schema file was not
+// regenerated (with DumpSchema) after applying a migration. The database is not modified.
+func (db *DB) VerifySchema() error {
+	expected, err := os.ReadFile(db.SchemaFile)
+	if err != nil {
+		return err
+	}
+
+	actual, err := db.CurrentSchema()
+	if err != nil {
+		return err
+	}
+
+	if checksum(expected) != checksum(actual) {
+		return fmt.Errorf("%w: %s has sha256 %s, but the database schema has sha256 %s",
+			ErrSchemaOutdated, db.SchemaFile, checksum(expected), checksum(actual))
+	}
+
+	fmt.Fprintf(db.logger(LogLevelInfo), "Verified: %s (sha256 %s)\n", db.SchemaFile, checksum(actual))
+	return nil
+}
+
+// Drift compares the current database schema with the schema file, and returns a unified
+// diff of any differences, or an empty string if they match. Comments, blank lines and
+// trailing whitespace are ignored, so that different versions of the dump tools do not
+// cause spurious differences. The database is not modified.
+func (db *DB) Drift() (string, error) {
+	expected, err := os.ReadFile(db.SchemaFile)
+	if err != nil {
+		return "", err
+	}
+
+	actual, err := db.CurrentSchema()
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        normalizeSchema(string(expected)),
+		B:        normalizeSchema(string(actual)),
+		FromFile: db.SchemaFile,
+		ToFile:   "database",
+		Context:  3,
+	})
+}
+
+// normalizeSchema splits a schema dump into lines, removing comments, blank lines and
+// trailing whitespace
+func normalizeSchema(schema string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(schema, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if isEmptyLine(line) || isCommentLine(line) {
+			continue
+		}
+		// pg_dump restricts meta-commands using a random key, which changes on every dump
+		if strings.HasPrefix(line, `\restrict `) || strings.HasPrefix(line, `\unrestrict `) {
+			continue
+		}
+
+		lines = append(lines, line+"\n")
+	}
+
+	return lines
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"io"
 	"net/url"
+	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 )
@@ -15,12 +18,208 @@ type Driver interface {
 	CreateDatabase() error
 	DropDatabase() error
 	DumpSchema(*sql.DB) ([]byte, error)
+	Tracker
+	Ping() error
+}
+
+// Tracker records which migrations have been applied. Every driver tracks migrations in
+// a table in the target database, but DB.Tracker can be set to record them elsewhere.
+// The database (or transaction) which the migration is executed against is passed to
+// each method, and may be ignored by trackers which do not use it.
+type Tracker interface {
 	MigrationsTableExists(*sql.DB) (bool, error)
 	CreateMigrationsTable(*sql.DB) error
 	SelectMigrations(*sql.DB, int) (map[string]bool, error)
 	InsertMigration(dbutil.Transaction, string) error
 	DeleteMigration(dbutil.Transaction, string) error
-	Ping() error
+}
+
+// ChecksumTracker is implemented by trackers which can record the checksum of each
+// applied migration, which is required by strict mode
+type ChecksumTracker interface {
+	// CreateChecksumsTable creates the table which records checksums, if it does not exist
+	CreateChecksumsTable(*sql.DB) error
+	// SelectChecksums returns the recorded checksum of each migration version
+	SelectChecksums(*sql.DB) (map[string]string, error)
+	// InsertChecksum records the checksum of a migration version
+	InsertChecksum(db dbutil.Transaction, version, checksum string) error
+	// DeleteChecksum removes the checksum of a migration version (if it exists)
+	DeleteChecksum(db dbutil.Transaction, version string) error
+}
+
+// CreatePermissionChecker is implemented by trackers which can check that the migrations
+// table could be created without creating it, which is used by CheckPreflight
+type CreatePermissionChecker interface {
+	// CheckCreateMigrationsTable returns an error if the session db is not permitted to
+	// create the migrations table, leaving the database unchanged
+	CheckCreateMigrationsTable(*sql.DB) error
+}
+
+// SchemaSwitcher is implemented by drivers which support the "schema" block option
+type SchemaSwitcher interface {
+	// SwitchSchema makes schema the default for subsequent statements executed on db,
+	// and returns a function which restores the previous default
+	SwitchSchema(db dbutil.Transaction, schema string) (func() error, error)
+}
+
+// BackslashEscaper is implemented by drivers for databases whose quoted strings use
+// backslash escapes (such as MySQL), which must be known to split SQL into statements
+type BackslashEscaper interface {
+	// BackslashEscapes reports whether a backslash escapes the next character in a string
+	BackslashEscapes() bool
+}
+
+// splitOptions returns the options for splitting SQL executed by drv into statements
+func splitOptions(drv Driver) dbutil.SplitOptions {
+	escaper, ok := drv.(BackslashEscaper)
+	return dbutil.SplitOptions{BackslashEscapes: ok && escaper.BackslashEscapes()}
+}
+
+// ImplicitCommitter is implemented by drivers for databases which implicitly commit the
+// current transaction before some statements (such as DDL in MySQL)
+type ImplicitCommitter interface {
+	// CommitsImplicitly reports whether statement implicitly commits the current transaction
+	CommitsImplicitly(statement string) bool
+}
+
+// TimeoutSetter is implemented by drivers which can limit how long statements wait for
+// locks and run for, which is required by LockTimeout and StatementTimeout
+type TimeoutSetter interface {
+	// SetTimeout sets a timeout (one of the Timeout constants) for subsequent statements
+	// executed on db, where 0 disables the timeout, and returns a function which restores
+	// the previous value
+	SetTimeout(db dbutil.Transaction, setting string, timeout time.Duration) (func() error, error)
+}
+
+// TransactionRetrier is implemented by drivers for databases which may abort a
+// transaction with an error that the client is expected to retry, such as the
+// "restart transaction" errors returned by CockroachDB under contention
+type TransactionRetrier interface {
+	// IsRetryable reports whether a transaction which failed with err may succeed if it
+	// is run again
+	IsRetryable(err error) bool
+}
+
+// SyntaxChecker is implemented by drivers which can check that a statement is valid
+// without executing it, which is required by CheckDownBlocks
+type SyntaxChecker interface {
+	// CheckSyntax parses (and where possible, plans) a single statement on the session
+	// db, and returns the error the database would report when executing it
+	CheckSyntax(db dbutil.Transaction, statement string) error
+}
+
+// Locker is implemented by drivers which support advisory locks, which prevent multiple
+// dbmate processes from applying migrations to the same database at once
+type Locker interface {
+	// Lock blocks until an exclusive lock is held by the session db
+	Lock(db dbutil.Transaction) error
+	// Unlock releases the lock held by the session db
+	Unlock(db dbutil.Transaction) error
+}
+
+// LockInspector is implemented by lockers which can acquire the lock without waiting,
+// and record which process holds it, so that a process which finds the lock held can
+// report its holder, and a stuck lock can be released
+type LockInspector interface {
+	// TryLock acquires the lock for the session db if no other session holds it, and
+	// reports whether it was acquired
+	TryLock(db dbutil.Transaction) (bool, error)
+	// SetLockHolder records holder as the holder of the lock held by the session db
+	SetLockHolder(db dbutil.Transaction, holder LockHolder) error
+	// LockHolder returns the holder of the lock, with its Session set to the session
+	// which holds it, or nil if the lock is not held
+	LockHolder(db *sql.DB) (*LockHolder, error)
+	// ForceUnlock releases the lock held by another session, by terminating it
+	ForceUnlock(db *sql.DB, session string) error
+}
+
+// QueryCanceller is implemented by drivers which can cancel the statement running on
+// another session, which is used to stop migrations exceeding MaxMigrationDuration
+type QueryCanceller interface {
+	// SessionID returns an identifier for the session db
+	SessionID(db dbutil.Transaction) (string, error)
+	// CancelQuery cancels the statement running on the session with the given identifier,
+	// using a different connection from db
+	CancelQuery(db *sql.DB, id string) error
+}
+
+// Consoler is implemented by drivers which can open an interactive command line client
+type Consoler interface {
+	// ConsoleCommand returns a command which opens the native client for the database,
+	// with credentials passed via environment variables rather than arguments
+	ConsoleCommand() (*exec.Cmd, error)
+}
+
+// DumpCommander is implemented by drivers which run an external command to dump the schema
+type DumpCommander interface {
+	// DumpCommand returns the name of the command which must be available in PATH
+	DumpCommand() string
+}
+
+// DatabaseLister is implemented by drivers which can list the databases on the server,
+// which is required to find tenant databases
+type DatabaseLister interface {
+	// ListDatabases returns the names of the databases on the server, which can be used
+	// as the database name in the URL
+	ListDatabases() ([]string, error)
+}
+
+// TemplateCloner is implemented by drivers which can create a database as a copy of
+// another database on the same server, which is faster than applying every migration
+type TemplateCloner interface {
+	// CreateDatabaseFromTemplate creates the database as a copy of the template database,
+	// which must not have any open connections
+	CreateDatabaseFromTemplate(template string) error
+}
+
+// TableLister is implemented by drivers which can list the tables in the database, which
+// is required to dump data
+type TableLister interface {
+	// Tables returns the names of the tables in the database, qualified with their schema
+	// where the database has schemas
+	Tables(db *sql.DB) ([]string, error)
+}
+
+// SchemaDescriber is implemented by drivers which can describe the structure of a table,
+// which is required to generate schema documentation
+type SchemaDescriber interface {
+	TableLister
+	// DescribeTable returns the columns, indexes and foreign keys of table, which is a
+	// name returned by Tables
+	DescribeTable(db *sql.DB, table string) (TableDescription, error)
+}
+
+// TableDescription describes a table in the database
+type TableDescription struct {
+	Name        string
+	Comment     string
+	Columns     []ColumnDescription
+	Indexes     []IndexDescription
+	ForeignKeys []ForeignKeyDescription
+}
+
+// ColumnDescription describes a column of a table
+type ColumnDescription struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Default  string
+	Comment  string
+}
+
+// IndexDescription describes an index on a table
+type IndexDescription struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKeyDescription describes a foreign key from a table to another table
+type ForeignKeyDescription struct {
+	Name              string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
 }
 
 // DriverConfig holds configuration passed to driver constructors
@@ -28,14 +227,42 @@ type DriverConfig struct {
 	DatabaseURL         *url.URL
 	Log                 io.Writer
 	MigrationsTableName string
+	// SchemaFormat is the level of detail requested for schema dumps
+	SchemaFormat string
+	// IncludeSchemas lists additional schemas (or databases) to include in schema dumps
+	IncludeSchemas []string
 }
 
 // DriverFunc represents a driver constructor
 type DriverFunc func(DriverConfig) Driver
 
-var drivers = map[string]DriverFunc{}
+var (
+	drivers   = map[string]DriverFunc{}
+	driversMu sync.RWMutex
+)
 
-// RegisterDriver registers a driver constructor for a given URL scheme
+// RegisterDriver registers a driver constructor for a given URL scheme, replacing any
+// driver previously registered for that scheme
 func RegisterDriver(f DriverFunc, scheme string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
 	drivers[scheme] = f
 }
+
+// UnregisterDriver removes the driver constructor registered for a given URL scheme
+func UnregisterDriver(scheme string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	delete(drivers, scheme)
+}
+
+// GetDriverFunc returns the driver constructor registered for a given URL scheme
+func GetDriverFunc(scheme string) (DriverFunc, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	f, ok := drivers[scheme]
+	return f, ok
+}
@@ -0,0 +1,145 @@
+package dbmate
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrNoFixtures is returned by LoadFixtures when a fixture set contains no files
+var ErrNoFixtures = errors.New("no fixture files found")
+
+// fixturePrefixRegexp matches the numeric prefix which orders fixture files
+var fixturePrefixRegexp = regexp.MustCompile(`^\d+_`)
+
+// fixture is a file in a fixture set, with the statements which load it
+type fixture struct {
+	path       string
+	table      string
+	statements []string
+}
+
+// LoadFixtures loads the fixture files in set (a subdirectory of FixturesDir) inside a
+// single transaction, so that a failure leaves the database unchanged. Files are loaded in
+// order of file name. A .sql file is executed as-is. A .csv file replaces the contents of
+// the table it is named after (ignoring a numeric prefix such as "01_"), using the first
+// row as the column names; empty values are loaded as NULL. Tables are emptied in reverse
+// order before any files are loaded, so that foreign keys can reference earlier files.
+func (db *DB) LoadFixtures(set string) error {
+	fixtures, err := db.readFixtures(set)
+	if err != nil {
+		return err
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return doTransaction(context.Background(), sqlDB, func(tx dbutil.Transaction) error {
+		for i := len(fixtures) - 1; i >= 0; i-- {
+			if fixtures[i].table == "" {
+				continue
+			}
+			if _, err := tx.Exec("DELETE FROM " + fixtures[i].table); err != nil {
+				return fmt.Errorf("%s: %w", fixtures[i].path, err)
+			}
+		}
+
+		for _, f := range fixtures {
+			fmt.Fprintf(db.logger(LogLevelInfo), "Loading: %s\n", f.path)
+			for _, statement := range f.statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return fmt.Errorf("%s: %w", f.path, err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// readFixtures reads the fixture files in set, ordered by file name
+func (db *DB) readFixtures(set string) ([]fixture, error) {
+	dir := filepath.Join(db.FixturesDir, set)
+	source := NewFSSource(db.FS)
+	names, err := source.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not find fixture set `%s`: %w", set, err)
+	}
+	sort.Strings(names)
+
+	fixtures := []fixture{}
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if ext != ".sql" && ext != ".csv" {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		contents, err := source.Read(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f := fixture{path: path}
+		if ext == ".sql" {
+			f.statements = []string{string(contents)}
+		} else {
+			f.table = fixturePrefixRegexp.ReplaceAllString(strings.TrimSuffix(name, ext), "")
+			if f.statements, err = csvInsertStatements(f.table, contents); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	if len(fixtures) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoFixtures, dir)
+	}
+
+	return fixtures, nil
+}
+
+// csvInsertStatements returns an INSERT statement into table for each row of contents,
+// using the first row as the column names
+func csvInsertStatements(table string, contents []byte) ([]string, error) {
+	rows, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := strings.Join(rows[0], ", ")
+	statements := []string{}
+	for _, row := range rows[1:] {
+		values := make([]string, len(row))
+		for i, value := range row {
+			if value == "" {
+				values[i] = sqlLiteral(nil)
+			} else {
+				values[i] = sqlLiteral(value)
+			}
+		}
+		statements = append(statements, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			table, columns, strings.Join(values, ", ")))
+	}
+
+	return statements, nil
+}
@@ -0,0 +1,159 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrNoSchemaChanges = errors.New("database schema already matches")
+	ErrSchemaSplit     = errors.New("schema cannot be split into statements")
+)
+
+// createObjectRegexp matches statements which create a named object that can be dropped
+var createObjectRegexp = regexp.MustCompile(`(?is)^CREATE\s+(?:OR\s+REPLACE\s+)?(?:UNIQUE\s+)?` +
+	`(TABLE|MATERIALIZED\s+VIEW|VIEW|INDEX|SEQUENCE|TYPE|SCHEMA)\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)`)
+
+// GenerateMigration creates a new migration file containing the statements which bring
+// the database schema up to date with desired (such as the contents of the schema file).
+// Objects which are created or dropped are handled automatically, while objects whose
+// definition has changed are written as comments, to be replaced with ALTER statements
+// by hand. It returns ErrNoSchemaChanges if the schemas already match.
+func (db *DB) GenerateMigration(name string, desired []byte) error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+	current, err := db.CurrentSchema()
+	if err != nil {
+		return err
+	}
+
+	opts := splitOptions(drv)
+	currentStatements, err := schemaStatements(string(current), db.MigrationsTableName, opts)
+	if err != nil {
+		return err
+	}
+	desiredStatements, err := schemaStatements(string(desired), db.MigrationsTableName, opts)
+	if err != nil {
+		return err
+	}
+
+	up, down := schemaChanges(currentStatements, desiredStatements)
+	if up == "" {
+		return ErrNoSchemaChanges
+	}
+
+	return db.NewMigrationWithContents(name, up, down)
+}
+
+// schemaStatements splits a schema dump into statements, ignoring comments and any
+// statements relating to the migrations table
+func schemaStatements(schema, migrationsTable string, opts dbutil.SplitOptions) ([]string, error) {
+	statements, ok := dbutil.SplitStatementsWith(strings.Join(normalizeSchema(schema), ""), opts)
+	if !ok {
+		return nil, ErrSchemaSplit
+	}
+
+	result := []string{}
+	for _, statement := range statements {
+		if strings.Contains(statement, migrationsTable) {
+			continue
+		}
+		result = append(result, strings.TrimSuffix(statement, ";")+";")
+	}
+
+	return result, nil
+}
+
+// schemaObject returns the kind and name of the object created by statement, if it can
+// be dropped
+func schemaObject(statement string) (string, bool) {
+	match := createObjectRegexp.FindStringSubmatch(statement)
+	if match == nil {
+		return "", false
+	}
+
+	kind := strings.ToUpper(strings.Join(strings.Fields(match[1]), " "))
+	return kind + " " + match[2], true
+}
+
+// schemaChanges returns the statements which change the current schema to the desired
+// schema, and the statements which revert them
+func schemaChanges(current, desired []string) (string, string) {
+	currentSet := map[string]bool{}
+	currentObjects := map[string]string{}
+	for _, statement := range current {
+		currentSet[statement] = true
+		if object, ok := schemaObject(statement); ok {
+			currentObjects[object] = statement
+		}
+	}
+
+	desiredSet := map[string]bool{}
+	desiredObjects := map[string]string{}
+	for _, statement := range desired {
+		desiredSet[statement] = true
+		if object, ok := schemaObject(statement); ok {
+			desiredObjects[object] = statement
+		}
+	}
+
+	up := []string{}
+	down := []string{}
+	for _, statement := range desired {
+		if currentSet[statement] {
+			continue
+		}
+
+		object, ok := schemaObject(statement)
+		switch {
+		case ok && currentObjects[object] != "":
+			up = append(up, fmt.Sprintf("-- TODO: %s has changed, replace this with ALTER statements:\n%s",
+				object, commentOut(statement)))
+			down = append(down, fmt.Sprintf("-- TODO: revert the changes to %s:\n%s",
+				object, commentOut(currentObjects[object])))
+		case ok:
+			up = append(up, statement)
+			down = append(down, fmt.Sprintf("DROP %s;", object))
+		default:
+			up = append(up, statement)
+			down = append(down, "-- TODO: revert:\n"+commentOut(statement))
+		}
+	}
+
+	for _, statement := range current {
+		if desiredSet[statement] {
+			continue
+		}
+
+		object, ok := schemaObject(statement)
+		switch {
+		case ok && desiredObjects[object] != "":
+			// already reported as changed
+		case ok:
+			up = append(up, fmt.Sprintf("DROP %s;", object))
+			down = append(down, statement)
+		default:
+			up = append(up, "-- TODO: this statement is no longer in the schema:\n"+commentOut(statement))
+			down = append(down, statement)
+		}
+	}
+
+	// changes are reverted in the opposite order
+	for i, j := 0, len(down)-1; i < j; i, j = i+1, j-1 {
+		down[i], down[j] = down[j], down[i]
+	}
+
+	return strings.Join(up, "\n"), strings.Join(down, "\n")
+}
+
+// commentOut prefixes each line of statement with a SQL comment
+func commentOut(statement string) string {
+	return "-- " + strings.ReplaceAll(statement, "\n", "\n-- ")
+}
@@ -0,0 +1,57 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaStatements(t *testing.T) {
+	statements, err := schemaStatements(`CREATE TABLE IF NOT EXISTS "schema_migrations" (version varchar(128) primary key);
+-- a comment
+CREATE TABLE users (
+  id integer
+);
+
+INSERT INTO "schema_migrations" (version) VALUES
+  ('001');
+`, "schema_migrations", dbutil.SplitOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CREATE TABLE users (\n  id integer\n);"}, statements)
+}
+
+func TestSchemaChanges(t *testing.T) {
+	current := []string{
+		"CREATE TABLE users (id integer);",
+		"CREATE TABLE legacy (id integer);",
+		"CREATE VIEW active_users AS SELECT * FROM users;",
+	}
+	desired := []string{
+		"CREATE TABLE users (id integer, name text);",
+		"CREATE VIEW active_users AS SELECT * FROM users;",
+		"CREATE TABLE posts (id integer);",
+		"CREATE UNIQUE INDEX posts_id ON posts (id);",
+		"ALTER TABLE posts OWNER TO app;",
+	}
+
+	up, down := schemaChanges(current, desired)
+	require.Equal(t, `-- TODO: TABLE users has changed, replace this with ALTER statements:
+-- CREATE TABLE users (id integer, name text);
+CREATE TABLE posts (id integer);
+CREATE UNIQUE INDEX posts_id ON posts (id);
+ALTER TABLE posts OWNER TO app;
+DROP TABLE legacy;`, up)
+	require.Equal(t, `CREATE TABLE legacy (id integer);
+-- TODO: revert:
+-- ALTER TABLE posts OWNER TO app;
+DROP INDEX posts_id;
+DROP TABLE posts;
+-- TODO: revert the changes to TABLE users:
+-- CREATE TABLE users (id integer);`, down)
+
+	up, down = schemaChanges(current, current)
+	require.Empty(t, up)
+	require.Empty(t, down)
+}
@@ -0,0 +1,178 @@
+package dbmate
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// git runs a git command in the first migrations directory, and returns the non-empty
+// lines of its output
+func (db *DB) git(args ...string) ([]string, error) {
+	out, err := dbutil.RunCommand("git", append([]string{"-C", db.MigrationsDir[0]}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// gitPathspecs returns the migrations directories as absolute paths, which limit git
+// commands run by db.git to the migration files
+func (db *DB) gitPathspecs() ([]string, error) {
+	paths := []string{}
+	for _, dir := range db.MigrationsDir {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// checkBranchVersions warns if a git branch which has not been merged into HEAD contains
+// a migration which sorts after version, since if that branch is merged first, databases
+// which apply its migration will apply the new migration out of order
+func (db *DB) checkBranchVersions(version string) {
+	later, err := db.laterBranchMigrations(version)
+	if err != nil {
+		fmt.Fprintf(db.logger(LogLevelWarn), "Warning: could not check other branches for migrations: %s\n", err)
+		return
+	}
+
+	for _, m := range later {
+		fmt.Fprintf(db.logger(LogLevelWarn), "Warning: branch %s contains migration %s, which sorts after %s; "+
+			"if it is merged first, the new migration will be applied out of order\n", m.branch, m.fileName, version)
+	}
+}
+
+// branchMigration is a migration file found on a git branch
+type branchMigration struct {
+	branch   string
+	fileName string
+}
+
+// laterBranchMigrations returns the latest migration on each unmerged branch which sorts
+// after version, and is not in the migrations directory
+func (db *DB) laterBranchMigrations(version string) ([]branchMigration, error) {
+	paths, err := db.gitPathspecs()
+	if err != nil {
+		return nil, err
+	}
+
+	local := map[string]bool{}
+	if migrations, err := db.listMigrationFiles(); err == nil {
+		for _, migration := range migrations {
+			local[migration.FileName] = true
+		}
+	}
+
+	refs, err := db.git("for-each-ref", "--no-merged=HEAD", "--format=%(refname:short) %(symref)",
+		"refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+
+	later := []branchMigration{}
+	for _, ref := range refs {
+		// symbolic refs such as origin/HEAD duplicate the branch they point to
+		fields := strings.Fields(ref)
+		if len(fields) != 1 {
+			continue
+		}
+
+		files, err := db.git(append([]string{"ls-tree", "-r", "--name-only", fields[0], "--"}, paths...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		latest := ""
+		for _, file := range files {
+			name := filepath.Base(file)
+			matches := migrationFileRegexp.FindStringSubmatch(name)
+			if matches == nil || local[name] || len(matches[1]) != len(version) || matches[1] <= version {
+				continue
+			}
+			if name > latest {
+				latest = name
+			}
+		}
+		if latest != "" {
+			later = append(later, branchMigration{branch: fields[0], fileName: latest})
+		}
+	}
+
+	return later, nil
+}
+
+// mergedOutOfOrder uses the git history of HEAD to find migrations which were added
+// (usually by merging a branch) after a migration with a later version, so databases
+// which applied the later migration first will apply them out of order. Only the first
+// parent of each merge is followed, so that a migration is attributed to the merge which
+// introduced it. If GitBase is set, only migrations added after it are returned. It
+// returns an error for each such migration, keyed by file name.
+func (db *DB) mergedOutOfOrder() (map[string]error, error) {
+	paths, err := db.gitPathspecs()
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := db.git(append([]string{"log", "--reverse", "--first-parent", "--diff-merges=first-parent",
+		"--diff-filter=A", "--no-renames", "--name-only", "--format=%x00%H %h", "HEAD", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var recent map[string]bool
+	if db.GitBase != "" {
+		commits, err := db.git("rev-list", "--first-parent", db.GitBase+"..HEAD")
+		if err != nil {
+			return nil, err
+		}
+		recent = map[string]bool{}
+		for _, commit := range commits {
+			recent[commit] = true
+		}
+	}
+
+	problems := map[string]error{}
+	newest, newestVersion := "", ""
+	commit, shortCommit := "", ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "\x00") {
+			commit, shortCommit, _ = strings.Cut(line[1:], " ")
+			continue
+		}
+
+		name := filepath.Base(line)
+		matches := migrationFileRegexp.FindStringSubmatch(name)
+		if matches == nil {
+			continue
+		}
+
+		// versions which are not timestamps cannot be compared
+		version := matches[1]
+		switch {
+		case newest == "":
+			newest, newestVersion = name, version
+		case len(version) != len(newestVersion):
+		case version > newestVersion:
+			newest, newestVersion = name, version
+		case version < newestVersion && (recent == nil || recent[commit]):
+			problems[name] = fmt.Errorf("%w (%s), in %s", ErrMergedOutOfOrder, newest, shortCommit)
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,82 @@
+package dbmate
+
+import (
+	"sync"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// GoMigrationFunc applies or rolls back a migration written in Go. It runs inside the same
+// transaction as the statement which records the migration.
+type GoMigrationFunc func(tx dbutil.Transaction) error
+
+// goMigration holds the functions registered for a Go migration
+type goMigration struct {
+	up   GoMigrationFunc
+	down GoMigrationFunc
+}
+
+var (
+	goMigrations   = map[string]goMigration{}
+	goMigrationsMu sync.RWMutex
+)
+
+// RegisterGoMigration registers a migration written in Go, for data transformations which
+// are impractical in SQL. It is applied in version order alongside migration files, and is
+// listed with the file name "<version>.go". Either function may be nil. Registering a
+// version again replaces the previous functions.
+func RegisterGoMigration(version string, up, down GoMigrationFunc) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+
+	goMigrations[version] = goMigration{up: up, down: down}
+}
+
+// UnregisterGoMigration removes the Go migration registered for version
+func UnregisterGoMigration(version string) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+
+	delete(goMigrations, version)
+}
+
+// findGoMigrations lists the registered Go migrations
+func findGoMigrations() []Migration {
+	goMigrationsMu.RLock()
+	defer goMigrationsMu.RUnlock()
+
+	migrations := []Migration{}
+	for version, fns := range goMigrations {
+		fns := fns
+		migrations = append(migrations, Migration{
+			FileName:    version + ".go",
+			FilePath:    version + ".go",
+			Version:     version,
+			goMigration: &fns,
+		})
+	}
+
+	return migrations
+}
+
+// parsedGoMigration returns a ParsedMigration for a Go migration, which has no SQL blocks
+// and always runs inside a transaction
+func parsedGoMigration(m *goMigration) *ParsedMigration {
+	return &ParsedMigration{
+		UpOptions:   migrationOptions{},
+		DownOptions: migrationOptions{},
+	}
+}
+
+// exec runs the up or down function of a Go migration, if it was registered
+func (m *goMigration) exec(tx dbutil.Transaction, direction string) error {
+	fn := m.up
+	if direction == DirectionDown {
+		fn = m.down
+	}
+	if fn == nil {
+		return nil
+	}
+
+	return fn(tx)
+}
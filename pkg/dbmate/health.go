@@ -0,0 +1,75 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HealthStatus reports the state of the database, as returned by HealthCheck
+type HealthStatus struct {
+	// Reachable is whether a connection to the database succeeded
+	Reachable bool `json:"reachable"`
+	// Latency is the time taken to connect to and ping the database
+	Latency time.Duration `json:"latency"`
+	// MigrationsTable is whether the migrations table exists
+	MigrationsTable bool `json:"migrations_table"`
+	// Pending is the number of migration files which have not been applied
+	Pending int `json:"pending"`
+}
+
+// Healthy returns whether the database is reachable and all migrations have been applied
+func (h *HealthStatus) Healthy() bool {
+	return h.Reachable && h.MigrationsTable && h.Pending == 0
+}
+
+// HealthCheck checks that the database is reachable and up to date, without modifying
+// it. It is intended to be called from an application's health check endpoint. If a
+// check fails, the error is returned along with the results of the checks before it.
+func (db *DB) HealthCheck(ctx context.Context) (*HealthStatus, error) {
+	status := &HealthStatus{}
+
+	drv, err := db.driver(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	start := time.Now()
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return status, fmt.Errorf("%w: %w", ErrCantConnect, err)
+	}
+	status.Reachable = true
+	status.Latency = time.Since(start)
+
+	tracker := db.tracker(drv)
+	status.MigrationsTable, err = tracker.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return status, err
+	}
+
+	applied := map[string]bool{}
+	if status.MigrationsTable {
+		applied, err = tracker.SelectMigrations(sqlDB, -1)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	migrations, err := db.findMigrationFiles()
+	if err != nil {
+		return status, err
+	}
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			status.Pending++
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,74 @@
+package dbmate
+
+import "time"
+
+// Migration directions reported in MigrationEvent
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// Hooks are callbacks which allow applications embedding dbmate to observe migrations
+// as they are applied or rolled back, e.g. to emit metrics
+type Hooks struct {
+	// BeforeMigration is called before each migration is applied or rolled back
+	BeforeMigration func(MigrationEvent)
+	// AfterMigration is called after each migration is applied or rolled back successfully
+	AfterMigration func(MigrationEvent)
+	// OnError is called when applying or rolling back a migration fails
+	OnError func(MigrationEvent, error)
+}
+
+// MigrationEvent describes a migration passed to Hooks
+type MigrationEvent struct {
+	// Version is the version of the migration
+	Version string
+	// FileName is the file name of the migration
+	FileName string
+	// Direction is DirectionUp when the migration is applied, or DirectionDown when it is
+	// rolled back
+	Direction string
+	// Duration is the time taken to run the migration, or zero in BeforeMigration
+	Duration time.Duration
+}
+
+// MultiHooks returns hooks which call each of hooks in turn
+func MultiHooks(hooks ...Hooks) Hooks {
+	return Hooks{
+		BeforeMigration: func(event MigrationEvent) {
+			for _, h := range hooks {
+				h.before(event)
+			}
+		},
+		AfterMigration: func(event MigrationEvent) {
+			for _, h := range hooks {
+				h.after(event, nil)
+			}
+		},
+		OnError: func(event MigrationEvent, err error) {
+			for _, h := range hooks {
+				h.after(event, err)
+			}
+		},
+	}
+}
+
+func (h Hooks) before(event MigrationEvent) {
+	if h.BeforeMigration != nil {
+		h.BeforeMigration(event)
+	}
+}
+
+// after calls AfterMigration, or OnError if err is not nil
+func (h Hooks) after(event MigrationEvent, err error) {
+	if err != nil {
+		if h.OnError != nil {
+			h.OnError(event, err)
+		}
+		return
+	}
+
+	if h.AfterMigration != nil {
+		h.AfterMigration(event)
+	}
+}
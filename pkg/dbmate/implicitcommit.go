@@ -0,0 +1,61 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrImplicitCommit is returned when ImplicitCommitPolicy is ImplicitCommitError, and a
+// migration block which runs in a transaction contains statements which commit it
+var ErrImplicitCommit = errors.New("migration contains DDL statements which commit the transaction implicitly")
+
+// Implicit commit policies, which control how Migrate and Rollback handle migration blocks
+// which run in a transaction, but cannot be rolled back as a whole
+const (
+	ImplicitCommitIgnore = "ignore"
+	ImplicitCommitWarn   = "warn"
+	ImplicitCommitError  = "error"
+)
+
+// checkImplicitCommit applies ImplicitCommitPolicy to a migration block. A block with more
+// than one statement, which runs in a transaction and contains a statement which the
+// driver commits implicitly (such as DDL in MySQL), is left partially applied if a later
+// statement fails. Blocks with the transaction:false option are not checked, since they
+// acknowledge that they are not atomic.
+func (db *DB) checkImplicitCommit(drv Driver, fileName, block string, options ParsedMigrationOptions) error {
+	committer, ok := drv.(ImplicitCommitter)
+	if !ok || !options.Transaction() || db.ImplicitCommitPolicy == ImplicitCommitIgnore {
+		return nil
+	}
+
+	statements, ok := dbutil.SplitStatementsWith(block, splitOptions(drv))
+	if !ok || len(statements) < 2 {
+		return nil
+	}
+
+	ddl := 0
+	for _, statement := range statements {
+		if committer.CommitsImplicitly(statement) {
+			ddl++
+		}
+	}
+	if ddl == 0 {
+		return nil
+	}
+
+	switch db.ImplicitCommitPolicy {
+	case "", ImplicitCommitWarn:
+		fmt.Fprintln(db.logger(LogLevelWarn), db.colorize(ColorYellow, fmt.Sprintf(
+			"Warning: %s: %d of its %d statements commit immediately, so it cannot be rolled back if a "+
+				"later statement fails (split it into one migration per DDL statement, or set transaction:false)",
+			fileName, ddl, len(statements))))
+		return nil
+	case ImplicitCommitError:
+		return fmt.Errorf("%s: %w (split it into one migration per DDL statement, or set transaction:false)",
+			fileName, ErrImplicitCommit)
+	default:
+		return fmt.Errorf("unsupported implicit commit policy: %s", db.ImplicitCommitPolicy)
+	}
+}
@@ -0,0 +1,61 @@
+package dbmate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/stretchr/testify/require"
+)
+
+// ddlCommitDriver commits implicitly before create and alter statements
+type ddlCommitDriver struct {
+	Driver
+}
+
+func (ddlCommitDriver) CommitsImplicitly(statement string) bool {
+	return strings.HasPrefix(statement, "create") || strings.HasPrefix(statement, "alter")
+}
+
+func TestCheckImplicitCommit(t *testing.T) {
+	drv := ddlCommitDriver{}
+	check := func(policy, block string) (string, error) {
+		var output strings.Builder
+		db := NewWithOptions(dbutil.MustParseURL("mysql://localhost/app"),
+			WithLogger(&output), WithImplicitCommitPolicy(policy))
+		parsed, err := parseMigrationContents(block)
+		require.NoError(t, err)
+
+		err = db.checkImplicitCommit(drv, "001_users.sql", parsed.Up, parsed.UpOptions)
+		return output.String(), err
+	}
+
+	mixed := "-- migrate:up\ncreate table users (id int);\ninsert into users values (1);\n-- migrate:down\n"
+
+	output, err := check(ImplicitCommitWarn, mixed)
+	require.NoError(t, err)
+	require.Equal(t, "Warning: 001_users.sql: 1 of its 2 statements commit immediately, so it cannot be "+
+		"rolled back if a later statement fails (split it into one migration per DDL statement, "+
+		"or set transaction:false)\n", output)
+
+	_, err = check(ImplicitCommitError, mixed)
+	require.ErrorIs(t, err, ErrImplicitCommit)
+
+	output, err = check(ImplicitCommitIgnore, mixed)
+	require.NoError(t, err)
+	require.Equal(t, "", output)
+
+	_, err = check("sometimes", mixed)
+	require.EqualError(t, err, "unsupported implicit commit policy: sometimes")
+
+	// a single statement, statements which do not commit, and transaction:false are fine
+	for _, block := range []string{
+		"-- migrate:up\ncreate table users (id int);\n-- migrate:down\n",
+		"-- migrate:up\ninsert into users values (1);\ninsert into users values (2);\n-- migrate:down\n",
+		"-- migrate:up transaction:false\ncreate table users (id int);\nalter table users add name text;\n-- migrate:down\n",
+	} {
+		output, err := check(ImplicitCommitError, block)
+		require.NoError(t, err)
+		require.Equal(t, "", output)
+	}
+}
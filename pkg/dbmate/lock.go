@@ -0,0 +1,190 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrLocked          = errors.New("migrations are locked by another process")
+	ErrLockUnsupported = errors.New("driver does not support inspecting the migration lock")
+)
+
+// LockHolder describes the process which holds the migration lock
+type LockHolder struct {
+	// Session identifies the database session which holds the lock
+	Session string
+	// Host is the hostname of the machine running the process, if it was recorded
+	Host string
+	// PID is the process ID, if it was recorded
+	PID int
+	// StartedAt is when the lock was acquired, if it was recorded
+	StartedAt time.Time
+}
+
+func (h LockHolder) String() string {
+	if h.Host == "" {
+		return fmt.Sprintf("session %s (no holder was recorded)", h.Session)
+	}
+
+	return fmt.Sprintf("%s (pid %d, session %s) since %s", h.Host, h.PID, h.Session,
+		h.StartedAt.UTC().Format(time.RFC3339))
+}
+
+// lock acquires the driver's advisory lock on a dedicated connection, and returns a
+// function which releases it. If the driver implements LockInspector, the holder is
+// recorded, and unless wait is set, a lock held by another process fails with ErrLocked
+// (describing its holder) rather than waiting for it. Drivers which do not support
+// locking are not locked.
+func (db *DB) lock(ctx context.Context, drv Driver, wait bool) (func() error, error) {
+	locker, ok := drv.(Locker)
+	if !ok {
+		return func() error { return nil }, nil
+	}
+	inspector, inspect := drv.(LockInspector)
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock is held by a single session, so must be acquired and released on one connection
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	closeConn := func() {
+		dbutil.MustClose(conn)
+		release()
+	}
+
+	if inspect && !wait {
+		acquired, err := inspector.TryLock(contextTransaction{ctx, conn})
+		if err != nil {
+			closeConn()
+			return nil, fmt.Errorf("%w: %w", ErrLockFailed, err)
+		}
+		if !acquired {
+			holder, err := inspector.LockHolder(sqlDB)
+			closeConn()
+			if err != nil || holder == nil {
+				return nil, ErrLocked
+			}
+			return nil, fmt.Errorf("%w: held by %s (if that process is no longer running, "+
+				"release the lock with dbmate unlock --force)", ErrLocked, holder)
+		}
+	} else if err := locker.Lock(contextTransaction{ctx, conn}); err != nil {
+		closeConn()
+		return nil, fmt.Errorf("%w: %w", ErrLockFailed, err)
+	}
+
+	unlock := func() error {
+		defer closeConn()
+		// release the lock even if ctx is done
+		return locker.Unlock(contextTransaction{context.Background(), conn})
+	}
+
+	if inspect {
+		host, _ := os.Hostname()
+		holder := LockHolder{Host: host, PID: os.Getpid(), StartedAt: time.Now().UTC()}
+		if err := inspector.SetLockHolder(contextTransaction{ctx, conn}, holder); err != nil {
+			_ = unlock()
+			return nil, fmt.Errorf("%w: unable to record the lock holder: %w", ErrLockFailed, err)
+		}
+	}
+
+	return unlock, nil
+}
+
+// withLock runs f while holding the driver's advisory lock, waiting for the lock if it is
+// held by another process, or simply runs f if the driver does not support locking
+func (db *DB) withLock(ctx context.Context, drv Driver, f func() error) error {
+	unlock, err := db.lock(ctx, drv, true)
+	if err != nil {
+		return err
+	}
+
+	err = f()
+	if unlockErr := unlock(); err == nil {
+		err = unlockErr
+	}
+
+	return err
+}
+
+// LockHolder returns the process which holds the migration lock, or nil if the lock is
+// not held
+func (db *DB) LockHolder() (*LockHolder, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	inspector, ok := drv.(LockInspector)
+	if !ok {
+		return nil, ErrLockUnsupported
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return inspector.LockHolder(sqlDB)
+}
+
+// CheckLock reports whether the migration lock is held, failing with ErrLocked (describing
+// its holder) if it is
+func (db *DB) CheckLock() error {
+	holder, err := db.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		fmt.Fprintln(db.logger(LogLevelInfo), "Migrations are not locked")
+		return nil
+	}
+
+	return fmt.Errorf("%w: held by %s", ErrLocked, holder)
+}
+
+// ForceUnlock releases a migration lock which is stuck, for example because the process
+// holding it hung, by terminating the database session which holds it. Any migration
+// which the session is running is rolled back (if it runs in a transaction).
+func (db *DB) ForceUnlock() error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	inspector, ok := drv.(LockInspector)
+	if !ok {
+		return ErrLockUnsupported
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	holder, err := inspector.LockHolder(sqlDB)
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		fmt.Fprintln(db.logger(LogLevelInfo), "Migrations are not locked")
+		return nil
+	}
+
+	fmt.Fprintf(db.logger(LogLevelInfo), "Unlocking: held by %s\n", holder)
+	return inspector.ForceUnlock(sqlDB, holder.Session)
+}
@@ -0,0 +1,287 @@
+package dbmate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogLevel controls which messages are written to DB.Log
+type LogLevel int
+
+// Log levels
+const (
+	LogLevelDebug LogLevel = iota - 1
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+}
+
+// ParseLogLevel parses a log level name (debug, info, warn or error)
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+
+	return LogLevelInfo, fmt.Errorf("unsupported log level: %s", s)
+}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// logger returns the writer for messages at the given level, which discards
+// them if they are below the configured LogLevel
+func (db *DB) logger(level LogLevel) io.Writer {
+	if level < db.LogLevel {
+		return io.Discard
+	}
+
+	// the JSON writer redacts each line as it emits it
+	if jw, ok := db.Log.(*JSONLogWriter); ok {
+		return jw.WithLevel(level.String())
+	}
+
+	return &redactWriter{w: db.Log}
+}
+
+var (
+	// urlPasswordRegexp matches the password in the userinfo of a URL, which may
+	// contain characters such as a forward slash if the URL is malformed
+	urlPasswordRegexp = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@\s]+@`)
+	// paramPasswordRegexp matches password query parameters and connection string keywords
+	paramPasswordRegexp = regexp.MustCompile(`(?i)((?:^|[?&;\s])(?:password|sslpassword|pass|pwd)=)[^&;\s]+`)
+)
+
+// RedactString masks passwords in any database URLs or connection strings within s,
+// so that it can be safely written to logs
+func RedactString(s string) string {
+	s = urlPasswordRegexp.ReplaceAllString(s, "${1}********@")
+	return paramPasswordRegexp.ReplaceAllString(s, "${1}********")
+}
+
+// redactWriter wraps an io.Writer, masking passwords in everything written to it. Output
+// is redacted a line at a time, so that a password written in several pieces is still
+// masked; a partial line is held back until it is completed or flushed.
+type redactWriter struct {
+	w io.Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Write buffers p, and writes each complete line with passwords masked
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+	i := bytes.LastIndexByte(r.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	lines := string(r.buf[:i+1])
+	r.buf = r.buf[i+1:]
+	if _, err := io.WriteString(r.w, RedactString(lines)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes any buffered partial line with passwords masked
+func (r *redactWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	line := string(r.buf)
+	r.buf = nil
+	if line == "" {
+		return nil
+	}
+	_, err := io.WriteString(r.w, RedactString(line))
+
+	return err
+}
+
+// flushLog writes any partial line held back by w, for output such as progress dots
+// which should be shown before the line is complete
+func flushLog(w io.Writer) {
+	if r, ok := w.(*redactWriter); ok {
+		_ = r.Flush()
+	}
+}
+
+// ANSI colors used for human readable output
+const (
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	ColorRed    = "\033[31m"
+	colorReset  = "\033[0m"
+)
+
+// Colorize wraps s in the given ANSI color
+func Colorize(color, s string) string {
+	return color + s + colorReset
+}
+
+// colorize wraps s in the given ANSI color, if color output is enabled
+func (db *DB) colorize(color, s string) string {
+	if !db.Color {
+		return s
+	}
+
+	return Colorize(color, s)
+}
+
+// JSONLogWriter wraps an io.Writer, emitting each line written to it as a JSON object
+// with time, level and msg fields, with passwords masked. It can be assigned to DB.Log so
+// that output is parseable by log pipelines.
+type JSONLogWriter struct {
+	level  string
+	w      io.Writer
+	levels *jsonLogLevels
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// jsonLogLevels holds the JSONLogWriter for each level which logs to the same writer, so
+// that each level buffers its partial lines in one place
+type jsonLogLevels struct {
+	mu      sync.Mutex
+	writers map[string]*JSONLogWriter
+}
+
+// logEntry is a single JSON log line
+type logEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// NewJSONLogWriter returns a JSONLogWriter which logs each line at the given level. Close
+// must be called when logging is finished, to emit any buffered partial lines.
+func NewJSONLogWriter(w io.Writer, level string) *JSONLogWriter {
+	j := &JSONLogWriter{level: level, w: w}
+	j.levels = &jsonLogLevels{writers: map[string]*JSONLogWriter{level: j}}
+
+	return j
+}
+
+// WithLevel returns the JSONLogWriter which logs to the same writer at a different level.
+// The same writer is returned for each call with the same level.
+func (j *JSONLogWriter) WithLevel(level string) *JSONLogWriter {
+	j.levels.mu.Lock()
+	defer j.levels.mu.Unlock()
+
+	if w, ok := j.levels.writers[level]; ok {
+		return w
+	}
+	w := &JSONLogWriter{level: level, w: j.w, levels: j.levels}
+	j.levels.writers[level] = w
+
+	return w
+}
+
+// Write buffers p, and emits a JSON object for each complete line
+func (j *JSONLogWriter) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.buf = append(j.buf, p...)
+	for {
+		i := bytes.IndexByte(j.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(j.buf[:i])
+		j.buf = j.buf[i+1:]
+		if err := j.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush emits any buffered partial line
+func (j *JSONLogWriter) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	line := string(j.buf)
+	j.buf = nil
+
+	return j.writeLine(line)
+}
+
+// Close emits any partial lines buffered by j, or by a JSONLogWriter returned by its
+// WithLevel. The underlying writer is not closed.
+func (j *JSONLogWriter) Close() error {
+	j.levels.mu.Lock()
+	writers := make([]*JSONLogWriter, 0, len(j.levels.writers))
+	for _, w := range j.levels.writers {
+		writers = append(writers, w)
+	}
+	j.levels.mu.Unlock()
+
+	// emit the levels in a stable order
+	sort.Slice(writers, func(a, b int) bool { return writers[a].level < writers[b].level })
+	var err error
+	for _, w := range writers {
+		if flushErr := w.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+
+	return err
+}
+
+func (j *JSONLogWriter) writeLine(line string) error {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		// blank lines only exist to separate human readable output
+		return nil
+	}
+
+	return WriteJSONLog(j.w, j.level, RedactString(line))
+}
+
+// WriteJSONLog writes a single JSON log entry to w
+func WriteJSONLog(w io.Writer, level, msg string) error {
+	data, err := json.Marshal(logEntry{
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+		Level: level,
+		Msg:   msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,97 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrMigrationTimeout is returned when a migration runs for longer than MaxMigrationDuration
+var ErrMigrationTimeout = errors.New("migration exceeded the maximum duration")
+
+// migrationGuard cancels a migration which runs for longer than MaxMigrationDuration.
+// The running statement is cancelled on the server where the driver supports it, and
+// the context of the migration is cancelled, which rolls back its transaction.
+type migrationGuard struct {
+	db     *DB
+	drv    Driver
+	sqlDB  *sql.DB
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	mu        sync.Mutex
+	sessionID string
+	expired   bool
+}
+
+// guardMigration returns a context for running a migration, and a guard which cancels it
+// after MaxMigrationDuration. The guard does nothing if MaxMigrationDuration is not set.
+func (db *DB) guardMigration(ctx context.Context, drv Driver, sqlDB *sql.DB) (context.Context, *migrationGuard) {
+	g := &migrationGuard{db: db, drv: drv, sqlDB: sqlDB, cancel: func() {}}
+	if db.MaxMigrationDuration <= 0 {
+		return ctx, g
+	}
+
+	ctx, g.cancel = context.WithCancel(ctx)
+	g.timer = time.AfterFunc(db.MaxMigrationDuration, g.expire)
+
+	return ctx, g
+}
+
+// watch records the session which runs the migration, so that its statement can be
+// cancelled
+func (g *migrationGuard) watch(tx dbutil.Transaction) error {
+	canceller, ok := g.drv.(QueryCanceller)
+	if g.timer == nil || !ok {
+		return nil
+	}
+
+	id, err := canceller.SessionID(tx)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sessionID = id
+
+	return nil
+}
+
+// expire cancels the migration
+func (g *migrationGuard) expire() {
+	g.mu.Lock()
+	g.expired = true
+	id := g.sessionID
+	g.mu.Unlock()
+
+	fmt.Fprintf(g.db.logger(LogLevelWarn), "Warning: cancelling migration after %s\n", g.db.MaxMigrationDuration)
+	if canceller, ok := g.drv.(QueryCanceller); ok && id != "" {
+		if err := canceller.CancelQuery(g.sqlDB, id); err != nil {
+			fmt.Fprintf(g.db.logger(LogLevelWarn), "Warning: unable to cancel the running statement: %s\n", err)
+		}
+	}
+	g.cancel()
+}
+
+// stop stops the guard once the migration has finished, and returns err, marked with
+// ErrMigrationTimeout if the migration was cancelled
+func (g *migrationGuard) stop(err error) error {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.expired && err != nil {
+		return fmt.Errorf("%w of %s: %w", ErrMigrationTimeout, g.db.MaxMigrationDuration, err)
+	}
+
+	return err
+}
@@ -0,0 +1,102 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata contains structured information about a migration, read from an optional
+// frontmatter section at the top of the migration file. Frontmatter is written as
+// YAML inside SQL comments, so that migration files remain valid SQL:
+//
+//	-- ---
+//	-- author: Jane Doe
+//	-- ticket: PROJ-123
+//	-- tags: [data, slow]
+//	-- ---
+//	-- migrate:up
+type Metadata struct {
+	Author          string                 `yaml:"author" json:"author,omitempty"`
+	Description     string                 `yaml:"description" json:"description,omitempty"`
+	Requires        []string               `yaml:"requires" json:"requires,omitempty"`
+	RequiresVersion string                 `yaml:"requires-version" json:"requires_version,omitempty"`
+	Tags            []string               `yaml:"tags" json:"tags,omitempty"`
+	Ticket          string                 `yaml:"ticket" json:"ticket,omitempty"`
+	Extra           map[string]interface{} `yaml:",inline" json:"extra,omitempty"`
+}
+
+// Error codes
+var (
+	ErrParseFrontmatter = errors.New("dbmate requires the frontmatter section to be closed with '-- ---'")
+)
+
+var (
+	frontmatterDelimiterRegExp = regexp.MustCompile(`^--\s*---\s*$`)
+	commentPrefixRegExp        = regexp.MustCompile(`^\s*--\s?`)
+)
+
+// frontmatter parser states
+const (
+	frontmatterPending = iota // no non-empty line has been read
+	frontmatterOpen           // the opening delimiter has been read
+	frontmatterDone           // the frontmatter was closed, or the file has none
+)
+
+// frontmatterParser reads the frontmatter section of a migration one line at a time, so
+// that it can be parsed in the same pass as the rest of the file. The frontmatter must be
+// the first non-empty content in the file.
+type frontmatterParser struct {
+	state int
+	// line is the line number of the opening delimiter
+	line int
+	yaml []string
+	meta Metadata
+	err  error
+}
+
+// parseLine reads line n (starting from 1) of the migration, without its newline
+func (p *frontmatterParser) parseLine(n int, line string) {
+	switch p.state {
+	case frontmatterPending:
+		if isEmptyLine(line) {
+			return
+		}
+		if !frontmatterDelimiterRegExp.MatchString(strings.TrimSpace(line)) {
+			p.state = frontmatterDone
+			return
+		}
+		p.state = frontmatterOpen
+		p.line = n
+	case frontmatterOpen:
+		line = strings.TrimRight(line, "\r")
+		if frontmatterDelimiterRegExp.MatchString(strings.TrimSpace(line)) {
+			p.state = frontmatterDone
+			if err := yaml.Unmarshal([]byte(strings.Join(p.yaml, "\n")), &p.meta); err != nil {
+				p.err = fmt.Errorf("dbmate could not parse frontmatter: %w", err)
+			}
+			return
+		}
+
+		if !isEmptyLine(line) && !isCommentLine(line) {
+			p.state = frontmatterDone
+			p.err = ErrParseFrontmatter
+			return
+		}
+		p.yaml = append(p.yaml, commentPrefixRegExp.ReplaceAllString(line, ""))
+	}
+}
+
+// finish returns the parsed metadata once every line has been read, along with the line
+// number where the frontmatter starts, for reporting errors
+func (p *frontmatterParser) finish() (Metadata, int, error) {
+	if p.state == frontmatterOpen {
+		p.state = frontmatterDone
+		p.err = ErrParseFrontmatter
+	}
+
+	return p.meta, p.line, p.err
+}
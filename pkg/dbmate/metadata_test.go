@@ -0,0 +1,90 @@
+package dbmate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrontmatterParser(t *testing.T) {
+	parse := func(contents string) (Metadata, int, error) {
+		p := frontmatterParser{}
+		for i, line := range strings.Split(contents, "\n") {
+			p.parseLine(i+1, line)
+		}
+
+		return p.finish()
+	}
+
+	t.Run("no frontmatter", func(t *testing.T) {
+		meta, _, err := parse("-- migrate:up\ncreate table users (id serial);\n-- migrate:down\n")
+		require.NoError(t, err)
+		require.Equal(t, Metadata{}, meta)
+	})
+
+	t.Run("commented yaml", func(t *testing.T) {
+		meta, line, err := parse(`
+-- ---
+-- author: Jane Doe
+-- ticket: PROJ-123
+-- tags: [data, slow]
+-- requires-version: ">=2.4"
+-- reviewers:
+--   - alice
+--   - bob
+-- ---
+-- migrate:up
+`)
+		require.NoError(t, err)
+		require.Equal(t, 2, line)
+		require.Equal(t, "Jane Doe", meta.Author)
+		require.Equal(t, "PROJ-123", meta.Ticket)
+		require.Equal(t, []string{"data", "slow"}, meta.Tags)
+		require.Equal(t, ">=2.4", meta.RequiresVersion)
+		require.Equal(t, map[string]interface{}{"reviewers": []interface{}{"alice", "bob"}}, meta.Extra)
+	})
+
+	t.Run("must be at the top of the file", func(t *testing.T) {
+		meta, _, err := parse("-- comment\n-- ---\n-- author: Jane Doe\n-- ---\n")
+		require.NoError(t, err)
+		require.Equal(t, Metadata{}, meta)
+	})
+}
+
+func TestParseMigrationContentsFrontmatterErrors(t *testing.T) {
+	t.Run("unclosed", func(t *testing.T) {
+		_, err := parseMigrationContents("\n-- ---\n-- author: Jane Doe\n-- migrate:up\n" +
+			"create table users (id serial);\n-- migrate:down\n")
+		require.ErrorIs(t, err, ErrParseFrontmatter)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		require.Equal(t, 2, parseErr.Line)
+	})
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		_, err := parseMigrationContents("-- ---\n-- tags: [data\n-- ---\n-- migrate:up\n-- migrate:down\n")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "dbmate could not parse frontmatter")
+	})
+}
+
+func TestParseMigrationContentsFrontmatter(t *testing.T) {
+	migration := `-- ---
+-- description: Backfills user status
+-- ticket: PROJ-123
+-- tags: [data]
+-- ---
+-- migrate:up tags:slow
+update users set status = 'active';
+-- migrate:down
+`
+
+	parsed, err := parseMigrationContents(migration)
+	require.NoError(t, err)
+	require.Equal(t, "Backfills user status", parsed.Description)
+	require.Equal(t, "PROJ-123", parsed.Metadata.Ticket)
+	require.Equal(t, []string{"data", "slow"}, parsed.upTags())
+	require.Equal(t, []string{"data"}, parsed.Metadata.Tags)
+	require.Equal(t, "-- migrate:up tags:slow\nupdate users set status = 'active';\n", parsed.Up)
+}
@@ -0,0 +1,131 @@
+package dbmate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsDurationBuckets are the upper bounds of the migration duration histogram, in seconds
+var metricsDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800}
+
+// Metrics collects Prometheus metrics about applied migrations. Assign the result of
+// Hooks to DB.Hooks to record migrations, then expose the metrics with WriteTo or Push.
+type Metrics struct {
+	mu            sync.Mutex
+	pending       int
+	pendingKnown  bool
+	durations     []float64
+	lastMigration time.Time
+	failures      int
+}
+
+// NewMetrics returns an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Hooks returns hooks which record each applied migration, and each failure
+func (m *Metrics) Hooks() Hooks {
+	return Hooks{
+		AfterMigration: func(event MigrationEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.durations = append(m.durations, event.Duration.Seconds())
+			m.lastMigration = time.Now()
+		},
+		OnError: func(MigrationEvent, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.failures++
+		},
+	}
+}
+
+// SetPending records the number of pending migrations
+func (m *Metrics) SetPending(pending int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = pending
+	m.pendingKnown = true
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	if m.pendingKnown {
+		writeMetricHeader(&buf, "dbmate_pending_migrations", "gauge", "Number of migrations which have not been applied.")
+		fmt.Fprintf(&buf, "dbmate_pending_migrations %d\n", m.pending)
+	}
+
+	writeMetricHeader(&buf, "dbmate_migration_duration_seconds", "histogram", "Time taken to apply each migration.")
+	sum := 0.0
+	for _, d := range m.durations {
+		sum += d
+	}
+	for _, bucket := range metricsDurationBuckets {
+		count := 0
+		for _, d := range m.durations {
+			if d <= bucket {
+				count++
+			}
+		}
+		fmt.Fprintf(&buf, "dbmate_migration_duration_seconds_bucket{le=\"%g\"} %d\n", bucket, count)
+	}
+	fmt.Fprintf(&buf, "dbmate_migration_duration_seconds_bucket{le=\"+Inf\"} %d\n", len(m.durations))
+	fmt.Fprintf(&buf, "dbmate_migration_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(&buf, "dbmate_migration_duration_seconds_count %d\n", len(m.durations))
+
+	if !m.lastMigration.IsZero() {
+		writeMetricHeader(&buf, "dbmate_last_migration_timestamp_seconds", "gauge", "Time the last migration was applied, in seconds since the epoch.")
+		fmt.Fprintf(&buf, "dbmate_last_migration_timestamp_seconds %d\n", m.lastMigration.Unix())
+	}
+
+	writeMetricHeader(&buf, "dbmate_migration_failures_total", "counter", "Number of migrations which failed.")
+	fmt.Fprintf(&buf, "dbmate_migration_failures_total %d\n", m.failures)
+
+	return buf.WriteTo(w)
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// Push sends the metrics to a Prometheus Pushgateway at gatewayURL (e.g.
+// http://localhost:9091), replacing any metrics previously pushed for the dbmate job
+func (m *Metrics) Push(gatewayURL string) error {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return err
+	}
+
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/dbmate"
+	req, err := http.NewRequest(http.MethodPut, pushURL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unable to push metrics to %s: %s", RedactString(pushURL), resp.Status)
+	}
+
+	return nil
+}
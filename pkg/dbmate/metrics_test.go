@@ -0,0 +1,61 @@
+package dbmate
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	hooks := m.Hooks()
+	hooks.after(MigrationEvent{Version: "001", Duration: 200 * time.Millisecond}, nil)
+	hooks.after(MigrationEvent{Version: "002", Duration: 2 * time.Second}, nil)
+	hooks.after(MigrationEvent{Version: "003"}, errors.New("failed"))
+	m.SetPending(1)
+
+	var output strings.Builder
+	_, err := m.WriteTo(&output)
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "# TYPE dbmate_pending_migrations gauge\ndbmate_pending_migrations 1\n")
+	require.Contains(t, output.String(), "dbmate_migration_duration_seconds_bucket{le=\"0.1\"} 0\n"+
+		"dbmate_migration_duration_seconds_bucket{le=\"0.5\"} 1\n"+
+		"dbmate_migration_duration_seconds_bucket{le=\"1\"} 1\n"+
+		"dbmate_migration_duration_seconds_bucket{le=\"5\"} 2\n")
+	require.Contains(t, output.String(), "dbmate_migration_duration_seconds_bucket{le=\"+Inf\"} 2\n"+
+		"dbmate_migration_duration_seconds_sum 2.2\n"+
+		"dbmate_migration_duration_seconds_count 2\n")
+	require.Contains(t, output.String(), "dbmate_last_migration_timestamp_seconds ")
+	require.Contains(t, output.String(), "# TYPE dbmate_migration_failures_total counter\ndbmate_migration_failures_total 1\n")
+
+	t.Run("push", func(t *testing.T) {
+		var body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPut, r.Method)
+			require.Equal(t, "/metrics/job/dbmate", r.URL.Path)
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			body = string(data)
+		}))
+		defer server.Close()
+
+		require.NoError(t, m.Push(server.URL+"/"))
+		require.Equal(t, output.String(), body)
+	})
+
+	t.Run("push error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		err := m.Push(server.URL)
+		require.EqualError(t, err, "unable to push metrics to "+server.URL+"/metrics/job/dbmate: 400 Bad Request")
+	})
+}
@@ -1,53 +1,164 @@
 package dbmate
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 )
 
 // Migration represents an available migration and status
 type Migration struct {
-	Applied  bool
-	FileName string
-	FilePath string
-	FS       fs.FS
-	Version  string
+	Applied     bool            `json:"applied"`
+	Description string          `json:"description,omitempty"`
+	FileName    string          `json:"file_name"`
+	FilePath    string          `json:"file_path"`
+	FS          fs.FS           `json:"-"`
+	Metadata    Metadata        `json:"metadata"`
+	Source      MigrationSource `json:"-"`
+	Version     string          `json:"version"`
+
+	goMigration *goMigration
+	// cache holds the contents and parse result of the file while a command runs
+	cache *migrationCache
 }
 
-func (m *Migration) readFile() (string, error) {
-	if m.FS == nil {
-		bytes, err := os.ReadFile(m.FilePath)
-		return string(bytes), err
+// readFile returns the contents of the migration file, except for the body of an up
+// block with the stream option, and the size of the omitted body (see readMigration)
+func (m *Migration) readFile() (string, omittedBody, error) {
+	if m.cache != nil {
+		return m.cache.entry(m.FilePath).read(m.readUncachedFile)
+	}
+
+	return m.readUncachedFile()
+}
+
+func (m *Migration) readUncachedFile() (string, omittedBody, error) {
+	f, err := m.open()
+	if err != nil {
+		return "", omittedBody{}, err
+	}
+	defer dbutil.MustClose(f)
+
+	return readMigration(f)
+}
+
+// open opens the migration file for reading, reading it into memory if its source does
+// not implement MigrationOpener
+func (m *Migration) open() (io.ReadCloser, error) {
+	source := m.Source
+	if source == nil {
+		source = NewFSSource(m.FS)
+	}
+
+	if opener, ok := source.(MigrationOpener); ok {
+		return opener.Open(m.FilePath)
+	}
+
+	data, err := source.Read(m.FilePath)
+	if err != nil {
+		return nil, err
 	}
 
-	bytes, err := fs.ReadFile(m.FS, m.FilePath)
-	return string(bytes), err
+	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
 // Parse a migration
 func (m *Migration) Parse() (*ParsedMigration, error) {
-	contents, err := m.readFile()
+	if m.goMigration != nil {
+		return parsedGoMigration(m.goMigration), nil
+	}
+
+	var parsed *ParsedMigration
+	var err error
+	if m.cache != nil {
+		parsed, err = m.cache.entry(m.FilePath).parse(m.parseFile)
+	} else {
+		parsed, err = m.parseFile()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return parseMigrationContents(contents)
+	m.Description = parsed.Description
+	m.Metadata = parsed.Metadata
+	return parsed, nil
+}
+
+func (m *Migration) parseFile() (*ParsedMigration, error) {
+	contents, omitted, err := m.readFile()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseMigrationContents(contents)
+	if err != nil {
+		return nil, newParseError(m.FileName, err)
+	}
+
+	// the omitted body of a streamed up block precedes the down block
+	parsed.UpRange.End += omitted.bytes
+	parsed.DownRange.Start += omitted.bytes
+	parsed.DownRange.End += omitted.bytes
+	parsed.DownRange.Line += omitted.lines
+
+	return parsed, nil
 }
 
 // ParsedMigration contains the migration contents and options
 type ParsedMigration struct {
+	Description string
+	Metadata    Metadata
 	Up          string
 	UpOptions   ParsedMigrationOptions
+	UpRange     BlockRange
 	Down        string
 	DownOptions ParsedMigrationOptions
+	DownRange   BlockRange
+}
+
+// BlockRange is the location of a migration block within its file, as byte offsets.
+// The block starts at its '-- migrate:' directive and ends before the next block.
+type BlockRange struct {
+	Start int
+	End   int
+	// Line is the line number of the block's directive, starting from 1
+	Line int
+}
+
+// ParseMigrationFile parses the migration file at path. It allows external tools such as
+// linters and editors to reuse the same parser as dbmate.
+func ParseMigrationFile(path string) (*ParsedMigration, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseMigrationContents(string(contents))
+	if err != nil {
+		return nil, newParseError(filepath.Base(path), err)
+	}
+
+	return parsed, nil
 }
 
 // ParsedMigrationOptions is an interface for accessing migration options
 type ParsedMigrationOptions interface {
 	Transaction() bool
+	Tags() []string
+	Schema() string
+	Shards() []string
+	LockTimeout() string
+	StatementTimeout() string
+	Stream() bool
 }
 
 type migrationOptions map[string]string
@@ -58,15 +169,62 @@ func (m migrationOptions) Transaction() bool {
 	return m["transaction"] != "false"
 }
 
-var (
-	upRegExp              = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
-	downRegExp            = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)$`)
-	emptyLineRegExp       = regexp.MustCompile(`^\s*$`)
-	commentLineRegExp     = regexp.MustCompile(`^\s*--`)
-	whitespaceRegExp      = regexp.MustCompile(`\s+`)
-	optionSeparatorRegExp = regexp.MustCompile(`:`)
-	blockDirectiveRegExp  = regexp.MustCompile(`^--\s*migrate:[up|down]]`)
-)
+// Schema returns the schema (or database, for MySQL) which this block should run against,
+// or an empty string to use the default. Defaults to "".
+func (m migrationOptions) Schema() string {
+	if m["schema"] != "" {
+		return m["schema"]
+	}
+
+	return m["database"]
+}
+
+// Tags returns the list of tags assigned to this migration, e.g. "tags:data,slow"
+func (m migrationOptions) Tags() []string {
+	return m.list("tags")
+}
+
+// Shards returns the list of shards which this block should run against, e.g.
+// "shard:eu,us", or an empty list to run against every database
+func (m migrationOptions) Shards() []string {
+	return m.list("shard")
+}
+
+// LockTimeout returns the lock_timeout option, e.g. "lock_timeout:5s", or an empty string
+// to use DB.LockTimeout
+func (m migrationOptions) LockTimeout() string {
+	return m[TimeoutLock]
+}
+
+// StatementTimeout returns the statement_timeout option, e.g. "statement_timeout:1m", or
+// an empty string to use DB.StatementTimeout
+func (m migrationOptions) StatementTimeout() string {
+	return m[TimeoutStatement]
+}
+
+// Stream returns whether the statements in this block are read from the migration file
+// and executed one at a time, rather than read into memory, e.g. "stream:true". Only up
+// blocks can be streamed. Defaults to false.
+func (m migrationOptions) Stream() bool {
+	return m["stream"] == "true"
+}
+
+// list splits a comma-separated option into a list
+func (m migrationOptions) list(key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(m[key], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+var directiveRegExp = regexp.MustCompile(`^--\s*migrate:([a-z-]+)(?:\s+(.*?))?\s*$`)
+
+// whitespace is the characters matched by \s in regular expressions
+const whitespace = " \t\n\f\r"
 
 // Error codes
 var (
@@ -74,104 +232,221 @@ var (
 	ErrParseMissingDown    = errors.New("dbmate requires each migration to define a down block with '-- migrate:down'")
 	ErrParseWrongOrder     = errors.New("dbmate requires '-- migrate:up' to appear before '-- migrate:down'")
 	ErrParseUnexpectedStmt = errors.New("dbmate does not support statements preceding the '-- migrate:up' block")
+	ErrParseVersion        = errors.New("this migration requires a different version of dbmate")
 )
 
-// parseMigrationContents parses the string contents of a migration.
-// It will return two Migration objects, the first representing the "up"
-// block and the second representing the "down" block. This function
-// requires that at least an up block was defined and will otherwise
-// return an error.
+// ParseError is returned when a migration file cannot be parsed
+type ParseError struct {
+	FileName string
+	// Line is the line number of the problem within the file, or 0 if it does not
+	// relate to a specific line
+	Line int
+	Err  error
+}
+
+// newParseError attaches fileName to an error returned by parseMigrationContents
+func newParseError(fileName string, err error) *ParseError {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return &ParseError{FileName: fileName, Line: parseErr.Line, Err: parseErr.Err}
+	}
+
+	return &ParseError{FileName: fileName, Err: err}
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationError is returned when the SQL in a migration fails to execute
+type MigrationError struct {
+	FileName string
+	Err      error
+}
+
+func (e *MigrationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// blockDirective is the '-- migrate:up' or '-- migrate:down' directive of a block
+type blockDirective struct {
+	offset  int
+	line    int
+	options string
+}
+
+// versionConstraint is a version of dbmate required by a migration, and the line which
+// requires it
+type versionConstraint struct {
+	constraint string
+	line       int
+}
+
+// parseMigrationContents parses the string contents of a migration in a single pass over
+// its lines, reading the frontmatter, directives and block boundaries as it goes. The up
+// block starts at the first '-- migrate:up' directive and ends at the first
+// '-- migrate:down' directive, which starts the down block. This function requires that
+// both blocks are defined, in that order, and will otherwise return an error.
 func parseMigrationContents(contents string) (*ParsedMigration, error) {
-	upDirectiveStart, hasDefinedUpBlock := getMatchPosition(contents, upRegExp)
-	downDirectiveStart, hasDefinedDownBlock := getMatchPosition(contents, downRegExp)
+	var (
+		frontmatter frontmatterParser
+		up, down    *blockDirective
+		description string
+		requires    []string
+		constraints []versionConstraint
+		// statementLine is the line of the first statement preceding the up directive
+		statementLine int
+	)
+
+	for offset, n := 0, 1; offset < len(contents); n++ {
+		end := len(contents)
+		if i := strings.IndexByte(contents[offset:], '\n'); i >= 0 {
+			end = offset + i + 1
+		}
+		line := strings.TrimSuffix(contents[offset:end], "\n")
 
-	if !hasDefinedUpBlock {
+		frontmatter.parseLine(n, line)
+		if up == nil && statementLine == 0 && !isEmptyLine(line) && !isCommentLine(line) {
+			statementLine = n
+		}
+
+		switch name, args := parseDirective(line); {
+		case name == "up" && up == nil:
+			up = &blockDirective{offset: offset, line: n, options: args}
+		case name == "down" && down == nil:
+			down = &blockDirective{offset: offset, line: n, options: args}
+		case name == "description" && description == "":
+			description = args
+		case name == "requires":
+			requires = append(requires, strings.FieldsFunc(args, func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})...)
+		case name == "requires-version" && args != "":
+			constraints = append(constraints, versionConstraint{constraint: args, line: n})
+		}
+
+		offset = end
+	}
+
+	metadata, frontmatterLine, err := frontmatter.finish()
+	if err != nil {
+		return nil, &ParseError{Line: frontmatterLine, Err: err}
+	}
+
+	// check version requirements first, since newer migrations may use syntax we don't understand
+	if metadata.RequiresVersion != "" {
+		constraints = append(constraints, versionConstraint{constraint: metadata.RequiresVersion, line: frontmatterLine})
+	}
+	for _, c := range constraints {
+		if err := checkRequiredVersion(c.constraint); err != nil {
+			return nil, &ParseError{Line: c.line, Err: err}
+		}
+	}
+
+	if up == nil {
 		return nil, ErrParseMissingUp
 	}
-	if !hasDefinedDownBlock {
+	if down == nil {
 		return nil, ErrParseMissingDown
 	}
-	if upDirectiveStart > downDirectiveStart {
-		return nil, ErrParseWrongOrder
+	if up.offset > down.offset {
+		return nil, &ParseError{Line: down.line, Err: ErrParseWrongOrder}
 	}
-	if statementsPrecedeMigrateBlocks(contents, upDirectiveStart) {
-		return nil, ErrParseUnexpectedStmt
+	if statementLine > 0 {
+		return nil, &ParseError{Line: statementLine, Err: ErrParseUnexpectedStmt}
 	}
 
-	upBlock := substring(contents, upDirectiveStart, downDirectiveStart)
-	downBlock := substring(contents, downDirectiveStart, len(contents))
-
 	parsed := ParsedMigration{
-		Up:          upBlock,
-		UpOptions:   parseMigrationOptions(upBlock),
-		Down:        downBlock,
-		DownOptions: parseMigrationOptions(downBlock),
+		Description: description,
+		Metadata:    metadata,
+		Up:          contents[up.offset:down.offset],
+		UpOptions:   parseMigrationOptions(up.options),
+		UpRange:     BlockRange{Start: up.offset, End: down.offset, Line: up.line},
+		Down:        contents[down.offset:],
+		DownOptions: parseMigrationOptions(down.options),
+		DownRange:   BlockRange{Start: down.offset, End: len(contents), Line: down.line},
+	}
+	if parsed.Description == "" {
+		parsed.Description = metadata.Description
 	}
+	parsed.Metadata.Requires = append(parsed.Metadata.Requires, requires...)
 	return &parsed, nil
 }
 
-// parseMigrationOptions parses the migration options out of a block
-// directive into an object that implements the MigrationOptions interface.
-//
-// For example:
-//
-//	fmt.Printf("%#v", parseMigrationOptions("-- migrate:up transaction:false"))
-//	// migrationOptions{"transaction": "false"}
-func parseMigrationOptions(contents string) ParsedMigrationOptions {
-	options := make(migrationOptions)
+// upTags returns the tags assigned to a migration in its frontmatter or up block options
+func (p *ParsedMigration) upTags() []string {
+	tags := append([]string{}, p.Metadata.Tags...)
+	return append(tags, p.UpOptions.Tags()...)
+}
 
-	// remove everything after first newline
-	contents = strings.SplitN(contents, "\n", 2)[0]
+// checkRequiredVersion verifies that this version of dbmate satisfies a version constraint
+// defined by the '-- migrate:requires-version' directive or the frontmatter
+func checkRequiredVersion(constraint string) error {
+	ok, err := satisfiesVersion(Version, constraint)
+	if err != nil {
+		return fmt.Errorf("dbmate could not parse required version: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w (requires %s, running %s)", ErrParseVersion, constraint, Version)
+	}
 
-	// strip away the -- migrate:[up|down] part
-	contents = blockDirectiveRegExp.ReplaceAllString(contents, "")
+	return nil
+}
 
-	// remove leading and trailing whitespace
-	contents = strings.TrimSpace(contents)
+// parseDirective returns the name and arguments of a '-- migrate:<name>' directive line
+// (without its newline), or empty strings if line is not a directive
+func parseDirective(line string) (string, string) {
+	if !strings.HasPrefix(line, "--") || !strings.Contains(line, "migrate:") {
+		return "", ""
+	}
 
-	// return empty options if nothing is left to parse
-	if contents == "" {
-		return options
+	match := directiveRegExp.FindStringSubmatch(line)
+	if match == nil {
+		return "", ""
 	}
 
-	// split the options string into pairs, e.g. "transaction:false foo:bar" -> []string{"transaction:false", "foo:bar"}
-	stringPairs := whitespaceRegExp.Split(contents, -1)
+	return match[1], match[2]
+}
 
-	for _, stringPair := range stringPairs {
+// parseMigrationOptions parses the options following a block directive into an object
+// that implements the MigrationOptions interface.
+//
+// For example:
+//
+//	fmt.Printf("%#v", parseMigrationOptions("transaction:false"))
+//	// migrationOptions{"transaction": "false"}
+func parseMigrationOptions(options string) ParsedMigrationOptions {
+	parsed := make(migrationOptions)
+
+	// split the options string into pairs, e.g. "transaction:false foo:bar" -> []string{"transaction:false", "foo:bar"}
+	for _, stringPair := range strings.Fields(options) {
 		// split stringified pair into key and value pairs, e.g. "transaction:false" -> []string{"transaction", "false"}
-		pair := optionSeparatorRegExp.Split(stringPair, -1)
+		pair := strings.Split(stringPair, ":")
 
 		// if the syntax is well-formed, then store the key and value pair in options
 		if len(pair) == 2 {
-			options[pair[0]] = pair[1]
+			parsed[pair[0]] = pair[1]
 		}
 	}
 
-	return options
+	return parsed
 }
 
-// statementsPrecedeMigrateBlocks inspects the contents between the first character
-// of a string and the index of the first block directive to see if there are any statements
-// defined outside of the block directive. It'll return true if it finds any such statements.
-//
-// For example:
-//
-// This will return false:
-//
-// statementsPrecedeMigrateBlocks(`-- migrate:up
-// create table users (id serial);
-// `, 0, -1)
-//
-// This will return true:
-//
-// statementsPrecedeMigrateBlocks(`create type status_type as enum('active', 'inactive');
-// -- migrate:up
-// create table users (id serial, status status_type);
-// `, 54, -1)
-func statementsPrecedeMigrateBlocks(contents string, upDirectiveStart int) bool {
-	lines := strings.Split(contents[0:upDirectiveStart], "\n")
-
-	for _, line := range lines {
+// blockHasStatements will return true if a migration block contains anything
+// other than its directive, empty lines and comments
+func blockHasStatements(block string) bool {
+	lines := strings.Split(block, "\n")
+
+	// skip the -- migrate:[up|down] directive
+	for _, line := range lines[1:] {
 		if isEmptyLine(line) || isCommentLine(line) {
 			continue
 		}
@@ -184,25 +459,10 @@ func statementsPrecedeMigrateBlocks(contents string, upDirectiveStart int) bool
 // isEmptyLine will return true if the line has no
 // characters or if all the characters are whitespace characters
 func isEmptyLine(s string) bool {
-	return emptyLineRegExp.MatchString(s)
+	return strings.TrimLeft(s, whitespace) == ""
 }
 
 // isCommentLine will return true if the line is a SQL comment
 func isCommentLine(s string) bool {
-	return commentLineRegExp.MatchString(s)
-}
-
-func getMatchPosition(s string, re *regexp.Regexp) (int, bool) {
-	match := re.FindStringIndex(s)
-	if match == nil {
-		return -1, false
-	}
-	return match[0], true
-}
-
-func substring(s string, begin, end int) string {
-	if begin == -1 || end == -1 {
-		return ""
-	}
-	return s[begin:end]
+	return strings.HasPrefix(strings.TrimLeft(s, whitespace), "--")
 }
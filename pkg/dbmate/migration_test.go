@@ -1,6 +1,8 @@
 package dbmate
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
 
@@ -28,10 +30,70 @@ drop table users;
 
 	parsed, err := migration.Parse()
 	require.Nil(t, err)
+	require.Equal(t, "", parsed.Description)
 	require.Equal(t, "-- migrate:up\ncreate table users (id serial, name text);\n", parsed.Up)
 	require.True(t, parsed.UpOptions.Transaction())
 	require.Equal(t, "-- migrate:down\ndrop table users;\n", parsed.Down)
 	require.True(t, parsed.DownOptions.Transaction())
+
+	// description is copied to the migration
+	fs["bar/123_foo.sql"].Data = append([]byte("-- migrate:description Creates users\n"), fs["bar/123_foo.sql"].Data...)
+	parsed, err = migration.Parse()
+	require.Nil(t, err)
+	require.Equal(t, "Creates users", parsed.Description)
+	require.Equal(t, "Creates users", migration.Description)
+}
+
+func TestParseMigrationFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "123_foo.sql")
+	contents := "-- migrate:description Creates users\n-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	parsed, err := ParseMigrationFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "Creates users", parsed.Description)
+	require.Equal(t, BlockRange{Start: 37, End: 84, Line: 2}, parsed.UpRange)
+	require.Equal(t, parsed.Up, contents[parsed.UpRange.Start:parsed.UpRange.End])
+	require.Equal(t, BlockRange{Start: 84, End: len(contents), Line: 4}, parsed.DownRange)
+	require.Equal(t, parsed.Down, contents[parsed.DownRange.Start:parsed.DownRange.End])
+
+	require.NoError(t, os.WriteFile(path, []byte("create table users (id integer);\n"), 0o644))
+	_, err = ParseMigrationFile(path)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "123_foo.sql", parseErr.FileName)
+	require.ErrorIs(t, err, ErrParseMissingUp)
+
+	_, err = ParseMigrationFile(filepath.Join(t.TempDir(), "missing.sql"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseErrorLine(t *testing.T) {
+	cases := []struct {
+		contents string
+		err      error
+		line     int
+	}{
+		{"\n-- ---\n-- author: Jane Doe\n-- migrate:up\n-- migrate:down\n", ErrParseFrontmatter, 2},
+		{"-- migrate:down\ndrop table users;\n-- migrate:up\n", ErrParseWrongOrder, 1},
+		{"-- comment\n\ncreate table users (id integer);\n-- migrate:up\n-- migrate:down\n", ErrParseUnexpectedStmt, 3},
+		{"create table users (id integer);\n", ErrParseMissingUp, 0},
+		{"-- migrate:description Creates users\n-- migrate:requires-version >=99\n-- migrate:up\n", ErrParseVersion, 2},
+		{"\n-- ---\n-- requires-version: \">=99\"\n-- ---\n-- migrate:up\n-- migrate:down\n", ErrParseVersion, 2},
+	}
+
+	for _, c := range cases {
+		m := Migration{FileName: "001_test.sql", FilePath: "001_test.sql", FS: fstest.MapFS{
+			"001_test.sql": {Data: []byte(c.contents)},
+		}}
+
+		_, err := m.Parse()
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		require.ErrorIs(t, err, c.err)
+		require.Equal(t, "001_test.sql", parseErr.FileName)
+		require.Equal(t, c.line, parseErr.Line)
+	}
 }
 
 func TestParseMigrationContents(t *testing.T) {
@@ -100,6 +162,176 @@ ALTER TYPE colors ADD VALUE 'orange' AFTER 'red';
 		require.Equal(t, false, parsed.DownOptions.Transaction())
 	})
 
+	t.Run("support multiple options on each block", func(t *testing.T) {
+		migration := `-- migrate:up transaction:false lock_timeout:5s
+create index concurrently users_email on users (email);
+-- migrate:down   transaction:false  lock_timeout:1s  
+drop index concurrently users_email;
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, false, parsed.UpOptions.Transaction())
+		require.Equal(t, "5s", parsed.UpOptions.LockTimeout())
+		require.Equal(t, false, parsed.DownOptions.Transaction())
+		require.Equal(t, "1s", parsed.DownOptions.LockTimeout())
+		require.Equal(t, BlockRange{Start: 0, End: len(parsed.Up), Line: 1}, parsed.UpRange)
+		require.Equal(t, 3, parsed.DownRange.Line)
+	})
+
+	t.Run("only the first directive starts a block", func(t *testing.T) {
+		migration := `-- migrate:up
+create table users (id integer);
+-- migrate:up
+-- migrate:down
+drop table users;
+-- migrate:down transaction:false
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, "-- migrate:up\ncreate table users (id integer);\n-- migrate:up\n", parsed.Up)
+		require.Equal(t, "-- migrate:down\ndrop table users;\n-- migrate:down transaction:false\n", parsed.Down)
+		require.Equal(t, true, parsed.DownOptions.Transaction())
+	})
+
+	t.Run("support migration tags", func(t *testing.T) {
+		migration := `-- migrate:up tags:data,slow transaction:false
+UPDATE users SET status = 'active';
+-- migrate:down
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, []string{"data", "slow"}, parsed.UpOptions.Tags())
+		require.Equal(t, false, parsed.UpOptions.Transaction())
+		require.Equal(t, []string{}, parsed.DownOptions.Tags())
+	})
+
+	t.Run("support schema override", func(t *testing.T) {
+		migration := `-- migrate:up schema:analytics
+create table events (id serial);
+-- migrate:down database:analytics
+drop table events;
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, "analytics", parsed.UpOptions.Schema())
+		require.Equal(t, "analytics", parsed.DownOptions.Schema())
+	})
+
+	t.Run("support shard option", func(t *testing.T) {
+		migration := `-- migrate:up shard:eu,uk
+create table consents (id serial);
+-- migrate:down
+drop table consents;
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, []string{"eu", "uk"}, parsed.UpOptions.Shards())
+		require.Equal(t, []string{}, parsed.DownOptions.Shards())
+	})
+
+	t.Run("support timeout options", func(t *testing.T) {
+		migration := `-- migrate:up transaction:false lock_timeout:5s statement_timeout:0
+create index concurrently users_email on users (email);
+-- migrate:down
+drop index users_email;
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, "5s", parsed.UpOptions.LockTimeout())
+		require.Equal(t, "0", parsed.UpOptions.StatementTimeout())
+		require.Equal(t, "", parsed.DownOptions.LockTimeout())
+		require.Equal(t, "", parsed.DownOptions.StatementTimeout())
+	})
+
+	t.Run("support migration description", func(t *testing.T) {
+		migration := `-- migrate:description   Adds soft-delete columns  
+-- migrate:up
+alter table users add column deleted_at timestamp;
+-- migrate:down
+alter table users drop column deleted_at;
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, "Adds soft-delete columns", parsed.Description)
+		require.Equal(t, "-- migrate:up\nalter table users add column deleted_at timestamp;\n", parsed.Up)
+	})
+
+	t.Run("support required migrations", func(t *testing.T) {
+		migration := `-- ---
+-- requires: ["20230101000000"]
+-- ---
+-- migrate:requires 20230102000000, 20230103000000
+-- migrate:requires 20230104000000
+-- migrate:up
+-- migrate:down
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+		require.Equal(t, []string{"20230101000000", "20230102000000", "20230103000000", "20230104000000"},
+			parsed.Metadata.Requires)
+	})
+
+	t.Run("support required version", func(t *testing.T) {
+		migration := `-- migrate:requires-version >=1.0, <99
+-- migrate:up
+create table users (id serial);
+-- migrate:down
+drop table users;
+`
+
+		_, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+	})
+
+	t.Run("reject unsupported version before parsing blocks", func(t *testing.T) {
+		migration := `-- migrate:requires-version >=99.0
+-- migrate:up future-option:true
+create table users (id serial);
+`
+
+		_, err := parseMigrationContents(migration)
+		require.ErrorIs(t, err, ErrParseVersion)
+		require.Contains(t, err.Error(), "requires >=99.0, running "+Version)
+	})
+
+	t.Run("reject unsupported version in frontmatter", func(t *testing.T) {
+		migration := `-- ---
+-- requires-version: ">=99"
+-- ---
+-- migrate:up
+-- migrate:down
+`
+
+		_, err := parseMigrationContents(migration)
+		require.ErrorIs(t, err, ErrParseVersion)
+	})
+
+	t.Run("reject invalid required version", func(t *testing.T) {
+		migration := `-- migrate:requires-version >=two
+-- migrate:up
+-- migrate:down
+`
+
+		_, err := parseMigrationContents(migration)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "could not parse required version")
+	})
+
 	t.Run("require migrate blocks", func(t *testing.T) {
 		migration := `
 ALTER TABLE users
@@ -168,3 +400,49 @@ DROP COLUMN status;
 		require.Error(t, err, "dbmate does not support statements preceding the '-- migrate:up' block")
 	})
 }
+
+func TestBlockHasStatements(t *testing.T) {
+	require.False(t, blockHasStatements("-- migrate:down"))
+	require.False(t, blockHasStatements("-- migrate:down\n\n"))
+	require.False(t, blockHasStatements("-- migrate:down\n-- irreversible\n  \n"))
+	require.True(t, blockHasStatements("-- migrate:down\ndrop table users;\n"))
+	require.True(t, blockHasStatements("-- migrate:down transaction:false\n-- comment\ndrop table users;"))
+}
+
+func TestSatisfiesVersion(t *testing.T) {
+	cases := []struct {
+		version    string
+		constraint string
+		expected   bool
+	}{
+		{"2.3.0", ">=2.0", true},
+		{"2.3.0", "2.3", true},
+		{"2.3.0", "2.4", false},
+		{"2.3.0", ">2.3", false},
+		{"2.3.1", ">2.3", true},
+		{"2.3.0", "<=2.3.0", true},
+		{"2.3.0", "<2.3.0", false},
+		{"2.3.0", "=2.3", true},
+		{"2.3.0", "==2.3.0", true},
+		{"2.3.0", "!=2.3.0", false},
+		{"2.3.0", ">=2.0, <3", true},
+		{"3.0.0", ">=2.0, <3", false},
+		{"v2.10.0", ">=2.9", true},
+		{"2.4.0-rc1", ">=2.4", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version+" "+c.constraint, func(t *testing.T) {
+			ok, err := satisfiesVersion(c.version, c.constraint)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, ok)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, constraint := range []string{"", ">=", ">=2.x", "1.2.3.4"} {
+			_, err := satisfiesVersion("2.3.0", constraint)
+			require.Error(t, err, constraint)
+		}
+	})
+}
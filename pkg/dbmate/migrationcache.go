@@ -0,0 +1,123 @@
+package dbmate
+
+import (
+	"sync"
+)
+
+// parseWorkers is the number of migration files read and parsed at once by
+// parseMigrations, which hides the latency of remote migration sources
+const parseWorkers = 8
+
+// migrationCache holds the migration files listed by a single command, along with their
+// contents and parse results, so that each file is listed, read and parsed once, even
+// when the command checks it several times or migrates many tenant databases. Files are
+// not cached between commands, since they may change in between.
+type migrationCache struct {
+	mu    sync.Mutex
+	files []Migration
+	// listed is set once files has been populated
+	listed  bool
+	entries map[string]*cachedMigration
+}
+
+// cachedMigration is the contents and parse result of a single migration file
+type cachedMigration struct {
+	readOnce  sync.Once
+	contents  string
+	omitted   omittedBody
+	readErr   error
+	parseOnce sync.Once
+	parsed    *ParsedMigration
+	parseErr  error
+}
+
+// withMigrationCache returns a copy of db which caches migration files until the command
+// finishes, or db itself if it already caches them
+func (db *DB) withMigrationCache() *DB {
+	if db.migrations != nil {
+		return db
+	}
+
+	c := *db
+	c.migrations = &migrationCache{entries: map[string]*cachedMigration{}}
+
+	return &c
+}
+
+// list returns a copy of the cached migration files, calling find to list them the first
+// time it is called
+func (c *migrationCache) list(find func() ([]Migration, error)) ([]Migration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.listed {
+		files, err := find()
+		if err != nil {
+			return nil, err
+		}
+		for i := range files {
+			files[i].cache = c
+		}
+		c.files = files
+		c.listed = true
+	}
+
+	// callers set Applied on their copy, which differs between tenant databases
+	return append([]Migration{}, c.files...), nil
+}
+
+// entry returns the cache entry for the migration file at path
+func (c *migrationCache) entry(path string) *cachedMigration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[path]
+	if !ok {
+		e = &cachedMigration{}
+		c.entries[path] = e
+	}
+
+	return e
+}
+
+// read returns the contents of the file, calling read the first time it is called
+func (e *cachedMigration) read(read func() (string, omittedBody, error)) (string, omittedBody, error) {
+	e.readOnce.Do(func() {
+		e.contents, e.omitted, e.readErr = read()
+	})
+
+	return e.contents, e.omitted, e.readErr
+}
+
+// parse returns the parsed file, calling parse the first time it is called. The result is
+// shared, and must not be modified.
+func (e *cachedMigration) parse(parse func() (*ParsedMigration, error)) (*ParsedMigration, error) {
+	e.parseOnce.Do(func() {
+		e.parsed, e.parseErr = parse()
+	})
+
+	return e.parsed, e.parseErr
+}
+
+// parseMigrations parses each migration, setting its Description and Metadata, using
+// several goroutines. Parse errors are ignored, since they are reported when the
+// migration is applied.
+func parseMigrations(migrations []Migration) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parseWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				_, _ = migrations[i].Parse()
+			}
+		}()
+	}
+
+	for i := range migrations {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,173 @@
+package dbmate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier records the migrations applied or rolled back during a run, and posts a
+// summary to a webhook or Slack when the run completes
+type Notifier struct {
+	mu         sync.Mutex
+	start      time.Time
+	migrations []NotifiedMigration
+	failed     *NotifiedMigration
+}
+
+// Notification is the summary of a run which is posted to webhooks as JSON
+type Notification struct {
+	Command    string              `json:"command"`
+	Database   string              `json:"database"`
+	Success    bool                `json:"success"`
+	Error      string              `json:"error,omitempty"`
+	Duration   float64             `json:"duration_seconds"`
+	Migrations []NotifiedMigration `json:"migrations"`
+	Failed     *NotifiedMigration  `json:"failed,omitempty"`
+}
+
+// NotifiedMigration describes a migration in a Notification
+type NotifiedMigration struct {
+	Version   string  `json:"version"`
+	FileName  string  `json:"file_name"`
+	Direction string  `json:"direction"`
+	Duration  float64 `json:"duration_seconds"`
+}
+
+// NewNotifier returns a Notifier for a run starting now
+func NewNotifier() *Notifier {
+	return &Notifier{start: time.Now()}
+}
+
+// Hooks returns hooks which record each migration applied, rolled back or failed
+func (n *Notifier) Hooks() Hooks {
+	record := func(event MigrationEvent) NotifiedMigration {
+		return NotifiedMigration{
+			Version:   event.Version,
+			FileName:  event.FileName,
+			Direction: event.Direction,
+			Duration:  event.Duration.Seconds(),
+		}
+	}
+
+	return Hooks{
+		AfterMigration: func(event MigrationEvent) {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			n.migrations = append(n.migrations, record(event))
+		},
+		OnError: func(event MigrationEvent, _ error) {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			failed := record(event)
+			n.failed = &failed
+		},
+	}
+}
+
+// Notification returns the summary of the run so far. Credentials are removed from the
+// database URL and error message.
+func (n *Notifier) Notification(command string, databaseURL *url.URL, err error) Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notification := Notification{
+		Command:    command,
+		Success:    err == nil,
+		Duration:   time.Since(n.start).Seconds(),
+		Migrations: append([]NotifiedMigration{}, n.migrations...),
+		Failed:     n.failed,
+	}
+	if databaseURL != nil {
+		notification.Database = databaseURL.Redacted()
+	}
+	if err != nil {
+		notification.Error = RedactString(err.Error())
+	}
+
+	return notification
+}
+
+// Empty returns whether no migrations were applied, rolled back or failed
+func (n *Notifier) Empty() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.migrations) == 0 && n.failed == nil
+}
+
+// SendWebhook posts notification to webhookURL as JSON
+func SendWebhook(webhookURL string, notification Notification) error {
+	return postJSON(webhookURL, notification)
+}
+
+// SendSlack posts notification to a Slack incoming webhook as a message
+func SendSlack(webhookURL string, notification Notification) error {
+	return postJSON(webhookURL, map[string]string{"text": notification.Text()})
+}
+
+// Text formats the notification as a human readable message
+func (n Notification) Text() string {
+	var b strings.Builder
+	if n.Success {
+		fmt.Fprintf(&b, "dbmate %s succeeded on %s", n.Command, n.Database)
+	} else {
+		fmt.Fprintf(&b, "dbmate %s failed on %s", n.Command, n.Database)
+	}
+	fmt.Fprintf(&b, " (%s)\n", (time.Duration(n.Duration * float64(time.Second))).Round(time.Millisecond))
+
+	for _, m := range n.Migrations {
+		verb := "Applied"
+		if m.Direction == DirectionDown {
+			verb = "Rolled back"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", verb, m.FileName)
+	}
+	if n.Failed != nil {
+		fmt.Fprintf(&b, "Failed: %s\n", n.Failed.FileName)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", n.Error)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// postJSON posts v as JSON to u, returning an error for non-2xx responses. Webhook URLs
+// often contain a secret in their path, so errors only include the host.
+func postJSON(u string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	target := "webhook"
+	if parsed, err := url.Parse(u); err == nil {
+		target = parsed.Scheme + "://" + parsed.Host
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("unable to post to %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unable to post to %s: %s", target, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package dbmate
+
+import (
+	"io"
+	"io/fs"
+	"net/url"
+	"time"
+)
+
+// Option configures a DB created by NewWithOptions
+type Option func(*DB)
+
+// NewWithOptions initializes a new dbmate database with the defaults used by New,
+// overridden by opts
+func NewWithOptions(databaseURL *url.URL, opts ...Option) *DB {
+	db := New(databaseURL)
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	return db
+}
+
+// WithAutoDumpSchema sets whether schema.sql is generated after each action
+func WithAutoDumpSchema(enabled bool) Option {
+	return func(db *DB) {
+		db.AutoDumpSchema = enabled
+	}
+}
+
+// WithCheckDownBlocks sets whether down blocks are syntax checked while applying migrations
+func WithCheckDownBlocks(enabled bool) Option {
+	return func(db *DB) {
+		db.CheckDownBlocks = enabled
+	}
+}
+
+// WithCredentials sets the provider of credentials used each time dbmate connects
+func WithCredentials(provider CredentialProvider) Option {
+	return func(db *DB) {
+		db.Credentials = provider
+	}
+}
+
+// WithFixturesDir sets the directory containing fixture sets
+func WithFixturesDir(dir string) Option {
+	return func(db *DB) {
+		db.FixturesDir = dir
+	}
+}
+
+// WithFS sets the filesystem used to read migrations
+func WithFS(fsys fs.FS) Option {
+	return func(db *DB) {
+		db.FS = fsys
+	}
+}
+
+// WithGitCheck enables checking for migration version conflicts between git branches,
+// reporting merged migrations only after base (if set)
+func WithGitCheck(base string) Option {
+	return func(db *DB) {
+		db.GitCheck = true
+		db.GitBase = base
+	}
+}
+
+// WithLockMigrations sets whether Migrate and Rollback hold the migration lock, failing
+// if another process holds it
+func WithLockMigrations(enabled bool) Option {
+	return func(db *DB) {
+		db.LockMigrations = enabled
+	}
+}
+
+// WithLogger sets the writer which output is written to
+func WithLogger(w io.Writer) Option {
+	return func(db *DB) {
+		db.Log = w
+	}
+}
+
+// WithLogLevel sets the minimum level of messages which are logged
+func WithLogLevel(level LogLevel) Option {
+	return func(db *DB) {
+		db.LogLevel = level
+	}
+}
+
+// WithMaxMigrationDuration sets the longest a migration may run for before it is cancelled
+func WithMaxMigrationDuration(d time.Duration) Option {
+	return func(db *DB) {
+		db.MaxMigrationDuration = d
+	}
+}
+
+// WithMigrationsDir sets the directory or directories to find migration files
+func WithMigrationsDir(dirs ...string) Option {
+	return func(db *DB) {
+		db.MigrationsDir = dirs
+	}
+}
+
+// WithMigrationFilter excludes pending migrations for which filter returns false
+func WithMigrationFilter(filter func(fileName string, meta Metadata) bool) Option {
+	return func(db *DB) {
+		db.MigrationFilter = filter
+	}
+}
+
+// WithMigrationsTableName sets the database table to record migrations in
+func WithMigrationsTableName(name string) Option {
+	return func(db *DB) {
+		db.MigrationsTableName = name
+	}
+}
+
+// WithOrphanPolicy sets how applied migrations without a migration file are handled
+// (one of the Orphans constants)
+func WithOrphanPolicy(policy string) Option {
+	return func(db *DB) {
+		db.OrphanPolicy = policy
+	}
+}
+
+// WithPreflight sets whether every target database is checked with CheckPreflight before
+// any migrations are applied
+func WithPreflight(enabled bool) Option {
+	return func(db *DB) {
+		db.Preflight = enabled
+	}
+}
+
+// WithReuseConnections sets whether the connection pool is kept open between operations
+func WithReuseConnections(enabled bool) Option {
+	return func(db *DB) {
+		db.ReuseConnections = enabled
+	}
+}
+
+// WithSchemaFile sets the location of the schema.sql file
+func WithSchemaFile(path string) Option {
+	return func(db *DB) {
+		db.SchemaFile = path
+	}
+}
+
+// WithSchemaFormat sets the level of detail in schema dumps, using one of the SchemaFormat
+// constants
+func WithSchemaFormat(format string) Option {
+	return func(db *DB) {
+		db.SchemaFormat = format
+	}
+}
+
+// WithImplicitCommitPolicy sets how migration blocks which commit their transaction
+// implicitly are handled
+func WithImplicitCommitPolicy(policy string) Option {
+	return func(db *DB) {
+		db.ImplicitCommitPolicy = policy
+	}
+}
+
+// WithIncludeSchemas restricts schema dumps to the given Postgres schemas or MySQL databases
+func WithIncludeSchemas(schemas ...string) Option {
+	return func(db *DB) {
+		db.IncludeSchemas = schemas
+	}
+}
+
+// WithSchemaMigrationsFile writes the migrations table data to a separate file from the
+// schema when it is dumped
+func WithSchemaMigrationsFile(path string) Option {
+	return func(db *DB) {
+		db.SchemaMigrationsFile = path
+	}
+}
+
+// WithSchemaSnapshotDir sets the directory where a copy of the schema is written after
+// each migration is applied
+func WithSchemaSnapshotDir(dir string) Option {
+	return func(db *DB) {
+		db.SchemaSnapshotDir = dir
+	}
+}
+
+// WithSource sets the MigrationSource which migration files are read from
+func WithSource(source MigrationSource) Option {
+	return func(db *DB) {
+		db.Source = source
+	}
+}
+
+// WithStrict sets whether modified applied migrations prevent migrating and rolling back
+func WithStrict(enabled bool) Option {
+	return func(db *DB) {
+		db.Strict = enabled
+	}
+}
+
+// WithTags restricts applied migrations to those tagged with any of tags
+func WithTags(tags ...string) Option {
+	return func(db *DB) {
+		db.Tags = tags
+	}
+}
+
+// WithShard sets the label of the shard which the database belongs to
+func WithShard(shard string) Option {
+	return func(db *DB) {
+		db.Shard = shard
+	}
+}
+
+// WithSignatureVerification verifies the signature of each migration with tool (one of the
+// Signature constants), using the public key or keyring in key
+func WithSignatureVerification(tool, key string) Option {
+	return func(db *DB) {
+		db.VerifySignatures = tool
+		db.SignatureKey = key
+	}
+}
+
+// WithSkipTags excludes migrations tagged with any of tags from being applied
+func WithSkipTags(tags ...string) Option {
+	return func(db *DB) {
+		db.SkipTags = tags
+	}
+}
+
+// WithTenantCanary sets the tenant which is migrated and verified before any other
+// tenant, and the query and shell command which verify it (either may be empty)
+func WithTenantCanary(tenant, query, command string) Option {
+	return func(db *DB) {
+		db.TenantCanary = tenant
+		db.TenantCanaryQuery = query
+		db.TenantCanaryCommand = command
+	}
+}
+
+// WithTenantKeepGoing sets whether the remaining tenants are migrated after a tenant fails
+func WithTenantKeepGoing(enabled bool) Option {
+	return func(db *DB) {
+		db.TenantKeepGoing = enabled
+	}
+}
+
+// WithTenantPattern sets the glob pattern matching the names of tenant databases
+func WithTenantPattern(pattern string) Option {
+	return func(db *DB) {
+		db.TenantPattern = pattern
+	}
+}
+
+// WithTenantProgressFile sets the file which records the outcome of migrating each tenant
+func WithTenantProgressFile(path string) Option {
+	return func(db *DB) {
+		db.TenantProgressFile = path
+	}
+}
+
+// WithTenantRegisterQuery sets the query which registers a new tenant
+func WithTenantRegisterQuery(query string) Option {
+	return func(db *DB) {
+		db.TenantRegisterQuery = query
+	}
+}
+
+// WithTenantTemplate sets the database which is copied to create new tenants
+func WithTenantTemplate(template string) Option {
+	return func(db *DB) {
+		db.TenantTemplate = template
+	}
+}
+
+// WithTenantWorkers sets the number of tenants migrated at once, and the minimum time
+// between starting each tenant
+func WithTenantWorkers(workers int, interval time.Duration) Option {
+	return func(db *DB) {
+		db.TenantWorkers = workers
+		db.TenantInterval = interval
+	}
+}
+
+// WithTenantsFile sets the file listing the names of tenant databases
+func WithTenantsFile(path string) Option {
+	return func(db *DB) {
+		db.TenantsFile = path
+	}
+}
+
+// WithTenantsQuery sets the query which returns the names of tenant databases
+func WithTenantsQuery(query string) Option {
+	return func(db *DB) {
+		db.TenantsQuery = query
+	}
+}
+
+// WithTimeouts sets the lock and statement timeouts for migrations
+func WithTimeouts(lock, statement time.Duration) Option {
+	return func(db *DB) {
+		db.LockTimeout = lock
+		db.StatementTimeout = statement
+	}
+}
+
+// WithTransactionRetries sets how many times a migration transaction which fails with a
+// retryable error is retried, and the delay before the first retry
+func WithTransactionRetries(retries int, interval time.Duration) Option {
+	return func(db *DB) {
+		db.TransactionRetries = retries
+		db.TransactionRetryInterval = interval
+	}
+}
+
+// WithVerbose sets whether each executed statement is printed with its result
+func WithVerbose(enabled bool) Option {
+	return func(db *DB) {
+		db.Verbose = enabled
+	}
+}
+
+// WithWait waits for the database to become available before running any actions,
+// attempting to connect every interval until timeout
+func WithWait(interval, timeout time.Duration) Option {
+	return func(db *DB) {
+		db.WaitBefore = true
+		db.WaitInterval = interval
+		db.WaitTimeout = timeout
+	}
+}
@@ -0,0 +1,54 @@
+package dbmate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	u := dbutil.MustParseURL("sqlite:foo.sqlite3")
+
+	t.Run("defaults", func(t *testing.T) {
+		require.Equal(t, New(u), NewWithOptions(u))
+	})
+
+	t.Run("options", func(t *testing.T) {
+		var output strings.Builder
+		mapFS := fstest.MapFS{}
+		db := NewWithOptions(u,
+			WithAutoDumpSchema(false),
+			WithFS(mapFS),
+			WithLogger(&output),
+			WithLogLevel(LogLevelWarn),
+			WithMigrationsDir("./a", "./b"),
+			WithMigrationsTableName("migrations"),
+			WithSchemaFile("./schema.sql"),
+			WithSource(NewFSSource(mapFS)),
+			WithTags("data"),
+			WithSkipTags("slow"),
+			WithVerbose(true),
+			WithWait(time.Millisecond, time.Second),
+		)
+
+		require.Equal(t, u, db.DatabaseURL)
+		require.False(t, db.AutoDumpSchema)
+		require.Equal(t, mapFS, db.FS)
+		require.Equal(t, &output, db.Log)
+		require.Equal(t, LogLevelWarn, db.LogLevel)
+		require.Equal(t, []string{"./a", "./b"}, db.MigrationsDir)
+		require.Equal(t, "migrations", db.MigrationsTableName)
+		require.Equal(t, "./schema.sql", db.SchemaFile)
+		require.Equal(t, NewFSSource(mapFS), db.Source)
+		require.Equal(t, []string{"data"}, db.Tags)
+		require.Equal(t, []string{"slow"}, db.SkipTags)
+		require.True(t, db.Verbose)
+		require.True(t, db.WaitBefore)
+		require.Equal(t, time.Millisecond, db.WaitInterval)
+		require.Equal(t, time.Second, db.WaitTimeout)
+	})
+}
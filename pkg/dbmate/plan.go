@@ -0,0 +1,256 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrPlanOutdated is returned by Apply when the plan no longer matches the pending migrations
+var ErrPlanOutdated = errors.New("plan does not match the pending migrations")
+
+// PlannedMigration describes a pending migration, and the SQL which Migrate would execute
+// to apply it
+type PlannedMigration struct {
+	Version  string
+	FileName string
+	// Statements are executed in order to apply the migration, including the statement
+	// which records it in the migrations table (if the table exists)
+	Statements []string
+	// Transaction is whether the statements are executed inside a transaction
+	Transaction bool
+}
+
+// Plan writes the SQL which Migrate would execute for all pending migrations to w,
+// including the statements which record each migration in the migrations table.
+// The database is read to determine pending migrations, but is not modified.
+func (db *DB) Plan(w io.Writer) error {
+	plan, tableExists, err := db.planMigrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "-- dbmate plan: %d pending migration(s)\n", len(plan))
+	if db.Tracker != nil {
+		fmt.Fprintln(w, "-- applied migrations are recorded by a custom tracker, and are not included in this plan")
+	} else if !tableExists {
+		fmt.Fprintf(w, "-- the %s table does not exist, run dbmate migrate (or create it) before applying this plan\n",
+			db.MigrationsTableName)
+	}
+
+	for _, migration := range plan {
+		fmt.Fprintf(w, "\n-- migration: %s\n", migration.FileName)
+		if migration.Transaction {
+			fmt.Fprintln(w, "BEGIN;")
+		}
+		for _, statement := range migration.Statements {
+			fmt.Fprintln(w, statement)
+		}
+		if migration.Transaction {
+			fmt.Fprintln(w, "COMMIT;")
+		}
+	}
+
+	return nil
+}
+
+// PlanMigrations returns the SQL which Migrate would execute for each pending migration,
+// so that it can be reviewed before calling Apply. The database is not modified.
+func (db *DB) PlanMigrations() ([]PlannedMigration, error) {
+	plan, _, err := db.planMigrations()
+	return plan, err
+}
+
+// Apply applies the migrations in plan, which was returned by PlanMigrations. It returns
+// ErrPlanOutdated without applying any migrations if the pending migrations (or the SQL
+// they would execute) have changed since the plan was made.
+func (db *DB) Apply(plan []PlannedMigration) error {
+	return db.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext is like Apply, but stops applying migrations when ctx is done
+func (db *DB) ApplyContext(ctx context.Context, plan []PlannedMigration) error {
+	current, _, err := db.planMigrations()
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(plan, current) {
+		return ErrPlanOutdated
+	}
+
+	return db.MigrateContext(ctx)
+}
+
+// planMigrations returns the planned pending migrations, and whether the migrations table exists
+func (db *DB) planMigrations() ([]PlannedMigration, bool, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, false, err
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(migrations) == 0 {
+		return nil, false, ErrNoMigrationFiles
+	}
+
+	pending, err := db.pendingMigrations(migrations)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+
+	tableExists, err := db.tracker(drv).MigrationsTableExists(sqlDB)
+	if err != nil {
+		return nil, false, err
+	}
+
+	plan := []PlannedMigration{}
+	for _, migration := range pending {
+		statements, err := db.planMigration(drv, sqlDB, migration, tableExists)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", migration.FileName, err)
+		}
+
+		plan = append(plan, PlannedMigration{
+			Version:     migration.Version,
+			FileName:    migration.FileName,
+			Statements:  statements,
+			Transaction: migration.parsed.UpOptions.Transaction(),
+		})
+	}
+
+	return plan, tableExists, nil
+}
+
+// planMigration returns the statements which would be executed to apply migration
+func (db *DB) planMigration(drv Driver, sqlDB *sql.DB, migration pendingMigration, tableExists bool) ([]string, error) {
+	tx := &recordingTransaction{Transaction: sqlDB}
+
+	restoreSchema, err := switchSchema(drv, tx, migration.parsed.UpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if migration.goMigration != nil {
+		tx.statements = append(tx.statements, "-- go migration, statements are not known until it is applied")
+	} else if !db.matchesShard(migration.parsed.UpOptions) {
+		tx.statements = append(tx.statements, "-- skipped, "+skippedShardMessage(migration.FileName, migration.parsed.UpOptions))
+	} else if migration.parsed.UpOptions.Stream() {
+		tx.statements = append(tx.statements, "-- streamed from "+migration.FilePath+", statements are not shown")
+	} else {
+		block, err := db.expandTenant(migration.parsed.Up)
+		if err != nil {
+			return nil, err
+		}
+		tx.record(block)
+	}
+
+	if err := restoreSchema(); err != nil {
+		return nil, err
+	}
+
+	if tableExists && db.Tracker == nil {
+		if err := drv.InsertMigration(tx, migration.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	return tx.statements, nil
+}
+
+// recordingTransaction executes queries against the wrapped transaction, but records
+// statements passed to Exec instead of executing them
+type recordingTransaction struct {
+	dbutil.Transaction
+	statements []string
+}
+
+func (r *recordingTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	statement, err := interpolateArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+	r.record(statement)
+
+	return recordedResult{}, nil
+}
+
+// record adds statement to the plan, terminated with a semicolon
+func (r *recordingTransaction) record(statement string) {
+	statement = strings.TrimSpace(statement)
+	if statement == "" {
+		return
+	}
+	if !strings.HasSuffix(statement, ";") {
+		statement += ";"
+	}
+
+	r.statements = append(r.statements, statement)
+}
+
+// recordedResult is returned for statements which were recorded rather than executed
+type recordedResult struct{}
+
+func (recordedResult) LastInsertId() (int64, error) {
+	return 0, errors.New("statement was not executed")
+}
+
+func (recordedResult) RowsAffected() (int64, error) {
+	return 0, errors.New("statement was not executed")
+}
+
+var placeholderRegexp = regexp.MustCompile(`\$\d+|\?`)
+
+// interpolateArgs replaces $1 or ? placeholders in query with args as SQL literals
+func interpolateArgs(query string, args []interface{}) (string, error) {
+	next := 0
+	var err error
+	out := placeholderRegexp.ReplaceAllStringFunc(query, func(placeholder string) string {
+		i := next
+		if placeholder != "?" {
+			i, _ = strconv.Atoi(placeholder[1:])
+			i--
+		} else {
+			next++
+		}
+		if i < 0 || i >= len(args) {
+			err = fmt.Errorf("missing argument for placeholder %s", placeholder)
+			return placeholder
+		}
+
+		return sqlLiteral(args[i])
+	})
+
+	return out, err
+}
+
+// sqlLiteral formats v as a SQL literal
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
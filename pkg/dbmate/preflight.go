@@ -0,0 +1,60 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrPreflightFailed is returned when a database fails the checks made by CheckPreflight
+var ErrPreflightFailed = errors.New("preflight check failed")
+
+// CheckPreflight verifies that the configured credentials can connect to the database,
+// create the migrations table (if the tracker is a CreatePermissionChecker), and acquire
+// the migration lock (if the driver supports locking), without applying any migrations or
+// otherwise changing the database.
+func (db *DB) CheckPreflight(ctx context.Context) error {
+	drv, err := db.driver(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPreflightFailed, err)
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPreflightFailed, err)
+	}
+	defer release()
+
+	tracker := db.tracker(drv)
+	exists, err := tracker.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return fmt.Errorf("%w: unable to read the migrations table: %w", ErrPreflightFailed, err)
+	}
+	if checker, ok := tracker.(CreatePermissionChecker); ok && !exists {
+		if err := checker.CheckCreateMigrationsTable(sqlDB); err != nil {
+			return fmt.Errorf("%w: unable to create the migrations table: %w", ErrPreflightFailed, err)
+		}
+	}
+
+	unlock, err := db.lock(ctx, drv, false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPreflightFailed, err)
+	}
+	if err := unlock(); err != nil {
+		return fmt.Errorf("%w: %w", ErrPreflightFailed, err)
+	}
+
+	return nil
+}
+
+// preflightTenants runs CheckPreflight against every tenant, and returns the errors of
+// all tenants which failed, so that they can be fixed before any tenant is migrated
+func (db *DB) preflightTenants(ctx context.Context, tenants []string) error {
+	p := *db
+	p.TenantKeepGoing = true
+	p.TenantInterval = 0
+
+	return p.forEachTenant(ctx, tenants, func(ctx context.Context, _ int, t *DB) error {
+		return t.CheckPreflight(ctx)
+	})
+}
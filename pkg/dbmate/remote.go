@@ -0,0 +1,275 @@
+package dbmate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RemoteManifestName is the name of the file listing the migrations available at a remote
+// source. It uses the same format as the output of `sha256sum`, e.g.
+//
+//	e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  20151129054053_test_migration.sql
+const RemoteManifestName = "SHA256SUMS"
+
+// Error codes
+var (
+	ErrUnsupportedSource = errors.New("unsupported migrations source")
+	ErrRemoteManifest    = errors.New("invalid migrations manifest")
+	ErrChecksumMismatch  = errors.New("checksum mismatch")
+)
+
+// RemoteFS is a read-only filesystem serving migration files published behind an HTTP
+// manifest. Files are listed using the manifest, downloaded on demand with anonymous GET
+// requests, verified against their checksum, and cached locally. Only http:// and https://
+// URLs are supported; requests are not signed, so objects in a cloud storage bucket must be
+// readable anonymously or through a signed or proxied HTTPS URL.
+type RemoteFS struct {
+	baseURL  *url.URL
+	cacheDir string
+	client   *http.Client
+	files    map[string]string
+}
+
+// NewRemoteFS fetches the manifest from a remote migrations source. If cacheDir is empty,
+// downloaded files are cached in the user cache directory.
+func NewRemoteFS(u *url.URL, cacheDir string) (*RemoteFS, error) {
+	baseURL, err := remoteHTTPURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(userCacheDir, "dbmate", "migrations")
+	}
+
+	rfs := &RemoteFS{
+		baseURL:  baseURL,
+		cacheDir: cacheDir,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}
+
+	manifest, err := rfs.download(RemoteManifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	rfs.files, err = parseRemoteManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return rfs, nil
+}
+
+// remoteHTTPURL validates a migrations source URL, and returns the URL of its directory
+func remoteHTTPURL(u *url.URL) (*url.URL, error) {
+	if u == nil {
+		return nil, ErrUnsupportedSource
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s (only http:// and https:// are supported)", ErrUnsupportedSource, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrUnsupportedSource)
+	}
+
+	// always treat the path as a directory
+	out := *u
+	if !strings.HasSuffix(out.Path, "/") {
+		out.Path += "/"
+	}
+
+	return &out, nil
+}
+
+// parseRemoteManifest parses a sha256sum formatted manifest into a map of file name to checksum
+func parseRemoteManifest(data []byte) (map[string]string, error) {
+	files := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrRemoteManifest, line)
+		}
+
+		// sha256sum prefixes file names with '*' in binary mode
+		sum, name := strings.ToLower(fields[0]), strings.TrimPrefix(fields[1], "*")
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%w: invalid checksum for %s", ErrRemoteManifest, name)
+		}
+		if !fs.ValidPath(name) || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%w: invalid file name %q", ErrRemoteManifest, name)
+		}
+
+		files[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (rfs *RemoteFS) download(name string) ([]byte, error) {
+	u := *rfs.baseURL
+	u.Path = path.Join(u.Path, name)
+
+	resp, err := rfs.client.Get(u.String())
+	if err != nil {
+		// the error describes the request using the full URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("unable to download %s: %w", redactedURL(&u), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", redactedURL(&u), resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// redactedURL returns u without its userinfo or query string, either of which may hold
+// credentials such as a token or presigned signature
+func redactedURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	return redacted.String()
+}
+
+// Open opens the named migration file
+func (rfs *RemoteFS) Open(name string) (fs.File, error) {
+	if _, err := rfs.ReadFile(name); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(rfs.cachePath(rfs.files[name]))
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteFile{File: f, name: name}, nil
+}
+
+// ReadDir lists the migration files contained in the manifest. Remote sources are flat,
+// so the only supported directory is ".".
+func (rfs *RemoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(rfs.files))
+	for name := range rfs.files {
+		entries = append(entries, remoteDirEntry(name))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// ReadFile returns the verified contents of the named migration file, downloading it
+// if it is not already cached
+func (rfs *RemoteFS) ReadFile(name string) ([]byte, error) {
+	sum, ok := rfs.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// cached files are stored by checksum, so they never need to be invalidated
+	cachePath := rfs.cachePath(sum)
+	if data, err := os.ReadFile(cachePath); err == nil && checksum(data) == sum {
+		return data, nil
+	}
+
+	data, err := rfs.download(name)
+	if err != nil {
+		return nil, err
+	}
+	if checksum(data) != sum {
+		return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, name)
+	}
+
+	if err := ensureDir(rfs.cacheDir); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(cachePath, data, 0o644); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (rfs *RemoteFS) cachePath(sum string) string {
+	return filepath.Join(rfs.cacheDir, sum)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// remoteFile is a migration file opened from the cache, which is named after the
+// migration rather than its cache file
+type remoteFile struct {
+	*os.File
+	name string
+}
+
+func (f remoteFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteFileInfo{FileInfo: info, name: f.name}, nil
+}
+
+type remoteFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i remoteFileInfo) Name() string { return i.name }
+
+// remoteDirEntry describes a file listed in a remote manifest
+type remoteDirEntry string
+
+func (e remoteDirEntry) Name() string               { return string(e) }
+func (e remoteDirEntry) IsDir() bool                { return false }
+func (e remoteDirEntry) Type() fs.FileMode          { return 0 }
+func (e remoteDirEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e remoteDirEntry) Size() int64                { return -1 }
+func (e remoteDirEntry) Mode() fs.FileMode          { return 0o444 }
+func (e remoteDirEntry) ModTime() time.Time         { return time.Time{} }
+func (e remoteDirEntry) Sys() any                   { return nil }
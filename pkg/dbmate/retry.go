@@ -0,0 +1,36 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// retryTransaction runs txFunc in a transaction, and runs it again in a new transaction
+// if the driver reports that the transaction failed with an error which the client is
+// expected to retry (such as a CockroachDB "restart transaction" error). The delay
+// between attempts starts at TransactionRetryInterval, and doubles after each attempt.
+func (db *DB) retryTransaction(ctx context.Context, drv Driver, sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	retrier, ok := drv.(TransactionRetrier)
+	delay := db.TransactionRetryInterval
+
+	for attempt := 1; ; attempt++ {
+		err := doTransaction(ctx, sqlDB, txFunc)
+		if err == nil || !ok || attempt > db.TransactionRetries || !retrier.IsRetryable(err) {
+			return err
+		}
+
+		fmt.Fprintf(db.logger(LogLevelWarn), "Warning: retrying transaction in %s (retry %d of %d): %s\n",
+			delay, attempt, db.TransactionRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,206 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrSafetyUnsupported = errors.New("safety checks are only supported for postgres")
+	ErrUnsafeMigration   = errors.New("unsafe migrations found")
+)
+
+// Safety rules, which may be named in a `-- safety:ignore` comment
+const (
+	SafetyIndexNotConcurrent = "index-not-concurrent"
+	SafetyVolatileDefault    = "volatile-default"
+	SafetyColumnTypeChange   = "column-type-change"
+)
+
+// SafetyProblem is a statement in a pending migration which may lock or rewrite a table
+// that is in use
+type SafetyProblem struct {
+	FilePath string
+	Line     int
+	Rule     string
+	Message  string
+}
+
+func (p SafetyProblem) String() string {
+	location := p.FilePath
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, p.Line)
+	}
+
+	return fmt.Sprintf("%s: %s (%s)", location, p.Message, p.Rule)
+}
+
+var (
+	safetyIgnoreRegexp   = regexp.MustCompile(`(?m)^\s*--\s*safety:ignore\b(.*)$`)
+	createTableRegexp    = regexp.MustCompile(`(?is)^create\s+(?:(?:global\s+|local\s+)?(?:temporary|temp|unlogged)\s+)?table\s+(?:if\s+not\s+exists\s+)?([\w."]+)`)
+	createIndexRegexp    = regexp.MustCompile(`(?is)^create\s+(?:unique\s+)?index\s+(concurrently\s+)?.*?\bon\s+(?:only\s+)?([\w."]+)`)
+	alterTableRegexp     = regexp.MustCompile(`(?is)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."]+)`)
+	addColumnRegexp      = regexp.MustCompile(`(?is)\badd\s+(?:column\s+)?(?:if\s+not\s+exists\s+)?[\w"]+\s+([^,]*)`)
+	volatileDefaultRegex = regexp.MustCompile(`(?is)\bdefault\s+.*?\b(random|clock_timestamp|timeofday|gen_random_uuid|uuid_generate_v[14]|nextval)\s*\(`)
+	serialTypeRegexp     = regexp.MustCompile(`(?i)^(small|big)?serial\b`)
+	columnTypeRegexp     = regexp.MustCompile(`(?is)\balter\s+(?:column\s+)?([\w"]+)\s+(?:set\s+data\s+)?type\b`)
+)
+
+// CheckSafety looks for statements in pending migrations which take long-held locks on,
+// or rewrite, existing postgres tables: indexes created without CONCURRENTLY, columns
+// added with a volatile default (which must be computed for every row), and column type
+// changes. Tables created by the pending migrations themselves are not checked.
+//
+// A migration file may suppress rules with a comment such as
+// `-- safety:ignore index-not-concurrent`, or all rules with `-- safety:ignore`.
+func (db *DB) CheckSafety() ([]SafetyProblem, error) {
+	if db.DatabaseURL == nil || (db.DatabaseURL.Scheme != "postgres" && db.DatabaseURL.Scheme != "postgresql") {
+		return nil, ErrSafetyUnsupported
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []SafetyProblem{}
+	created := map[string]bool{}
+	for _, migration := range migrations {
+		if migration.Applied || migration.goMigration != nil {
+			continue
+		}
+
+		// the statements of a streamed up block are omitted, and not checked
+		contents, _, err := migration.readFile()
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := migration.Parse()
+		if err != nil {
+			return nil, err
+		}
+
+		ignored := safetyIgnored(contents)
+		if ignored[""] {
+			continue
+		}
+
+		statements, ok := dbutil.SplitStatements(parsed.Up)
+		if !ok {
+			statements = []string{parsed.Up}
+		}
+
+		offset := parsed.UpRange.Start
+		for _, statement := range statements {
+			line := 0
+			if i := strings.Index(contents[offset:], statement); i >= 0 {
+				offset += i
+				line = parsed.UpRange.Line + strings.Count(contents[parsed.UpRange.Start:offset], "\n")
+			}
+
+			for _, problem := range checkStatementSafety(statement, created) {
+				if ignored[problem.Rule] {
+					continue
+				}
+				problem.FilePath = migration.FilePath
+				problem.Line = line
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// Safety prints the problems found by CheckSafety, and returns ErrUnsafeMigration if
+// there are any
+func (db *DB) Safety() error {
+	problems, err := db.CheckSafety()
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(db.logger(LogLevelError), db.colorize(ColorRed, problem.String()))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %d problem(s) found", ErrUnsafeMigration, len(problems))
+	}
+
+	return nil
+}
+
+// safetyIgnored returns the rules suppressed by `-- safety:ignore` comments in a
+// migration file. An empty rule means that all rules are suppressed.
+func safetyIgnored(contents string) map[string]bool {
+	ignored := map[string]bool{}
+	for _, match := range safetyIgnoreRegexp.FindAllStringSubmatch(contents, -1) {
+		rules := strings.FieldsFunc(match[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		if len(rules) == 0 {
+			ignored[""] = true
+		}
+		for _, rule := range rules {
+			ignored[rule] = true
+		}
+	}
+
+	return ignored
+}
+
+// checkStatementSafety returns the rules broken by a statement. Tables created by earlier
+// statements are recorded in created, since changes to them cannot block anything.
+func checkStatementSafety(statement string, created map[string]bool) []SafetyProblem {
+	problems := []SafetyProblem{}
+
+	if match := createTableRegexp.FindStringSubmatch(statement); match != nil {
+		created[safetyTableName(match[1])] = true
+		return problems
+	}
+
+	if match := createIndexRegexp.FindStringSubmatch(statement); match != nil {
+		if match[1] == "" && !created[safetyTableName(match[2])] {
+			problems = append(problems, SafetyProblem{Rule: SafetyIndexNotConcurrent, Message: fmt.Sprintf(
+				"creating an index on %s blocks writes to it until the index is built, "+
+					"use CREATE INDEX CONCURRENTLY in a migration with transaction:false", match[2])})
+		}
+		return problems
+	}
+
+	match := alterTableRegexp.FindStringSubmatch(statement)
+	if match == nil || created[safetyTableName(match[1])] {
+		return problems
+	}
+	table := match[1]
+
+	for _, column := range addColumnRegexp.FindAllStringSubmatch(statement, -1) {
+		if volatileDefaultRegex.MatchString(column[1]) || serialTypeRegexp.MatchString(column[1]) {
+			problems = append(problems, SafetyProblem{Rule: SafetyVolatileDefault, Message: fmt.Sprintf(
+				"adding a column with a volatile default rewrites %s while holding an exclusive lock, "+
+					"add the column without a default and backfill it in batches", table)})
+			break
+		}
+	}
+
+	if column := columnTypeRegexp.FindStringSubmatch(statement); column != nil {
+		problems = append(problems, SafetyProblem{Rule: SafetyColumnTypeChange, Message: fmt.Sprintf(
+			"changing the type of %s.%s may rewrite the table while holding an exclusive lock, "+
+				"add a new column and backfill it instead", table, column[1])})
+	}
+
+	return problems
+}
+
+// safetyTableName normalizes a table name, so that references to the same table match
+func safetyTableName(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, `"`, ""))
+	return strings.TrimPrefix(name, "public.")
+}
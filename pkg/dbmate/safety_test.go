@@ -0,0 +1,58 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStatementSafety(t *testing.T) {
+	rules := func(statement string, created map[string]bool) []string {
+		result := []string{}
+		for _, problem := range checkStatementSafety(statement, created) {
+			result = append(result, problem.Rule)
+		}
+		return result
+	}
+
+	cases := []struct {
+		statement string
+		expected  []string
+	}{
+		{"create index users_email on users (email);", []string{SafetyIndexNotConcurrent}},
+		{"CREATE UNIQUE INDEX users_email ON public.users (email);", []string{SafetyIndexNotConcurrent}},
+		{"create index concurrently users_email on users (email);", []string{}},
+		{"alter table users add column token uuid default gen_random_uuid();", []string{SafetyVolatileDefault}},
+		{"alter table users add column created_at timestamptz not null default clock_timestamp();", []string{SafetyVolatileDefault}},
+		{"alter table users add column id bigserial;", []string{SafetyVolatileDefault}},
+		{"alter table users add column active boolean not null default true;", []string{}},
+		{"alter table users add column created_at timestamptz default now();", []string{}},
+		{"alter table users alter column name type text;", []string{SafetyColumnTypeChange}},
+		{"ALTER TABLE users ALTER name SET DATA TYPE varchar(100);", []string{SafetyColumnTypeChange}},
+		{"alter table users alter column name set default 'x';", []string{}},
+		{"insert into users (name) values ('create index x on users (name)');", []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.statement, func(t *testing.T) {
+			require.Equal(t, c.expected, rules(c.statement, map[string]bool{}))
+		})
+	}
+
+	t.Run("created tables", func(t *testing.T) {
+		created := map[string]bool{}
+		require.Equal(t, []string{}, rules(`create table "Posts" (id int);`, created))
+		require.Equal(t, []string{}, rules("create index posts_id on public.posts (id);", created))
+		require.Equal(t, []string{}, rules("alter table posts add column id2 serial;", created))
+		require.Equal(t, []string{SafetyIndexNotConcurrent}, rules("create index users_id on users (id);", created))
+	})
+}
+
+func TestSafetyIgnored(t *testing.T) {
+	require.Equal(t, map[string]bool{}, safetyIgnored("-- migrate:up\ncreate index a on b (c);\n"))
+	require.Equal(t, map[string]bool{
+		SafetyIndexNotConcurrent: true,
+		SafetyColumnTypeChange:   true,
+	}, safetyIgnored("-- safety:ignore index-not-concurrent, column-type-change\n-- migrate:up\n"))
+	require.Equal(t, map[string]bool{"": true}, safetyIgnored("-- migrate:up\n-- safety:ignore\n"))
+}
@@ -0,0 +1,95 @@
+package dbmate
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// Schema formats, which control the level of detail in schema dumps
+const (
+	// SchemaFormatDefault omits ownership and grants
+	SchemaFormatDefault = "default"
+	// SchemaFormatFull includes ownership and grants, where the database supports them
+	SchemaFormatFull = "full"
+	// SchemaFormatNoComments is like SchemaFormatDefault, but omits comments
+	SchemaFormatNoComments = "no-comments"
+	// SchemaFormatMinimal is like SchemaFormatNoComments, but also omits session SET statements
+	SchemaFormatMinimal = "minimal"
+)
+
+// setStatementRegexp matches session settings in pg_dump and mysqldump output
+var setStatementRegexp = regexp.MustCompile(`(?i)^(SET\s|SELECT pg_catalog\.set_config\(|/\*!\d+ SET )`)
+
+// schemaMigrationsMarker is the comment which drivers write before the migrations table
+// data in schema dumps
+var schemaMigrationsMarker = []byte("-- Dbmate schema migrations\n")
+
+// dumpSchema dumps the database schema using drv, in the format set by SchemaFormat. If
+// SchemaMigrationsFile is set, the migrations table data is returned separately from the
+// schema, otherwise it is included in the schema and the second return value is nil.
+func (db *DB) dumpSchema(drv Driver, sqlDB *sql.DB) ([]byte, []byte, error) {
+	switch db.SchemaFormat {
+	case "", SchemaFormatDefault, SchemaFormatFull, SchemaFormatNoComments, SchemaFormatMinimal:
+	default:
+		return nil, nil, fmt.Errorf("unsupported schema format: %s", db.SchemaFormat)
+	}
+
+	schema, err := drv.DumpSchema(sqlDB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if db.SchemaMigrationsFile == "" {
+		return formatSchema(schema, db.SchemaFormat), nil, nil
+	}
+
+	schema, migrations := splitSchemaMigrations(schema)
+	return formatSchema(schema, db.SchemaFormat), formatSchema(migrations, db.SchemaFormat), nil
+}
+
+// splitSchemaMigrations splits a schema dump into the schema, and the migrations table
+// data which follows it
+func splitSchemaMigrations(schema []byte) ([]byte, []byte) {
+	i := bytes.Index(schema, schemaMigrationsMarker)
+	if i < 0 {
+		return schema, nil
+	}
+
+	// drop the comment lines surrounding the marker
+	ddl := bytes.TrimRight(schema[:i], "\n")
+	ddl = bytes.TrimRight(bytes.TrimSuffix(ddl, []byte("--")), "\n")
+
+	return append(ddl, '\n'), schema[i:]
+}
+
+// formatSchema removes comments (and for the minimal format, SET statements) from schema,
+// along with any consecutive blank lines this leaves behind
+func formatSchema(schema []byte, format string) []byte {
+	if format != SchemaFormatNoComments && format != SchemaFormatMinimal {
+		return schema
+	}
+
+	lines := bytes.SplitAfter(schema, []byte("\n"))
+	out := make([]byte, 0, len(schema))
+	blank := true
+	for _, line := range lines {
+		text := string(line)
+		if isCommentLine(text) || (format == SchemaFormatMinimal && setStatementRegexp.MatchString(text)) {
+			continue
+		}
+		if isEmptyLine(text) {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+
+		out = append(out, line...)
+	}
+
+	return append(bytes.TrimRight(out, "\n"), '\n')
+}
@@ -0,0 +1,82 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSchema(t *testing.T) {
+	schema := []byte(`SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.users (
+    id integer
+);
+
+--
+-- Dbmate schema migrations
+--
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('001');
+`)
+
+	require.Equal(t, string(schema), string(formatSchema(schema, SchemaFormatDefault)))
+	require.Equal(t, string(schema), string(formatSchema(schema, SchemaFormatFull)))
+
+	require.Equal(t, `SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+CREATE TABLE public.users (
+    id integer
+);
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('001');
+`, string(formatSchema(schema, SchemaFormatNoComments)))
+
+	require.Equal(t, `CREATE TABLE public.users (
+    id integer
+);
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('001');
+`, string(formatSchema(schema, SchemaFormatMinimal)))
+}
+
+func TestSplitSchemaMigrations(t *testing.T) {
+	schema, migrations := splitSchemaMigrations([]byte(`CREATE TABLE public.users (
+    id integer
+);
+
+
+--
+-- Dbmate schema migrations
+--
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('001');
+`))
+	require.Equal(t, "CREATE TABLE public.users (\n    id integer\n);\n", string(schema))
+	require.Equal(t, `-- Dbmate schema migrations
+--
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('001');
+`, string(migrations))
+
+	// sqlite does not surround the marker with comment lines
+	schema, migrations = splitSchemaMigrations([]byte("CREATE TABLE users (id integer);\n" +
+		"-- Dbmate schema migrations\nINSERT INTO \"schema_migrations\" (version) VALUES\n  ('001');\n"))
+	require.Equal(t, "CREATE TABLE users (id integer);\n", string(schema))
+	require.Equal(t, "-- Dbmate schema migrations\nINSERT INTO \"schema_migrations\" (version) VALUES\n  ('001');\n", string(migrations))
+
+	schema, migrations = splitSchemaMigrations([]byte("CREATE TABLE users (id integer);\n"))
+	require.Equal(t, "CREATE TABLE users (id integer);\n", string(schema))
+	require.Nil(t, migrations)
+}
@@ -0,0 +1,59 @@
+package dbmate
+
+import (
+	"net/url"
+	"strings"
+)
+
+// shardQueryParam is the database URL parameter which sets the shard label, if Shard is
+// not set. It is removed from the URL before it is passed to the driver.
+const shardQueryParam = "shard"
+
+// shard returns the label of the shard which the database belongs to
+func (db *DB) shard() string {
+	if db.Shard != "" {
+		return db.Shard
+	}
+	if db.DatabaseURL == nil {
+		return ""
+	}
+
+	return db.DatabaseURL.Query().Get(shardQueryParam)
+}
+
+// matchesShard returns whether a migration block with the given options should be
+// executed against this database. Blocks without a shard option are executed everywhere.
+func (db *DB) matchesShard(options ParsedMigrationOptions) bool {
+	shards := options.Shards()
+	if len(shards) == 0 {
+		return true
+	}
+
+	shard := db.shard()
+	for _, s := range shards {
+		if s == shard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutShard returns a copy of u without the shard parameter
+func withoutShard(u *url.URL) *url.URL {
+	query := u.Query()
+	if !query.Has(shardQueryParam) {
+		return u
+	}
+
+	query.Del(shardQueryParam)
+	c := *u
+	c.RawQuery = query.Encode()
+	return &c
+}
+
+// skippedShardMessage describes a migration block which was not executed because it is
+// for other shards
+func skippedShardMessage(fileName string, options ParsedMigrationOptions) string {
+	return fileName + " is only for shard " + strings.Join(options.Shards(), ", ")
+}
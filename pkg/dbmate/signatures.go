@@ -0,0 +1,122 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrSignatureInvalid is returned when VerifySignatures is set, and a migration which is
+// about to be applied or rolled back is unsigned, or does not match its signature
+var ErrSignatureInvalid = errors.New("migration signatures could not be verified")
+
+// Signature tools, which verify the detached signature of each migration file
+const (
+	// SignatureMinisign verifies <file>.minisig with minisign, using the public key in
+	// SignatureKey
+	SignatureMinisign = "minisign"
+	// SignatureGPG verifies <file>.asc with gpg, using the keyring in SignatureKey (or the
+	// default keyring if it is empty)
+	SignatureGPG = "gpg"
+)
+
+// verifySignatures checks the detached signature of each migration file with the
+// VerifySignatures tool, and returns an error listing every migration which is unsigned
+// or does not match its signature. Go migrations are compiled into the binary, and are
+// not checked.
+func (db *DB) verifySignatures(migrations []Migration) error {
+	if db.VerifySignatures == "" {
+		return nil
+	}
+
+	var suffix string
+	switch db.VerifySignatures {
+	case SignatureMinisign:
+		if db.SignatureKey == "" {
+			return errors.New("minisign signature verification requires a public key")
+		}
+		suffix = ".minisig"
+	case SignatureGPG:
+		suffix = ".asc"
+	default:
+		return fmt.Errorf("unsupported signature tool: %s", db.VerifySignatures)
+	}
+
+	// the files are copied to disk for the tool to read, since they may come from a
+	// remote source
+	dir, err := os.MkdirTemp("", "dbmate-signatures")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	problems := []string{}
+	for _, migration := range migrations {
+		if migration.goMigration != nil {
+			continue
+		}
+
+		if err := db.verifySignature(dir, migration, suffix); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", migration.FilePath, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n  %s", ErrSignatureInvalid, strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// verifySignature checks the signature of a single migration file
+func (db *DB) verifySignature(dir string, migration Migration, suffix string) error {
+	signature, err := db.source().Read(migration.FilePath + suffix)
+	if err != nil {
+		return fmt.Errorf("missing signature %s", migration.FileName+suffix)
+	}
+
+	file := filepath.Join(dir, migration.FileName)
+	if err := writeMigrationFile(file, migration); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+suffix, signature, 0o600); err != nil {
+		return err
+	}
+
+	var args []string
+	switch db.VerifySignatures {
+	case SignatureMinisign:
+		args = []string{"-V", "-q", "-p", db.SignatureKey, "-m", file, "-x", file + suffix}
+	case SignatureGPG:
+		args = []string{"--batch", "--quiet"}
+		if db.SignatureKey != "" {
+			args = append(args, "--no-default-keyring", "--keyring", db.SignatureKey)
+		}
+		args = append(args, "--verify", file+suffix, file)
+	}
+
+	if _, err := dbutil.RunCommand(db.VerifySignatures, args...); err != nil {
+		return fmt.Errorf("invalid signature (%s)", strings.ReplaceAll(err.Error(), "\n", " "))
+	}
+
+	return nil
+}
+
+// writeMigrationFile copies the contents of migration to a file at path
+func writeMigrationFile(path string, migration Migration) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if err := migration.copyFile(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package dbmate
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// MigrationSource provides the migration files which dbmate lists and applies. It allows
+// migrations to be loaded from somewhere other than a filesystem, e.g. generated at runtime.
+type MigrationSource interface {
+	// List returns the names of the files (but not directories) in dir
+	List(dir string) ([]string, error)
+	// Read returns the contents of the file at path, which is a file name returned by
+	// List joined to its directory
+	Read(path string) ([]byte, error)
+}
+
+// MigrationOpener is implemented by sources which can open a migration file for reading,
+// which allows the up block of a large migration to be streamed rather than read into
+// memory
+type MigrationOpener interface {
+	// Open opens the file at path, as passed to Read
+	Open(path string) (io.ReadCloser, error)
+}
+
+// NewFSSource returns a MigrationSource which reads migrations from fsys, or from the
+// OS filesystem if fsys is nil. This is the default source.
+func NewFSSource(fsys fs.FS) MigrationSource {
+	return fsSource{fsys: fsys}
+}
+
+type fsSource struct {
+	fsys fs.FS
+}
+
+func (s fsSource) List(dir string) ([]string, error) {
+	path := filepath.Clean(dir)
+
+	// We use nil instead of os.DirFS() because DirFS cannot support both relative and absolute
+	// directory paths - it must be anchored at either "." or "/", which we do not know in advance.
+	// See: https://github.com/amacneil/dbmate/issues/403
+	var entries []fs.DirEntry
+	var err error
+	if s.fsys == nil {
+		entries, err = os.ReadDir(path)
+	} else {
+		entries, err = fs.ReadDir(s.fsys, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (s fsSource) Read(path string) ([]byte, error) {
+	if s.fsys == nil {
+		return os.ReadFile(path)
+	}
+
+	return fs.ReadFile(s.fsys, path)
+}
+
+func (s fsSource) Open(path string) (io.ReadCloser, error) {
+	if s.fsys == nil {
+		return os.Open(path)
+	}
+
+	return s.fsys.Open(path)
+}
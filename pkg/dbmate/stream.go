@@ -0,0 +1,168 @@
+package dbmate
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// omittedBody is the size of the body of a streamed up block, which readMigration omits
+type omittedBody struct {
+	bytes int
+	lines int
+}
+
+// readMigration reads a migration file from r. If its up block has the stream option, the
+// body of the block (between the up and down directives) is omitted, since it may be too
+// large to hold in memory, and is instead read again when the migration is applied. It
+// returns the contents, and the size of the body omitted after the up directive.
+func readMigration(r io.Reader) (string, omittedBody, error) {
+	br := bufio.NewReader(r)
+	var contents strings.Builder
+	omitted := omittedBody{}
+	seenUp, streaming := false, false
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", omittedBody{}, err
+		}
+
+		name, args := parseDirective(strings.TrimSuffix(line, "\n"))
+		if streaming && name == "down" {
+			streaming = false
+		}
+		if streaming {
+			omitted.bytes += len(line)
+			omitted.lines++
+		} else {
+			contents.WriteString(line)
+		}
+
+		if !seenUp && name == "up" {
+			seenUp = true
+			streaming = parseMigrationOptions(args).Stream()
+		}
+
+		if err == io.EOF {
+			return contents.String(), omitted, nil
+		}
+	}
+}
+
+// upBlockReader reads the body of the up block of a migration file, i.e. the lines
+// between its up and down directives
+type upBlockReader struct {
+	r       *bufio.Reader
+	started bool
+	done    bool
+	line    string
+}
+
+func (u *upBlockReader) Read(p []byte) (int, error) {
+	for u.line == "" {
+		if u.done {
+			return 0, io.EOF
+		}
+
+		line, err := u.r.ReadString('\n')
+		if err == io.EOF {
+			u.done = true
+		} else if err != nil {
+			return 0, err
+		}
+
+		name, _ := parseDirective(strings.TrimSuffix(line, "\n"))
+		switch {
+		case !u.started:
+			u.started = name == "up"
+		case name == "down":
+			u.done = true
+		default:
+			u.line = line
+		}
+	}
+
+	n := copy(p, u.line)
+	u.line = u.line[n:]
+
+	return n, nil
+}
+
+// execStreamedMigration executes the up block of a migration with the stream option,
+// reading its statements from the migration file and executing them one at a time. In
+// verbose mode, each statement is echoed, followed by its result and execution time.
+func (db *DB) execStreamedMigration(drv Driver, tx dbutil.Transaction, migration Migration) error {
+	f, err := migration.open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(f)
+
+	statements := dbutil.NewStatementReader(&upBlockReader{r: bufio.NewReader(f)}, splitOptions(drv))
+	for {
+		statement, err := statements.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		statement, err = db.expandTenant(statement)
+		if err != nil {
+			return err
+		}
+
+		if db.Verbose {
+			fmt.Fprintf(db.logger(LogLevelInfo), "Executing: %s\n", statement)
+		}
+		start := time.Now()
+		result, err := tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+		if db.Verbose {
+			db.printVerbose(result, time.Since(start))
+		}
+	}
+}
+
+// copyFile writes the full contents of the migration file to w, reading it again if the
+// body of a streamed up block was omitted when it was read
+func (m *Migration) copyFile(w io.Writer) error {
+	contents, omitted, err := m.readFile()
+	if err != nil {
+		return err
+	}
+
+	if omitted.bytes == 0 {
+		_, err := io.WriteString(w, contents)
+		return err
+	}
+
+	f, err := m.open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(f)
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// fileChecksum returns the sha256 checksum of the contents of the migration file
+func (m *Migration) fileChecksum() (string, error) {
+	h := sha256.New()
+	if err := m.copyFile(h); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,146 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrStrictUnsupported = errors.New("strict mode requires a driver which can record migration checksums")
+	ErrMigrationModified = errors.New("applied migrations have been modified")
+)
+
+// checksumTracker returns the ChecksumTracker used in strict mode
+func (db *DB) checksumTracker(drv Driver) (ChecksumTracker, error) {
+	tracker, ok := db.tracker(drv).(ChecksumTracker)
+	if !ok {
+		return nil, ErrStrictUnsupported
+	}
+
+	return tracker, nil
+}
+
+// verifyChecksums checks that the contents of each applied migration match the checksum
+// recorded when it was applied. Applied migrations without a checksum (which were applied
+// before strict mode was enabled) have their current checksum recorded.
+func (db *DB) verifyChecksums(drv Driver, sqlDB *sql.DB, migrations []Migration) error {
+	tracker, err := db.checksumTracker(drv)
+	if err != nil {
+		return err
+	}
+
+	if err := tracker.CreateChecksumsTable(sqlDB); err != nil {
+		return err
+	}
+
+	recorded, err := tracker.SelectChecksums(sqlDB)
+	if err != nil {
+		return err
+	}
+
+	modified := []string{}
+	for _, migration := range migrations {
+		if !migration.Applied || migration.goMigration != nil {
+			continue
+		}
+
+		sum, err := migrationChecksum(migration)
+		if err != nil {
+			return err
+		}
+
+		expected, ok := recorded[migration.Version]
+		if !ok {
+			fmt.Fprintf(db.logger(LogLevelInfo), "Recording checksum: %s\n", migration.FileName)
+			if err := tracker.InsertChecksum(sqlDB, migration.Version, sum); err != nil {
+				return err
+			}
+		} else if expected != sum {
+			modified = append(modified, fmt.Sprintf("%s (applied with sha256 %s, now sha256 %s)",
+				migration.FilePath, expected, sum))
+		}
+	}
+
+	if len(modified) > 0 {
+		return fmt.Errorf("%w:\n  %s\nrestore the original contents (see `git log -p -- <file>`), "+
+			"and make further changes in a new migration", ErrMigrationModified, strings.Join(modified, "\n  "))
+	}
+
+	return nil
+}
+
+// recordChecksum records the checksum of a migration which is being applied
+func (db *DB) recordChecksum(drv Driver, tx dbutil.Transaction, migration Migration) error {
+	tracker, err := db.checksumTracker(drv)
+	if err != nil {
+		return err
+	}
+
+	sum, err := migrationChecksum(migration)
+	if err != nil {
+		return err
+	}
+
+	// a checksum may remain from a migration rolled back outside of strict mode
+	if err := tracker.DeleteChecksum(tx, migration.Version); err != nil {
+		return err
+	}
+
+	return tracker.InsertChecksum(tx, migration.Version, sum)
+}
+
+// migrationChecksum returns the sha256 checksum of the contents of a migration file, or
+// an empty string for Go migrations
+func migrationChecksum(migration Migration) (string, error) {
+	if migration.goMigration != nil {
+		return "", nil
+	}
+
+	return migration.fileChecksum()
+}
+
+// Checksum states of applied migrations, reported by StatusExtended
+const (
+	ChecksumOK       = "ok"
+	ChecksumModified = "modified"
+	ChecksumUnknown  = "unknown"
+)
+
+// checksumStates compares the contents of each applied migration with the checksum
+// recorded when it was applied, and returns its checksum state keyed by version.
+// Migrations without a recorded checksum (because strict mode was not enabled when they
+// were applied, or the driver cannot record checksums) are unknown.
+func (db *DB) checksumStates(drv Driver, sqlDB *sql.DB, migrations []Migration) map[string]string {
+	recorded := map[string]string{}
+	if tracker, ok := db.tracker(drv).(ChecksumTracker); ok {
+		// the checksums table does not exist until strict mode is first used
+		if checksums, err := tracker.SelectChecksums(sqlDB); err == nil {
+			recorded = checksums
+		}
+	}
+
+	states := map[string]string{}
+	for _, migration := range migrations {
+		if !migration.Applied {
+			continue
+		}
+
+		expected, ok := recorded[migration.Version]
+		sum, err := migrationChecksum(migration)
+		switch {
+		case !ok || err != nil || sum == "":
+			states[migration.Version] = ChecksumUnknown
+		case sum == expected:
+			states[migration.Version] = ChecksumOK
+		default:
+			states[migration.Version] = ChecksumModified
+		}
+	}
+
+	return states
+}
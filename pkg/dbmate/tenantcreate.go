@@ -0,0 +1,176 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrTemplateUnsupported is returned by CreateTenant when the driver cannot copy databases
+var ErrTemplateUnsupported = errors.New("driver does not support creating databases from a template")
+
+// CreateTenant provisions a new tenant database: it creates the database, applies all
+// migrations, loads the fixture set named seeds (if not empty), and then registers the
+// tenant in the tenants source, so that it is included in future multi-tenant runs.
+//
+// If TenantTemplate is set, the template database is created (if necessary) and
+// migrated, and the tenant database is created as a copy of it, so that only the
+// migrations applied since the template was copied need to be applied.
+//
+// A tenant is registered by appending it to TenantsFile, or by running
+// TenantRegisterQuery (with the tenant name as its only parameter) against the database
+// in DatabaseURL. Tenants found with TenantPattern do not need to be registered, but the
+// name must match the pattern. The tenant is only registered once every other step has
+// succeeded, so a failed tenant is never migrated by later runs.
+func (db *DB) CreateTenant(tenant, seeds string) error {
+	if tenant == "" {
+		return errors.New("please specify a tenant name")
+	}
+	if db.TenantPattern != "" {
+		match, err := path.Match(db.TenantPattern, tenant)
+		if err != nil {
+			return fmt.Errorf("invalid tenant pattern `%s`: %w", db.TenantPattern, err)
+		}
+		if !match {
+			return fmt.Errorf("tenant %s does not match the tenant pattern `%s`", tenant, db.TenantPattern)
+		}
+	}
+
+	if tenant == db.TenantTemplate {
+		return fmt.Errorf("tenant %s is the tenant template", tenant)
+	}
+
+	t := db.ForTenant(tenant)
+	t.AutoDumpSchema = false
+	defer func() { _ = t.Close() }()
+
+	fmt.Fprintf(db.logger(LogLevelInfo), "Tenant: %s\n", tenant)
+	if db.TenantTemplate != "" {
+		if err := t.createFromTemplate(db.TenantTemplate); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	} else if err := t.Create(); err != nil {
+		return fmt.Errorf("tenant %s: %w", tenant, err)
+	}
+	if err := t.Migrate(); err != nil {
+		return fmt.Errorf("tenant %s: %w", tenant, err)
+	}
+	if seeds != "" {
+		if err := t.LoadFixtures(seeds); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+
+	return db.registerTenant(tenant)
+}
+
+// createFromTemplate migrates the template database, and creates the database as a copy
+// of it
+func (db *DB) createFromTemplate(template string) error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	cloner, ok := drv.(TemplateCloner)
+	if !ok {
+		return ErrTemplateUnsupported
+	}
+
+	// a copy of the template would contain the template name in place of the tenant name
+	migrations, err := db.findMigrationFiles()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		parsed, err := migration.Parse()
+		if err != nil {
+			return err
+		}
+		if strings.Contains(parsed.Up, TenantWildcard) {
+			return fmt.Errorf("%s uses %s, so tenants cannot be created from a template",
+				migration.FileName, TenantWildcard)
+		}
+	}
+
+	tmpl := db.ForTenant(template)
+	tmpl.AutoDumpSchema = false
+	err = tmpl.CreateAndMigrate()
+	// the template cannot be copied while it has open connections
+	if closeErr := tmpl.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("template %s: %w", template, err)
+	}
+
+	return cloner.CreateDatabaseFromTemplate(template)
+}
+
+// registerTenant adds tenant to the tenants source
+func (db *DB) registerTenant(tenant string) error {
+	switch {
+	case db.TenantsFile != "":
+		names, err := readTenantsFile(db.TenantsFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, name := range names {
+			if name == tenant {
+				return nil
+			}
+		}
+
+		if err := appendTenantsFile(db.TenantsFile, tenant); err != nil {
+			return err
+		}
+		fmt.Fprintf(db.logger(LogLevelInfo), "Registered: %s in %s\n", tenant, db.TenantsFile)
+	case db.TenantRegisterQuery != "":
+		drv, err := db.Driver()
+		if err != nil {
+			return err
+		}
+
+		sqlDB, release, err := db.open(drv)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		if _, err := sqlDB.Exec(db.TenantRegisterQuery, tenant); err != nil {
+			return fmt.Errorf("tenant register query: %w", err)
+		}
+		fmt.Fprintf(db.logger(LogLevelInfo), "Registered: %s\n", tenant)
+	case db.TenantsQuery != "":
+		fmt.Fprintf(db.logger(LogLevelWarn),
+			"Warning: %s was not registered, since no tenant register query is set\n", tenant)
+	}
+
+	return nil
+}
+
+// appendTenantsFile adds tenant to the end of a tenants file, creating it if necessary
+func appendTenantsFile(path, tenant string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	line := tenant + "\n"
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		line = "\n" + line
+	}
+	if _, err := f.WriteString(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package dbmate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNoTenantProgress is returned by MigrateTenants when resuming without a progress file
+var ErrNoTenantProgress = errors.New("no tenant progress to resume from")
+
+// Tenant progress statuses
+const (
+	TenantApplied = "applied"
+	TenantFailed  = "failed"
+)
+
+// TenantProgress records the outcome of the last attempt to migrate a tenant database
+type TenantProgress struct {
+	// Status is TenantApplied or TenantFailed
+	Status string `json:"status"`
+	// Error is the error which caused the tenant to fail
+	Error string `json:"error,omitempty"`
+	// Time is when the attempt finished
+	Time string `json:"time"`
+}
+
+// tenantProgressFile records the progress of a multi-tenant run, rewriting the file after
+// each tenant finishes so that it is up to date if the run is interrupted
+type tenantProgressFile struct {
+	mu      sync.Mutex
+	path    string
+	Tenants map[string]TenantProgress `json:"tenants"`
+}
+
+// ReadTenantProgress reads the progress of a previous multi-tenant run from path
+func ReadTenantProgress(path string) (map[string]TenantProgress, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var progress tenantProgressFile
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if progress.Tenants == nil {
+		progress.Tenants = map[string]TenantProgress{}
+	}
+
+	return progress.Tenants, nil
+}
+
+// openTenantProgress returns the progress file at path. When resuming, it starts with the
+// progress of the previous run, which must exist; otherwise it starts empty.
+func openTenantProgress(path string, resume bool) (*tenantProgressFile, error) {
+	progress := &tenantProgressFile{path: path, Tenants: map[string]TenantProgress{}}
+	if !resume {
+		return progress, nil
+	}
+
+	tenants, err := ReadTenantProgress(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrNoTenantProgress, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress.Tenants = tenants
+
+	return progress, nil
+}
+
+// applied reports whether tenant was migrated successfully by a previous run
+func (p *tenantProgressFile) applied(tenant string) bool {
+	return p.Tenants[tenant].Status == TenantApplied
+}
+
+// record saves the outcome of migrating tenant
+func (p *tenantProgressFile) record(tenant string, err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress := TenantProgress{
+		Status: TenantApplied,
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err != nil {
+		progress.Status = TenantFailed
+		progress.Error = err.Error()
+	}
+	p.Tenants[tenant] = progress
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that an interrupted write cannot lose progress
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p.path)
+}
@@ -0,0 +1,504 @@
+package dbmate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrTenantsUnsupported = errors.New("driver does not support listing tenant databases")
+	ErrNoTenants          = errors.New("no tenant databases found")
+)
+
+// TenantWildcard is replaced with the name of the tenant database in migrations
+const TenantWildcard = "{{TENANT}}"
+
+// tenantNameRegexp matches tenant names which are safe to substitute into SQL
+var tenantNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Status formats, used by StatusTenants
+const (
+	StatusFormatTable = "table"
+	StatusFormatJSON  = "json"
+)
+
+// TenantURL returns a copy of databaseURL which connects to the tenant database on the
+// same server (or for SQLite, the tenant file in the same directory)
+func TenantURL(databaseURL *url.URL, tenant string) *url.URL {
+	u := *databaseURL
+	if u.Opaque != "" {
+		// relative sqlite paths are stored in Opaque
+		u.Opaque = path.Join(path.Dir(u.Opaque), tenant)
+	} else {
+		u.Path = path.Join(path.Dir(u.Path), tenant)
+		u.RawPath = ""
+	}
+
+	return &u
+}
+
+// ForTenant returns a copy of db which targets the tenant database on the same server.
+// The copy does not share connections with db.
+func (db *DB) ForTenant(tenant string) *DB {
+	t := *db
+	t.DatabaseURL = TenantURL(db.DatabaseURL, tenant)
+	t.conn = &connCache{}
+
+	return &t
+}
+
+// TenantName returns the name of the database targeted by db, which is the tenant name
+// for copies returned by ForTenant
+func (db *DB) TenantName() string {
+	if db.DatabaseURL == nil {
+		return ""
+	}
+
+	name := db.DatabaseURL.Opaque
+	if name == "" {
+		name = db.DatabaseURL.Path
+	}
+	if name = path.Base(name); name == "." || name == "/" {
+		return ""
+	}
+
+	return name
+}
+
+// expandTenant replaces TenantWildcard in block with the tenant name. Since the name is
+// substituted as-is, it must only contain letters, digits, underscores, dots and dashes.
+func (db *DB) expandTenant(block string) (string, error) {
+	if !strings.Contains(block, TenantWildcard) {
+		return block, nil
+	}
+
+	name := db.TenantName()
+	if !tenantNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("cannot replace %s with the tenant name `%s`, which must only contain "+
+			"letters, digits, underscores, dots and dashes", TenantWildcard, name)
+	}
+
+	return strings.ReplaceAll(block, TenantWildcard, name), nil
+}
+
+// MultiTenant reports whether a source of tenant databases is configured, in which case
+// migrations should be applied with MigrateTenants
+func (db *DB) MultiTenant() bool {
+	return db.TenantPattern != "" || db.TenantsFile != "" || db.TenantsQuery != ""
+}
+
+// Tenants returns the names of the tenant databases. They are read from TenantsFile, or
+// the results of TenantsQuery, in the order listed. Otherwise, they are the databases on
+// the server in alphabetical order. In each case, only names matching TenantPattern (if
+// set) are returned. The TenantTemplate database is never included.
+func (db *DB) Tenants() ([]string, error) {
+	var names []string
+	var err error
+	switch {
+	case db.TenantsFile != "" && db.TenantsQuery != "":
+		return nil, errors.New("a tenants file and tenants query cannot be used together")
+	case db.TenantsFile != "":
+		names, err = readTenantsFile(db.TenantsFile)
+	case db.TenantsQuery != "":
+		names, err = db.queryTenants()
+	case db.TenantPattern != "":
+		names, err = db.listDatabases()
+	default:
+		return nil, fmt.Errorf("%w: no tenant source is set", ErrNoTenants)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if name == db.TenantTemplate {
+			continue
+		}
+		if db.TenantPattern != "" {
+			match, err := path.Match(db.TenantPattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tenant pattern `%s`: %w", db.TenantPattern, err)
+			}
+			if !match {
+				continue
+			}
+		}
+		tenants = append(tenants, name)
+	}
+	if len(tenants) == 0 {
+		return nil, ErrNoTenants
+	}
+
+	return tenants, nil
+}
+
+// listDatabases returns the databases on the server, in alphabetical order
+func (db *DB) listDatabases() ([]string, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := drv.(DatabaseLister)
+	if !ok {
+		return nil, ErrTenantsUnsupported
+	}
+
+	names, err := lister.ListDatabases()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// queryTenants returns the first column of the results of TenantsQuery, which is run
+// against the database in DatabaseURL
+func (db *DB) queryTenants() ([]string, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, release, err := db.open(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	names, err := dbutil.QueryColumn(sqlDB, db.TenantsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("tenants query: %w", err)
+	}
+
+	return names, nil
+}
+
+// readTenantsFile returns the tenant names in a file, one per line, ignoring blank lines
+// and comments starting with #
+func readTenantsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+
+	return names, nil
+}
+
+// MigrateTenants applies pending migrations to each tenant database, using up to
+// TenantWorkers tenants at once, and stops starting new tenants after one fails (unless
+// TenantKeepGoing is set). Each tenant records its applied migrations in its own
+// migrations table. The schema file is not written, since every tenant would overwrite it.
+//
+// If TenantCanary is set, that tenant is migrated and verified (using TenantCanaryQuery
+// and TenantCanaryCommand) first, and the other tenants are only migrated if it succeeds.
+//
+// If TenantProgressFile is set, the outcome of each tenant is recorded in it, and when
+// TenantResume is set, only the tenants which were not migrated successfully by the
+// previous run are migrated.
+func (db *DB) MigrateTenants() error {
+	return db.MigrateTenantsContext(context.Background())
+}
+
+// MigrateTenantsContext is like MigrateTenants, but stops applying migrations when ctx
+// is done
+func (db *DB) MigrateTenantsContext(ctx context.Context) error {
+	// every tenant shares the migration files, which are read and parsed once
+	db = db.withMigrationCache()
+	tenants, err := db.Tenants()
+	if err != nil {
+		return err
+	}
+	urls := map[string]string{}
+	for _, tenant := range tenants {
+		urls["tenant "+tenant] = TenantURL(db.DatabaseURL, tenant).String()
+	}
+	if err := ValidateURLs(urls); err != nil {
+		return err
+	}
+	if db.TenantCanary != "" {
+		if _, found := withoutCanary(tenants, db.TenantCanary); !found {
+			return fmt.Errorf("canary %s is not one of the tenants", db.TenantCanary)
+		}
+	}
+
+	var progress *tenantProgressFile
+	if db.TenantProgressFile != "" {
+		progress, err = openTenantProgress(db.TenantProgressFile, db.TenantResume)
+		if err != nil {
+			return err
+		}
+	} else if db.TenantResume {
+		return fmt.Errorf("%w: no tenant progress file is set", ErrNoTenantProgress)
+	}
+
+	if db.TenantResume {
+		remaining := []string{}
+		for _, tenant := range tenants {
+			if !progress.applied(tenant) {
+				remaining = append(remaining, tenant)
+			}
+		}
+		fmt.Fprintf(db.logger(LogLevelInfo), "Resuming: %d of %d tenants remaining\n", len(remaining), len(tenants))
+		tenants = remaining
+	}
+
+	if db.Preflight {
+		if err := db.preflightTenants(ctx, tenants); err != nil {
+			return err
+		}
+	}
+
+	migrateTenant := func(ctx context.Context, tenant string, t *DB, canary bool) error {
+		fmt.Fprintf(t.logger(LogLevelInfo), "Tenant: %s\n", tenant)
+		t.AutoDumpSchema = false
+		t.Preflight = false
+		err := t.MigrateContext(ctx)
+		if err == nil && canary {
+			err = t.verifyCanary(ctx)
+		}
+		if progress != nil {
+			if recordErr := progress.record(tenant, err); recordErr != nil {
+				return errors.Join(err, fmt.Errorf("recording tenant progress: %w", recordErr))
+			}
+		}
+		return err
+	}
+
+	// when resuming after the canary succeeded, it is not migrated or verified again
+	if rest, found := withoutCanary(tenants, db.TenantCanary); db.TenantCanary != "" && found {
+		err := db.forEachTenant(ctx, []string{db.TenantCanary}, func(ctx context.Context, _ int, t *DB) error {
+			return migrateTenant(ctx, db.TenantCanary, t, true)
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrCanaryFailed, err)
+		}
+		tenants = rest
+	}
+
+	return db.forEachTenant(ctx, tenants, func(ctx context.Context, i int, t *DB) error {
+		return migrateTenant(ctx, tenants[i], t, false)
+	})
+}
+
+// forEachTenant calls f with the index of each tenant, and a copy of db which targets it,
+// using up to TenantWorkers goroutines, and starting each tenant at least TenantInterval
+// after the previous one. The output of each tenant is buffered, and written to db.Log
+// in the order of tenants. No more tenants are started after f returns an error (unless
+// TenantKeepGoing is set), and the errors are returned in the order of tenants.
+func (db *DB) forEachTenant(ctx context.Context, tenants []string, f func(context.Context, int, *DB) error) error {
+	workers := db.TenantWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	logs := make([]strings.Builder, len(tenants))
+	errs := make([]error, len(tenants))
+	done := make([]chan struct{}, len(tenants))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	var failed atomic.Bool
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range tenants {
+			if i > 0 && db.TenantInterval > 0 {
+				select {
+				case <-time.After(db.TenantInterval):
+				case <-ctx.Done():
+				}
+			}
+			if failed.Load() || ctx.Err() != nil {
+				// tenants which are not started are marked as done without output
+				for ; i < len(tenants); i++ {
+					close(done[i])
+				}
+				return
+			}
+			jobs <- i
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				t := db.ForTenant(tenants[i])
+				t.Log = &logs[i]
+				if err := f(ctx, i, t); err != nil {
+					errs[i] = fmt.Errorf("tenant %s: %w", tenants[i], err)
+					if !db.TenantKeepGoing {
+						failed.Store(true)
+					}
+				}
+				_ = t.Close()
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range tenants {
+		<-done[i]
+		_, _ = io.WriteString(db.Log, logs[i].String())
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// TenantStatus summarizes the migrations applied to a tenant database
+type TenantStatus struct {
+	// Tenant is the name of the tenant database
+	Tenant string `json:"tenant"`
+	// Applied is the number of applied migrations
+	Applied int `json:"applied"`
+	// Pending is the number of pending migrations
+	Pending int `json:"pending"`
+	// OldestPending is the version of the first pending migration, if any
+	OldestPending string `json:"oldest_pending,omitempty"`
+	// Missing is the number of applied migrations which have no migration file
+	Missing int `json:"missing"`
+	// Error describes why the status of the tenant could not be read, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// StatusTenantsExtended returns the status of each tenant database, in the order of
+// Tenants. A tenant which cannot be read is included with its Error set, rather than
+// stopping the report.
+func (db *DB) StatusTenantsExtended() ([]TenantStatus, error) {
+	db = db.withMigrationCache()
+	tenants, err := db.Tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]TenantStatus, len(tenants))
+	err = db.forEachTenant(context.Background(), tenants, func(_ context.Context, i int, t *DB) error {
+		status := TenantStatus{Tenant: tenants[i]}
+		report, err := t.StatusExtended()
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Applied = len(report.Migrations) - len(report.Pending)
+			status.Pending = len(report.Pending)
+			status.Missing = len(report.Missing)
+			if len(report.Pending) > 0 {
+				status.OldestPending = report.Pending[0].Version
+			}
+		}
+
+		statuses[i] = status
+		return nil
+	})
+
+	return statuses, err
+}
+
+// StatusTenants writes the status of each tenant database, as a table or (if format is
+// "json") a JSON array, and returns the total number of pending migrations. An error is
+// returned after writing the report if any tenant could not be read.
+func (db *DB) StatusTenants(format string) (int, error) {
+	if format != "" && format != StatusFormatTable && format != StatusFormatJSON {
+		return -1, fmt.Errorf("unsupported status format: %s", format)
+	}
+
+	statuses, err := db.StatusTenantsExtended()
+	if err != nil {
+		return -1, err
+	}
+
+	totalPending := 0
+	failed := 0
+	rows := [][]string{{"TENANT", "APPLIED", "PENDING", "OLDEST PENDING", "MISSING", "ERROR"}}
+	for _, status := range statuses {
+		totalPending += status.Pending
+		if status.Error != "" {
+			failed++
+		}
+		rows = append(rows, []string{status.Tenant, strconv.Itoa(status.Applied), strconv.Itoa(status.Pending),
+			status.OldestPending, strconv.Itoa(status.Missing), status.Error})
+	}
+
+	if format == StatusFormatJSON {
+		enc := json.NewEncoder(db.Log)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(statuses); err != nil {
+			return -1, err
+		}
+	} else {
+		for _, line := range formatTable(rows) {
+			fmt.Fprintln(db.Log, line)
+		}
+	}
+
+	if failed > 0 {
+		return totalPending, fmt.Errorf("could not read the status of %d of %d tenants", failed, len(statuses))
+	}
+
+	return totalPending, nil
+}
+
+// TenantSchemaFile returns the path which the schema of the tenant database is dumped
+// to, by inserting the tenant name before the extension of path (e.g. db/schema.sql
+// becomes db/schema.tenant_a.sql)
+func TenantSchemaFile(path, tenant string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + tenant + ext
+}
+
+// DumpTenantSchemas writes the schema of each tenant database to its TenantSchemaFile
+// (and its schema migrations file, if SchemaMigrationsFile is set). Each dump runs the
+// driver's dump tool independently, so up to TenantWorkers tenants are dumped at once.
+func (db *DB) DumpTenantSchemas() error {
+	tenants, err := db.Tenants()
+	if err != nil {
+		return err
+	}
+
+	return db.forEachTenant(context.Background(), tenants, func(_ context.Context, i int, t *DB) error {
+		fmt.Fprintf(t.logger(LogLevelInfo), "Tenant: %s\n", tenants[i])
+		t.SchemaFile = TenantSchemaFile(db.SchemaFile, tenants[i])
+		if db.SchemaMigrationsFile != "" {
+			t.SchemaMigrationsFile = TenantSchemaFile(db.SchemaMigrationsFile, tenants[i])
+		}
+		return t.DumpSchema()
+	})
+}
@@ -0,0 +1,95 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrTimeoutUnsupported is returned when a migration block sets a timeout option, and
+// the driver does not support timeouts
+var ErrTimeoutUnsupported = errors.New("driver does not support lock_timeout and statement_timeout")
+
+// Timeout settings, which may also be set as migration block options
+const (
+	TimeoutLock      = "lock_timeout"
+	TimeoutStatement = "statement_timeout"
+)
+
+// blockTimeouts returns the timeouts to set for a migration block, which are LockTimeout
+// and StatementTimeout (if set) unless the block overrides them
+func (db *DB) blockTimeouts(options ParsedMigrationOptions) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	if db.LockTimeout > 0 {
+		timeouts[TimeoutLock] = db.LockTimeout
+	}
+	if db.StatementTimeout > 0 {
+		timeouts[TimeoutStatement] = db.StatementTimeout
+	}
+
+	for setting, option := range map[string]string{
+		TimeoutLock:      options.LockTimeout(),
+		TimeoutStatement: options.StatementTimeout(),
+	} {
+		if option == "" {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(option)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid %s option: %s", setting, option)
+		}
+		timeouts[setting] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// setTimeouts applies the lock and statement timeouts for the remainder of a migration
+// block, and returns a function which restores the previous timeouts. LockTimeout and
+// StatementTimeout are ignored by drivers which do not support timeouts, but block
+// options are not.
+func (db *DB) setTimeouts(drv Driver, tx dbutil.Transaction, options ParsedMigrationOptions) (func() error, error) {
+	noop := func() error { return nil }
+
+	timeouts, err := db.blockTimeouts(options)
+	if err != nil {
+		return nil, err
+	}
+
+	setter, ok := drv.(TimeoutSetter)
+	if !ok {
+		if options.LockTimeout() != "" || options.StatementTimeout() != "" {
+			return nil, ErrTimeoutUnsupported
+		}
+		return noop, nil
+	}
+
+	restores := []func() error{}
+	restore := func() error {
+		for i := len(restores) - 1; i >= 0; i-- {
+			if err := restores[i](); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, setting := range []string{TimeoutLock, TimeoutStatement} {
+		timeout, ok := timeouts[setting]
+		if !ok {
+			continue
+		}
+
+		r, err := setter.SetTimeout(tx, setting, timeout)
+		if err != nil {
+			_ = restore()
+			return nil, err
+		}
+		restores = append(restores, r)
+	}
+
+	return restoreOnce(restore), nil
+}
@@ -0,0 +1,179 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrMalformedURL is returned by ValidateURL for a database url which cannot be used to
+// connect to a database, even though it can be parsed
+var ErrMalformedURL = errors.New("malformed database url")
+
+// serverSchemes are the schemes of drivers which connect to a database server. The
+// value reports whether the url must name a database.
+var serverSchemes = map[string]bool{
+	"clickhouse": false,
+	"mysql":      true,
+	"postgres":   true,
+	"postgresql": true,
+}
+
+// fileSchemes are the schemes of drivers which open a database file
+var fileSchemes = map[string]bool{
+	"sqlite":  true,
+	"sqlite3": true,
+}
+
+// schemeTypos maps common mistakes in url schemes to the scheme which was probably meant
+var schemeTypos = map[string]string{
+	"cockroach":   "postgres",
+	"cockroachdb": "postgres",
+	"mariadb":     "mysql",
+	"pg":          "postgres",
+	"pgsql":       "postgres",
+	"postgis":     "postgres",
+	"psql":        "postgres",
+	"redshift":    "postgres",
+	"file":        "sqlite",
+}
+
+// ValidateURL checks that u can be used to connect to a database, without connecting to
+// it: its scheme must belong to a registered driver, and for database servers it must
+// have a host (or socket) and database name in the usual places. The error describes how
+// to fix the url, and never includes its credentials.
+func ValidateURL(u *url.URL) error {
+	if u == nil || u.Scheme == "" {
+		return ErrInvalidURL
+	}
+
+	if _, ok := GetDriverFunc(u.Scheme); !ok {
+		return fmt.Errorf("%w: %s (%s)", ErrUnsupportedDriver, u.Scheme, schemeSuggestion(u.Scheme))
+	}
+
+	if fileSchemes[u.Scheme] {
+		if u.Opaque == "" && u.Path == "" {
+			return fmt.Errorf("%w: missing the database file path, e.g. %s:db/database.sqlite3",
+				ErrMalformedURL, u.Scheme)
+		}
+		return nil
+	}
+
+	requireName, ok := serverSchemes[u.Scheme]
+	if !ok {
+		// drivers registered by other packages may use any url format
+		return nil
+	}
+
+	example := fmt.Sprintf("%s://localhost/myapp", u.Scheme)
+	if u.Opaque != "" {
+		return fmt.Errorf("%w: missing // after %s:, e.g. %s", ErrMalformedURL, u.Scheme, example)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" && len(segments) > 1 {
+		return fmt.Errorf("%w: the host is in the path, add another / after %s:, e.g. %s",
+			ErrMalformedURL, u.Scheme, example)
+	}
+	if len(segments) > 1 {
+		return fmt.Errorf("%w: the database name %q cannot contain /", ErrMalformedURL, strings.Trim(u.Path, "/"))
+	}
+	if requireName && segments[0] == "" {
+		if u.Fragment != "" {
+			return fmt.Errorf("%w: missing the database name, which may be hidden by a # in the "+
+				"password (escape it as %%23)", ErrMalformedURL)
+		}
+		return fmt.Errorf("%w: missing the database name, e.g. %s", ErrMalformedURL, example)
+	}
+
+	return nil
+}
+
+// ValidateURLs parses and validates each named url, and returns an error listing every
+// invalid url, so that they can all be fixed before a command which uses them starts.
+// Empty urls are skipped.
+func ValidateURLs(urls map[string]string) error {
+	names := make([]string, 0, len(urls))
+	for name := range urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		if urls[name] == "" {
+			continue
+		}
+
+		u, err := url.Parse(urls[name])
+		if err != nil {
+			// the url.Error message includes the url, which may include a password
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w: %w", name, ErrMalformedURL, err))
+			continue
+		}
+
+		if err := ValidateURL(u); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// schemeSuggestion suggests the registered scheme which was probably meant instead of
+// scheme, or lists the registered schemes
+func schemeSuggestion(scheme string) string {
+	if scheme == "jdbc" {
+		return "remove the jdbc: prefix"
+	}
+
+	if typo, ok := schemeTypos[scheme]; ok {
+		if _, registered := GetDriverFunc(typo); registered {
+			return fmt.Sprintf("did you mean %s://?", typo)
+		}
+	}
+
+	for _, registered := range driverSchemes() {
+		if editDistance(scheme, registered) <= 2 {
+			return fmt.Sprintf("did you mean %s://?", registered)
+		}
+	}
+
+	return "supported schemes are " + strings.Join(driverSchemes(), ", ")
+}
+
+// editDistance returns the number of single character insertions, deletions and
+// substitutions needed to change a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
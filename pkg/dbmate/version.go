@@ -1,4 +1,106 @@
 package dbmate
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Version of dbmate
 const Version = "2.3.0"
+
+// versionOperators are the supported version constraint operators, longest first
+var versionOperators = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// satisfiesVersion reports whether version satisfies a comma-separated list of
+// constraints, e.g. ">=2.0" or ">=2.1, <3". A constraint without an operator is
+// treated as a minimum version.
+func satisfiesVersion(version, constraints string) (bool, error) {
+	for _, constraint := range strings.Split(constraints, ",") {
+		constraint = strings.TrimSpace(constraint)
+
+		op := ">="
+		for _, o := range versionOperators {
+			if strings.HasPrefix(constraint, o) {
+				op = o
+				constraint = strings.TrimSpace(constraint[len(o):])
+				break
+			}
+		}
+
+		cmp, err := compareVersions(version, constraint)
+		if err != nil {
+			return false, err
+		}
+
+		var ok bool
+		switch op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			ok = cmp == 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// compareVersions compares two dotted version numbers, returning -1, 0 or 1.
+// Missing components are treated as zero, so "2" == "2.0" == "2.0.0".
+func compareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func parseVersion(s string) ([3]int, error) {
+	out := [3]int{}
+
+	// ignore leading "v" and any pre-release or build suffix
+	v := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if v == "" || len(parts) > len(out) {
+		return out, fmt.Errorf("invalid version %q", s)
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return out, fmt.Errorf("invalid version %q", s)
+		}
+		out[i] = n
+	}
+
+	return out, nil
+}
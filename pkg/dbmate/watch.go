@@ -0,0 +1,230 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch checks for new migration files by default, when
+// it cannot be notified of changes to them
+const DefaultWatchInterval = time.Second
+
+// watchSettleDelay is how long Watch waits after a file changes for further changes,
+// so that a file which is written in several steps is only read once it is complete
+const watchSettleDelay = 50 * time.Millisecond
+
+// errWatchUnsupported is returned by watchDirs on platforms without file notifications
+var errWatchUnsupported = errors.New("file notifications are not supported on this platform")
+
+// dirWatcher sends to changes whenever a file in one of the watched directories is
+// written, renamed or removed. changes is closed when the watch stops, after setting err
+// if it stopped because of an error.
+type dirWatcher struct {
+	changes chan struct{}
+	err     error
+}
+
+// notify records a change without blocking, since Watch only needs to know that something
+// changed since it last checked
+func (w *dirWatcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Watch creates the database if it does not exist and applies pending migrations, then
+// applies new migration files as they are created, until ctx is done. Local migrations
+// directories are watched using file notifications where the platform supports them;
+// otherwise (and for an FS or Source) the migration files are listed every interval. The
+// database is only queried when the files change, and the connection pool is kept open
+// in between. Errors are reported to the log rather than stopping the watch. A migration
+// which failed is only retried after a migration file changes, but a database which could
+// not be reached is retried every interval.
+func (db *DB) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	w := *db
+	w.ReuseConnections = true
+	w.conn = &connCache{}
+	defer func() { _ = w.Close() }()
+
+	var changes <-chan struct{}
+	var watcher *dirWatcher
+	if w.FS == nil && w.Source == nil {
+		var err error
+		watcher, err = watchDirs(ctx, w.MigrationsDir)
+		if err != nil && !errors.Is(err, errWatchUnsupported) {
+			return err
+		}
+	}
+	if watcher != nil {
+		changes = watcher.changes
+	} else {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		polls := make(chan struct{})
+		changes = polls
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				select {
+				case polls <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	fmt.Fprintf(w.logger(LogLevelInfo), "Watching: %s\n", strings.Join(w.MigrationsDir, ", "))
+
+	// errors are only reported when they change, so that an unreachable database does not
+	// report the same error on every check
+	lastErr := ""
+	report := func(err error) {
+		if err == nil || err.Error() == lastErr || ctx.Err() != nil {
+			lastErr = ""
+			return
+		}
+		lastErr = err.Error()
+		fmt.Fprintln(w.logger(LogLevelError), w.colorize(ColorRed, fmt.Sprintf("Error: %s", err)))
+	}
+
+	// a migration which failed is only retried after the files change, but a database
+	// which could not be reached is retried every interval until it can be
+	var retry <-chan time.Time
+	migrate := func(fn func(context.Context) error) {
+		err := fn(ctx)
+		report(err)
+		retry = nil
+		var opErr *net.OpError
+		if errors.Is(err, ErrCantConnect) || errors.As(err, &opErr) {
+			retry = time.After(interval)
+		}
+	}
+
+	attempted, err := w.migrationsFingerprint()
+	report(err)
+	migrate(w.CreateAndMigrateContext)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-retry:
+			migrate(w.CreateAndMigrateContext)
+			continue
+		case _, ok := <-changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return watcher.err
+			}
+		}
+
+		if watcher != nil && !settle(ctx, changes, watchSettleDelay) {
+			continue
+		}
+
+		files, err := w.migrationsFingerprint()
+		if err != nil {
+			report(err)
+			continue
+		}
+		if files == attempted {
+			continue
+		}
+
+		attempted = files
+		migrate(w.MigrateContext)
+	}
+}
+
+// settle waits until changes has received nothing for delay. It returns false if ctx is
+// done or changes is closed first.
+func settle(ctx context.Context, changes <-chan struct{}, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case _, ok := <-changes:
+			if !ok {
+				return false
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(delay)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// migrationsFingerprint returns a string which identifies the migration files, along with
+// their size and modification time where the filesystem provides them, so that Watch can
+// tell when they change without reading them or querying the database. Files from a
+// Source are identified by name only.
+func (db *DB) migrationsFingerprint() (string, error) {
+	var fingerprint strings.Builder
+	for _, dir := range db.MigrationsDir {
+		if db.Source != nil {
+			names, err := db.Source.List(dir)
+			if err != nil {
+				return "", fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir)
+			}
+			for _, name := range names {
+				if migrationFileRegexp.MatchString(name) {
+					fmt.Fprintln(&fingerprint, filepath.Join(dir, name))
+				}
+			}
+			continue
+		}
+
+		var entries []fs.DirEntry
+		var err error
+		if db.FS == nil {
+			entries, err = os.ReadDir(filepath.Clean(dir))
+		} else {
+			entries, err = fs.ReadDir(db.FS, filepath.Clean(dir))
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !migrationFileRegexp.MatchString(entry.Name()) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				// removed since the directory was read
+				continue
+			} else if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&fingerprint, "%s %d %d\n", filepath.Join(dir, entry.Name()), info.Size(),
+				info.ModTime().UnixNano())
+		}
+	}
+
+	return fingerprint.String(), nil
+}
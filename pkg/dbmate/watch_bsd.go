@@ -0,0 +1,179 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// kqueueWatcher holds the files watched by a kqueue
+type kqueueWatcher struct {
+	kq   int
+	dirs []string
+	// fds maps each watched path to its file descriptor, and paths the reverse
+	fds   map[string]int
+	paths map[int]string
+}
+
+// watchDirs watches dirs for changes using kqueue, until ctx is done. kqueue reports
+// changes to the entries of a directory but not to the contents of the files in it, so
+// each migration file is also watched, and the directories are scanned again after each
+// change to watch files which were added.
+func watchDirs(ctx context.Context, dirs []string) (*dirWatcher, error) {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return nil, os.NewSyscallError("kqueue", err)
+	}
+	k := &kqueueWatcher{kq: kq, dirs: dirs, fds: map[string]int{}, paths: map[int]string{}}
+
+	// the read end of the pipe becomes readable when the write end is closed, which wakes
+	// the kqueue once ctx is done
+	var pipe [2]int
+	if err := unix.Pipe(pipe[:]); err != nil {
+		k.close()
+		return nil, os.NewSyscallError("pipe", err)
+	}
+	closePipe := func() {
+		_ = unix.Close(pipe[0])
+		_ = unix.Close(pipe[1])
+	}
+	if err := k.register(pipe[0], unix.EVFILT_READ, 0); err != nil {
+		closePipe()
+		k.close()
+		return nil, err
+	}
+	if err := k.scan(); err != nil {
+		closePipe()
+		k.close()
+		return nil, err
+	}
+
+	w := &dirWatcher{changes: make(chan struct{}, 1)}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = unix.Close(pipe[1])
+	}()
+	go func() {
+		defer close(w.changes)
+		defer close(done)
+		defer func() { _ = unix.Close(pipe[0]) }()
+		defer k.close()
+
+		events := make([]unix.Kevent_t, 16)
+		for {
+			n, err := unix.Kevent(kq, nil, events, nil)
+			if errors.Is(err, unix.EINTR) {
+				continue
+			} else if err != nil {
+				w.err = os.NewSyscallError("kevent", err)
+				return
+			}
+
+			for _, event := range events[:n] {
+				fd := int(event.Ident)
+				if fd == pipe[0] {
+					return
+				}
+				if event.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0 {
+					k.remove(fd)
+				}
+			}
+
+			// a file may be removed while the directory is being scanned
+			if err := k.scan(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				w.err = err
+				return
+			}
+			w.notify()
+		}
+	}()
+
+	return w, nil
+}
+
+// register adds a kevent for fd to the kqueue
+func (k *kqueueWatcher) register(fd, filter int, fflags uint32) error {
+	changes := make([]unix.Kevent_t, 1)
+	unix.SetKevent(&changes[0], fd, filter, unix.EV_ADD|unix.EV_CLEAR)
+	changes[0].Fflags = fflags
+	if _, err := unix.Kevent(k.kq, changes, nil, nil); err != nil {
+		return os.NewSyscallError("kevent", err)
+	}
+
+	return nil
+}
+
+// add watches the file or directory at path, if it is not already watched
+func (k *kqueueWatcher) add(path string) error {
+	if _, ok := k.fds[path]; ok {
+		return nil
+	}
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	const fflags = unix.NOTE_WRITE | unix.NOTE_EXTEND | unix.NOTE_ATTRIB | unix.NOTE_DELETE | unix.NOTE_RENAME
+	if err := k.register(fd, unix.EVFILT_VNODE, fflags); err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+	k.fds[path] = fd
+	k.paths[fd] = path
+
+	return nil
+}
+
+// remove stops watching the file with descriptor fd
+func (k *kqueueWatcher) remove(fd int) {
+	path, ok := k.paths[fd]
+	if !ok {
+		return
+	}
+	// closing the descriptor removes its kevents
+	_ = unix.Close(fd)
+	delete(k.paths, fd)
+	delete(k.fds, path)
+}
+
+// scan watches each directory and the migration files in it
+func (k *kqueueWatcher) scan() error {
+	for _, dir := range k.dirs {
+		if err := k.add(dir); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !migrationFileRegexp.MatchString(entry.Name()) {
+				continue
+			}
+			if err := k.add(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// close closes the kqueue and every watched file
+func (k *kqueueWatcher) close() {
+	for fd := range k.paths {
+		_ = unix.Close(fd)
+	}
+	_ = unix.Close(k.kq)
+}
@@ -0,0 +1,51 @@
+package dbmate
+
+import (
+	"context"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchDirs watches dirs for changes using inotify, until ctx is done
+func watchDirs(ctx context.Context, dirs []string) (*dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// the descriptor is non-blocking, so reads use the runtime poller and are interrupted
+	// when the file is closed
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	// files are only reported once they are closed after writing, so that Watch does not
+	// read a migration which is still being written
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+			_ = f.Close()
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+	}
+
+	w := &dirWatcher{changes: make(chan struct{}, 1)}
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+	go func() {
+		defer close(w.changes)
+		// the events themselves are not needed, since Watch lists the files after each change
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			if _, err := f.Read(buf); err != nil {
+				if ctx.Err() == nil {
+					w.err = err
+				}
+				return
+			}
+			w.notify()
+		}
+	}()
+
+	return w, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package dbmate
+
+import (
+	"context"
+)
+
+// watchDirs is not supported on this platform, so Watch lists the migration files every
+// interval instead
+func watchDirs(_ context.Context, _ []string) (*dirWatcher, error) {
+	return nil, errWatchUnsupported
+}
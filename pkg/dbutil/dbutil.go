@@ -5,9 +5,11 @@ import (
 	"bytes"
 	"database/sql"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"os/exec"
+	"regexp"
 	"strings"
 	"unicode"
 )
@@ -97,6 +99,268 @@ func TrimLeadingSQLComments(data []byte) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+var dollarQuoteRegexp = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// ErrUnsplittableStatement is returned by StatementReader for SQL which cannot be split
+// into statements safely
+var ErrUnsplittableStatement = errors.New("statement cannot be split safely")
+
+// SplitOptions describes the syntax of the SQL which is split into statements
+type SplitOptions struct {
+	// BackslashEscapes is set if a backslash escapes the next character in a quoted string,
+	// as in MySQL and ClickHouse. Postgres E'' strings always allow backslash escapes.
+	BackslashEscapes bool
+}
+
+// SplitStatements splits sql into individual statements at semicolons, ignoring semicolons
+// inside quotes, comments and dollar quoted strings. Leading comments are removed from
+// each statement, except for MySQL conditional comments (/*! ... */), which are executed,
+// and empty statements are skipped. It returns false if sql cannot be split safely,
+// because it contains a compound BEGIN ... END block (e.g. a trigger body) or an
+// unterminated quote.
+func SplitStatements(sql string) ([]string, bool) {
+	return SplitStatementsWith(sql, SplitOptions{})
+}
+
+// SplitStatementsWith splits sql into statements as by SplitStatements, using the syntax
+// described by opts
+func SplitStatementsWith(sql string, opts SplitOptions) ([]string, bool) {
+	s := statementSplitter{opts: opts, statements: []string{}}
+	for sql != "" {
+		end := strings.IndexByte(sql, '\n') + 1
+		if end == 0 {
+			end = len(sql)
+		}
+		if !s.scanLine(sql[:end]) {
+			return nil, false
+		}
+		sql = sql[end:]
+	}
+
+	if !s.finish() {
+		return nil, false
+	}
+
+	return s.statements, true
+}
+
+// statementSplitter splits SQL into statements one line at a time, keeping track of any
+// quote or comment which continues onto the next line, so that each line is only scanned
+// once. Tokens never span lines, other than quotes and block comments.
+type statementSplitter struct {
+	opts SplitOptions
+	// statement is the current statement, from its first non-comment character
+	statement strings.Builder
+	started   bool
+	// closing is the delimiter which ends the quote or block comment which the last line
+	// ended inside, if any, and escapes is set if backslashes escape characters in it
+	closing    string
+	escapes    bool
+	statements []string
+}
+
+// scanLine splits a line of SQL, including its newline, appending each statement which it
+// completes to statements. It returns false if the SQL cannot be split safely.
+func (s *statementSplitter) scanLine(line string) bool {
+	i := 0
+	if s.closing != "" {
+		end := indexClosing(line, s.closing, s.escapes)
+		if end < 0 {
+			s.write(line)
+			return true
+		}
+		i = end + len(s.closing)
+		s.write(line[:i])
+		s.closing = ""
+	}
+
+	for i < len(line) {
+		c := line[i]
+		next := i + 1
+
+		switch {
+		case strings.HasPrefix(line[i:], "--"):
+			next = len(line)
+			if end := strings.IndexByte(line[i:], '\n'); end >= 0 {
+				next = i + end
+			}
+		case strings.HasPrefix(line[i:], "/*") && !strings.HasPrefix(line[i:], "/*!"):
+			next = s.skipTo(line, i+2, "*/", false)
+		case unicode.IsSpace(rune(c)):
+		case c == ';':
+			if s.started {
+				s.statement.WriteByte(c)
+				s.statements = append(s.statements, strings.TrimSpace(s.statement.String()))
+			}
+			s.statement.Reset()
+			s.started = false
+			i = next
+			continue
+		default:
+			s.started = true
+
+			switch {
+			case strings.HasPrefix(line[i:], "/*!"):
+				// a MySQL conditional comment contains SQL which MySQL executes
+				next = s.skipTo(line, i+3, "*/", false)
+			case c == '\'' || c == '"':
+				escapes := s.opts.BackslashEscapes || c == '\'' && isEscapeStringPrefix(line, i)
+				next = s.skipTo(line, i+1, string(c), escapes)
+			case c == '`':
+				next = s.skipTo(line, i+1, string(c), false)
+			case c == '$' && dollarQuoteRegexp.MatchString(line[i:]):
+				tag := dollarQuoteRegexp.FindString(line[i:])
+				next = s.skipTo(line, i+len(tag), tag, false)
+			case isWordStart(line, i):
+				for next < len(line) && isWordChar(line[next]) {
+					next++
+				}
+				if strings.EqualFold(line[i:next], "begin") {
+					return false
+				}
+			}
+		}
+
+		s.write(line[i:next])
+		i = next
+	}
+
+	return true
+}
+
+// skipTo returns the offset in line just after the closing delimiter of a quote or
+// comment, searching from offset from. If the line ends first, the rest of the line is
+// skipped, and the delimiter is looked for on the next line.
+func (s *statementSplitter) skipTo(line string, from int, closing string, escapes bool) int {
+	end := indexClosing(line[from:], closing, escapes)
+	if end < 0 {
+		s.closing = closing
+		s.escapes = escapes
+		return len(line)
+	}
+
+	return from + end + len(closing)
+}
+
+// indexClosing returns the offset of the first closing delimiter in s, skipping any which
+// are escaped with a backslash if escapes is set, or -1 if there is none
+func indexClosing(s, closing string, escapes bool) int {
+	if !escapes {
+		return strings.Index(s, closing)
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if strings.HasPrefix(s[i:], closing) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// isEscapeStringPrefix returns whether the quote at offset i of line starts a Postgres
+// escape string constant, e.g. E'it\'s'
+func isEscapeStringPrefix(line string, i int) bool {
+	return i > 0 && (line[i-1] == 'E' || line[i-1] == 'e') && (i == 1 || !isWordChar(line[i-2]))
+}
+
+// write appends SQL to the current statement, if it has started, so that comments before
+// a statement are removed
+func (s *statementSplitter) write(sql string) {
+	if s.started {
+		s.statement.WriteString(sql)
+	}
+}
+
+// finish appends the final statement, which may not end with a semicolon. It returns
+// false if the SQL ends inside a quote or comment.
+func (s *statementSplitter) finish() bool {
+	if s.closing != "" {
+		return false
+	}
+	if s.started {
+		s.statements = append(s.statements, strings.TrimSpace(s.statement.String()))
+		s.statement.Reset()
+		s.started = false
+	}
+
+	return true
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isWordStart(s string, i int) bool {
+	return isWordChar(s[i]) && (i == 0 || !isWordChar(s[i-1]))
+}
+
+// StatementReader reads the statements in SQL from a reader one at a time, so that a
+// file which is too large to hold in memory can be executed statement by statement.
+// Statements are split as by SplitStatementsWith, but each line is only scanned once. SQL
+// which cannot be split safely, such as a compound BEGIN ... END block, is reported with
+// ErrUnsplittableStatement rather than read into memory.
+type StatementReader struct {
+	r        *bufio.Reader
+	splitter statementSplitter
+	pending  []string
+	eof      bool
+}
+
+// NewStatementReader returns a StatementReader which reads from r, splitting its
+// statements using the syntax described by opts
+func NewStatementReader(r io.Reader, opts SplitOptions) *StatementReader {
+	return &StatementReader{r: bufio.NewReader(r), splitter: statementSplitter{opts: opts}}
+}
+
+// Next returns the next statement, or io.EOF once every statement has been read
+func (s *StatementReader) Next() (string, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return "", io.EOF
+		}
+
+		line, err := s.r.ReadString('\n')
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return "", err
+		}
+
+		if !s.splitter.scanLine(line) {
+			return "", fmt.Errorf("%w: compound BEGIN ... END blocks are not supported: %s",
+				ErrUnsplittableStatement, s.statementStart())
+		}
+		if s.eof && !s.splitter.finish() {
+			return "", fmt.Errorf("%w: unterminated quote or comment: %s", ErrUnsplittableStatement,
+				s.statementStart())
+		}
+
+		s.pending = s.splitter.statements
+		s.splitter.statements = nil
+	}
+
+	statement := s.pending[0]
+	s.pending = s.pending[1:]
+
+	return statement, nil
+}
+
+// statementStart returns the first line of the current statement, to identify it in errors
+func (s *StatementReader) statementStart() string {
+	statement := strings.TrimSpace(s.splitter.statement.String())
+	if end := strings.IndexByte(statement, '\n'); end >= 0 {
+		statement = statement[:end]
+	}
+	if len(statement) > 60 {
+		statement = statement[:60] + "..."
+	}
+
+	return statement
+}
+
 // QueryColumn runs a SQL statement and returns a slice of strings
 // it is assumed that the statement returns only one column
 // e.g. schema_migrations table
@@ -124,6 +388,31 @@ func QueryColumn(db Transaction, query string, args ...interface{}) ([]string, e
 	return result, nil
 }
 
+// QueryMap runs a SQL statement and returns a map of the first column to the second
+// it is assumed that the statement returns two columns
+func QueryMap(db Transaction, query string, args ...interface{}) (map[string]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer MustClose(rows)
+
+	result := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+
+		result[k] = v
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // QueryValue runs a SQL statement and returns a single string
 // it is assumed that the statement returns only one row and one column
 // sql NULL is returned as empty string
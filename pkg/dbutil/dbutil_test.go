@@ -2,6 +2,8 @@ package dbutil_test
 
 import (
 	"database/sql"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
@@ -35,6 +37,139 @@ func TestTrimLeadingSQLComments(t *testing.T) {
 	require.Equal(t, "real stuff\n-- end\n", string(out))
 }
 
+func TestSplitStatements(t *testing.T) {
+	examples := []struct {
+		in       string
+		expected []string
+		ok       bool
+	}{
+		{"", []string{}, true},
+		{"-- migrate:up\ncreate table users (id int);\n\ninsert into users values (1);\n",
+			[]string{"create table users (id int);", "insert into users values (1);"}, true},
+		// missing final semicolon
+		{"select 1; select 2", []string{"select 1;", "select 2"}, true},
+		// semicolons in quotes and comments
+		{"insert into t values ('a;b', \"c;d\", `e;f`); -- x; y\nselect /* ; */ 1;",
+			[]string{"insert into t values ('a;b', \"c;d\", `e;f`);", "select /* ; */ 1;"}, true},
+		{"select 'it''s; fine';", []string{"select 'it''s; fine';"}, true},
+		// dollar quoting
+		{"create function f() returns int as $body$ select 1; $body$ language sql; select 2;",
+			[]string{"create function f() returns int as $body$ select 1; $body$ language sql;", "select 2;"}, true},
+		// compound statements can't be split
+		{"create trigger t after insert on a begin insert into b values (1); end;", nil, false},
+		// unterminated quote
+		{"select 'abc;", nil, false},
+		// MySQL conditional comments are kept, since MySQL executes them
+		{"/*!40101 SET NAMES utf8 */;\n/* comment */ select 1;",
+			[]string{"/*!40101 SET NAMES utf8 */;", "select 1;"}, true},
+		// empty statements are skipped
+		{"select 1;; ;\n;select 2;", []string{"select 1;", "select 2;"}, true},
+		// backslashes only escape quotes in Postgres escape strings
+		{"select E'it\\'s; ok', e'a\\\\'; select 'C:\\'; select 2;",
+			[]string{"select E'it\\'s; ok', e'a\\\\';", "select 'C:\\';", "select 2;"}, true},
+	}
+
+	for _, example := range examples {
+		statements, ok := dbutil.SplitStatements(example.in)
+		require.Equal(t, example.ok, ok, example.in)
+		require.Equal(t, example.expected, statements, example.in)
+	}
+}
+
+func TestSplitStatementsBackslashEscapes(t *testing.T) {
+	opts := dbutil.SplitOptions{BackslashEscapes: true}
+	examples := []struct {
+		in       string
+		expected []string
+	}{
+		{"insert into t values ('it\\'s; ok');", []string{"insert into t values ('it\\'s; ok');"}},
+		// an even number of escaped quotes must not end the string
+		{"insert into t values ('a\\'; b\\'c', \"d\\\"; e\");\nselect 1;",
+			[]string{"insert into t values ('a\\'; b\\'c', \"d\\\"; e\");", "select 1;"}},
+		{"insert into t values ('C:\\\\'); select 1;", []string{"insert into t values ('C:\\\\');", "select 1;"}},
+		// escaped quotes in strings which span lines
+		{"insert into t values ('a\\'\n;b\\'');\nselect 1;",
+			[]string{"insert into t values ('a\\'\n;b\\'');", "select 1;"}},
+		// mysqldump output
+		{"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+			"INSERT INTO `users` VALUES (1,'O\\'Brien'),(2,'a;b');\n",
+			[]string{"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;",
+				"INSERT INTO `users` VALUES (1,'O\\'Brien'),(2,'a;b');"}},
+	}
+
+	for _, example := range examples {
+		statements, ok := dbutil.SplitStatementsWith(example.in, opts)
+		require.True(t, ok, example.in)
+		require.Equal(t, example.expected, statements, example.in)
+
+		// the statement reader splits them the same way
+		reader := dbutil.NewStatementReader(strings.NewReader(example.in), opts)
+		read := []string{}
+		for {
+			statement, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, example.in)
+			read = append(read, statement)
+		}
+		require.Equal(t, example.expected, read, example.in)
+	}
+}
+
+func TestStatementReader(t *testing.T) {
+	examples := []struct {
+		in       string
+		expected []string
+	}{
+		{"", []string{}},
+		{"-- comment\n\n", []string{}},
+		{"create table users (id int);\ninsert into users\nvalues (1);\n",
+			[]string{"create table users (id int);", "insert into users\nvalues (1);"}},
+		{"insert into users values (1); insert into users values (2);\nselect 3",
+			[]string{"insert into users values (1);", "insert into users values (2);", "select 3"}},
+		{"insert into t values ('a;\nb');\nselect 1 -- trailing;\nfrom t;\n",
+			[]string{"insert into t values ('a;\nb');", "select 1 -- trailing;\nfrom t;"}},
+		{"create function f() returns int as $$\n  select 1;\n$$ language sql;\n/* a;\nb */ select 2;\n",
+			[]string{"create function f() returns int as $$\n  select 1;\n$$ language sql;", "select 2;"}},
+	}
+
+	for _, example := range examples {
+		reader := dbutil.NewStatementReader(strings.NewReader(example.in), dbutil.SplitOptions{})
+		statements := []string{}
+		for {
+			statement, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, example.in)
+			statements = append(statements, statement)
+		}
+		require.Equal(t, example.expected, statements, example.in)
+	}
+
+	// statements which can't be split are reported, rather than read to the end of the input
+	unsplittable := []struct {
+		in    string
+		error string
+	}{
+		{"select 1;\ncreate trigger t begin\n  select 2;\nend;\nselect 3;\n",
+			"statement cannot be split safely: compound BEGIN ... END blocks are not supported: create trigger t"},
+		{"select 1;\nselect 'abc;\n", "statement cannot be split safely: unterminated quote or comment: select 'abc;"},
+	}
+
+	for _, example := range unsplittable {
+		reader := dbutil.NewStatementReader(strings.NewReader(example.in), dbutil.SplitOptions{})
+		statement, err := reader.Next()
+		require.NoError(t, err, example.in)
+		require.Equal(t, "select 1;", statement)
+
+		_, err = reader.Next()
+		require.ErrorIs(t, err, dbutil.ErrUnsplittableStatement)
+		require.EqualError(t, err, example.error)
+	}
+}
+
 // connect to in-memory sqlite database for testing
 const sqliteMemoryDB = "file:dbutil.sqlite3?mode=memory&cache=shared"
 
@@ -48,6 +183,15 @@ func TestQueryColumn(t *testing.T) {
 	require.Equal(t, []string{"foo_hi", "foo_there"}, val)
 }
 
+func TestQueryMap(t *testing.T) {
+	db, err := sql.Open("sqlite3", sqliteMemoryDB)
+	require.NoError(t, err)
+
+	val, err := dbutil.QueryMap(db, "select 'a', ? union select 'b', ?", "hi", "there")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "hi", "b": "there"}, val)
+}
+
 func TestQueryValue(t *testing.T) {
 	db, err := sql.Open("sqlite3", sqliteMemoryDB)
 	require.NoError(t, err)
@@ -369,6 +369,11 @@ func (drv *Driver) Ping() error {
 	return err
 }
 
+// BackslashEscapes reports that ClickHouse strings use backslash escapes, e.g. 'it\'s'
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
 func (drv *Driver) quotedMigrationsTableName() string {
 	return drv.quoteIdentifier(drv.migrationsTableName)
 }
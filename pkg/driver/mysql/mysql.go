@@ -3,16 +3,22 @@ package mysql
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/url"
+	"os"
+	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
-	_ "github.com/go-sql-driver/mysql" // database/sql driver
+	mysqldriver "github.com/go-sql-driver/mysql" // database/sql driver
 )
 
 func init() {
@@ -24,6 +30,7 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	includeSchemas      []string
 }
 
 // NewDriver initializes the driver
@@ -32,6 +39,7 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		includeSchemas:      config.IncludeSchemas,
 	}
 }
 
@@ -151,12 +159,81 @@ func (drv *Driver) mysqldumpArgs() []string {
 		args = append(args, "--password="+password)
 	}
 
+	// the tables which record checksums and the lock holder are not part of the schema
+	name := dbutil.DatabaseName(drv.databaseURL)
+	for _, table := range drv.internalTableNames() {
+		args = append(args, "--ignore-table="+name+"."+table)
+	}
+
 	// add database name
-	args = append(args, dbutil.DatabaseName(drv.databaseURL))
+	if len(drv.includeSchemas) == 0 {
+		return append(args, name)
+	}
+
+	// dump additional databases, ending with our own so that the migrations are
+	// inserted into it when the schema is loaded
+	args = append(args, "--databases")
+	for _, database := range drv.includeSchemas {
+		if database != name {
+			args = append(args, database)
+		}
+	}
+	args = append(args, name)
 
 	return args
 }
 
+func (drv *Driver) consoleArgs() (args []string, env []string) {
+	query := drv.databaseURL.Query()
+
+	if socket := query.Get("socket"); socket != "" {
+		args = append(args, "--socket="+socket)
+	} else {
+		if hostname := drv.databaseURL.Hostname(); hostname != "" {
+			args = append(args, "--host="+hostname)
+		}
+		if port := drv.databaseURL.Port(); port != "" {
+			args = append(args, "--port="+port)
+		}
+	}
+
+	// map go-sql-driver tls parameter to client ssl mode
+	switch query.Get("tls") {
+	case "true":
+		args = append(args, "--ssl-mode=VERIFY_IDENTITY")
+	case "skip-verify":
+		args = append(args, "--ssl-mode=REQUIRED")
+	case "preferred":
+		args = append(args, "--ssl-mode=PREFERRED")
+	case "false":
+		args = append(args, "--ssl-mode=DISABLED")
+	}
+
+	if username := drv.databaseURL.User.Username(); username != "" {
+		args = append(args, "--user="+username)
+	}
+
+	// pass password via environment so it is not visible in the process list
+	if password, set := drv.databaseURL.User.Password(); set {
+		env = append(env, "MYSQL_PWD="+password)
+	}
+
+	if name := dbutil.DatabaseName(drv.databaseURL); name != "" {
+		args = append(args, name)
+	}
+
+	return args, env
+}
+
+// ConsoleCommand returns a mysql command connected to the database
+func (drv *Driver) ConsoleCommand() (*exec.Cmd, error) {
+	args, env := drv.consoleArgs()
+	cmd := exec.Command("mysql", args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	return cmd, nil
+}
+
 func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	migrationsTable := drv.quotedMigrationsTableName()
 
@@ -184,9 +261,113 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ListDatabases returns the databases on the server, excluding system databases
+func (drv *Driver) ListDatabases() ([]string, error) {
+	db, err := drv.openRootDB()
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryColumn(db, "select schema_name from information_schema.schemata "+
+		"where schema_name not in ('information_schema', 'mysql', 'performance_schema', 'sys') "+
+		"order by schema_name")
+}
+
+// Tables returns the tables in the current database
+func (drv *Driver) Tables(db *sql.DB) ([]string, error) {
+	return dbutil.QueryColumn(db, "select table_name "+
+		"from information_schema.tables "+
+		"where table_type = 'BASE TABLE' and table_schema = database() "+
+		"order by table_name")
+}
+
+// DescribeTable returns the columns, indexes, foreign keys and comments of table
+func (drv *Driver) DescribeTable(db *sql.DB, table string) (dbmate.TableDescription, error) {
+	description := dbmate.TableDescription{Name: table}
+
+	err := db.QueryRow("select table_comment from information_schema.tables "+
+		"where table_schema = database() and table_name = ?", table).Scan(&description.Comment)
+	if err != nil {
+		return description, err
+	}
+
+	rows, err := db.Query("select column_name, column_type, is_nullable = 'YES', "+
+		"coalesce(column_default, ''), column_comment "+
+		"from information_schema.columns "+
+		"where table_schema = database() and table_name = ? "+
+		"order by ordinal_position", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var column dbmate.ColumnDescription
+		if err := rows.Scan(&column.Name, &column.Type, &column.Nullable, &column.Default, &column.Comment); err != nil {
+			return description, err
+		}
+		description.Columns = append(description.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select index_name, non_unique = 0, "+
+		"group_concat(column_name order by seq_in_index separator ',') "+
+		"from information_schema.statistics "+
+		"where table_schema = database() and table_name = ? "+
+		"group by index_name, non_unique "+
+		"order by index_name", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var index dbmate.IndexDescription
+		var columns string
+		if err := rows.Scan(&index.Name, &index.Unique, &columns); err != nil {
+			return description, err
+		}
+		index.Columns = strings.Split(columns, ",")
+		description.Indexes = append(description.Indexes, index)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select constraint_name, referenced_table_name, "+
+		"group_concat(column_name order by ordinal_position separator ','), "+
+		"group_concat(referenced_column_name order by ordinal_position separator ',') "+
+		"from information_schema.key_column_usage "+
+		"where table_schema = database() and table_name = ? and referenced_table_name is not null "+
+		"group by constraint_name, referenced_table_name "+
+		"order by constraint_name", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var fk dbmate.ForeignKeyDescription
+		var columns, refColumns string
+		if err := rows.Scan(&fk.Name, &fk.ReferencedTable, &columns, &refColumns); err != nil {
+			return description, err
+		}
+		fk.Columns = strings.Split(columns, ",")
+		fk.ReferencedColumns = strings.Split(refColumns, ",")
+		description.ForeignKeys = append(description.ForeignKeys, fk)
+	}
+
+	return description, rows.Err()
+}
+
+// DumpCommand returns the command used to dump the schema
+func (drv *Driver) DumpCommand() string {
+	return "mysqldump"
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
-	schema, err := dbutil.RunCommand("mysqldump", drv.mysqldumpArgs()...)
+	schema, err := dbutil.RunCommand(drv.DumpCommand(), drv.mysqldumpArgs()...)
 	if err != nil {
 		return nil, err
 	}
@@ -248,8 +429,33 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	_, err := db.Exec(fmt.Sprintf(
 		"create table if not exists %s (version varchar(128) primary key)",
 		drv.quotedMigrationsTableName()))
+	if err != nil {
+		return err
+	}
 
-	return err
+	return drv.indexMigrationsTable(db)
+}
+
+// indexMigrationsTable adds a unique index on the version column of a migrations table
+// which was created without a primary key (e.g. by another tool), so that selecting
+// migrations stays fast as versions accumulate. If the table contains duplicate versions
+// the index cannot be added, which is reported as a warning rather than an error.
+func (drv *Driver) indexMigrationsTable(db *sql.DB) error {
+	indexed, err := dbutil.QueryValue(db, `select count(*) from information_schema.statistics
+		where table_schema = database() and table_name = ? and column_name = 'version' and seq_in_index = 1`, drv.migrationsTableName)
+	if err != nil || indexed != "0" {
+		return err
+	}
+
+	table := drv.quotedMigrationsTableName()
+	fmt.Fprintf(drv.log, "Indexing: %s\n", table)
+	_, err = db.Exec(fmt.Sprintf("create unique index %s on %s (version)",
+		drv.quoteIdentifier(drv.migrationsTableName+"_version_idx"), table))
+	if err != nil {
+		fmt.Fprintf(drv.log, "Warning: could not index the version column of %s: %s\n", table, err)
+	}
+
+	return nil
 }
 
 // SelectMigrations returns a list of applied migrations
@@ -301,6 +507,203 @@ func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// CreateChecksumsTable creates the table which records the checksum of each migration
+func (drv *Driver) CreateChecksumsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version varchar(128) primary key, checksum varchar(64) not null)",
+		drv.quotedChecksumsTableName()))
+
+	return err
+}
+
+// SelectChecksums returns the recorded checksum of each migration version
+func (drv *Driver) SelectChecksums(db *sql.DB) (map[string]string, error) {
+	return dbutil.QueryMap(db, fmt.Sprintf("select version, checksum from %s", drv.quotedChecksumsTableName()))
+}
+
+// InsertChecksum records the checksum of a migration version
+func (drv *Driver) InsertChecksum(db dbutil.Transaction, version, checksum string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, checksum) values (?, ?)", drv.quotedChecksumsTableName()),
+		version, checksum)
+
+	return err
+}
+
+// DeleteChecksum removes the checksum of a migration version
+func (drv *Driver) DeleteChecksum(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("delete from %s where version = ?", drv.quotedChecksumsTableName()),
+		version)
+
+	return err
+}
+
+// SwitchSchema changes the current database to the specified database, and returns a
+// function which restores the previous database
+func (drv *Driver) SwitchSchema(db dbutil.Transaction, schema string) (func() error, error) {
+	current, err := dbutil.QueryValue(db, "select database()")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("use " + drv.quoteIdentifier(schema)); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if current == "" {
+			// no database was previously selected
+			return nil
+		}
+
+		_, err := db.Exec("use " + drv.quoteIdentifier(current))
+		return err
+	}, nil
+}
+
+// Lock acquires a named lock, blocking until no other session holds the lock for this
+// database and migrations table
+func (drv *Driver) Lock(db dbutil.Transaction) error {
+	result, err := dbutil.QueryValue(db, "select get_lock(?, -1)", drv.lockName())
+	if err != nil {
+		return err
+	}
+	if result != "1" {
+		return fmt.Errorf("unable to acquire lock %s", drv.lockName())
+	}
+
+	return nil
+}
+
+// Unlock releases the named lock acquired by Lock
+func (drv *Driver) Unlock(db dbutil.Transaction) error {
+	_, err := db.Exec("select release_lock(?)", drv.lockName())
+	return err
+}
+
+// TryLock acquires the named lock if no other session holds it
+func (drv *Driver) TryLock(db dbutil.Transaction) (bool, error) {
+	result, err := dbutil.QueryValue(db, "select get_lock(?, 0)", drv.lockName())
+	return result == "1", err
+}
+
+// SetLockHolder records the holder of the named lock held by the session db
+func (drv *Driver) SetLockHolder(db dbutil.Transaction, holder dbmate.LockHolder) error {
+	lockTable := drv.quotedLockTableName()
+	if _, err := db.Exec("create table if not exists " + lockTable + " (session varchar(64) primary key, " +
+		"host varchar(255) not null, pid integer not null, started_at datetime not null)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("delete from " + lockTable); err != nil {
+		return err
+	}
+	_, err := db.Exec("insert into "+lockTable+" (session, host, pid, started_at) "+
+		"values (connection_id(), ?, ?, ?)", holder.Host, holder.PID, holder.StartedAt.UTC())
+
+	return err
+}
+
+// LockHolder returns the holder of the named lock, or nil if it is not held
+func (drv *Driver) LockHolder(db *sql.DB) (*dbmate.LockHolder, error) {
+	session, err := dbutil.QueryValue(db, "select is_used_lock(?)", drv.lockName())
+	if err != nil || session == "" {
+		return nil, err
+	}
+
+	holder := &dbmate.LockHolder{Session: session}
+	var startedAt string
+	err = db.QueryRow("select host, pid, date_format(started_at, '%Y-%m-%d %H:%i:%s') from "+
+		drv.quotedLockTableName()+" where session = ?", session).Scan(&holder.Host, &holder.PID, &startedAt)
+	var mysqlErr *mysqldriver.MySQLError
+	if err == sql.ErrNoRows || (errors.As(err, &mysqlErr) && mysqlErr.Number == 1146) {
+		// the holder was not recorded, or the table does not exist
+		return holder, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	holder.StartedAt, err = time.Parse("2006-01-02 15:04:05", startedAt)
+
+	return holder, err
+}
+
+// ForceUnlock kills the connection with the given ID, which releases the named lock it
+// holds
+func (drv *Driver) ForceUnlock(db *sql.DB, session string) error {
+	if _, err := strconv.ParseUint(session, 10, 64); err != nil {
+		return fmt.Errorf("invalid connection id: %s", session)
+	}
+
+	_, err := db.Exec("kill " + session)
+	return err
+}
+
+var (
+	implicitCommitRegexp = regexp.MustCompile(`(?i)^(alter|create|drop|rename|truncate|grant|revoke|lock\s+tables)\b`)
+	temporaryTableRegexp = regexp.MustCompile(`(?i)^(create|drop)\s+temporary\b`)
+)
+
+// CommitsImplicitly reports whether statement is one which MySQL executes in its own
+// transaction, committing the current transaction first, such as DDL. Creating and
+// dropping temporary tables does not commit.
+func (drv *Driver) CommitsImplicitly(statement string) bool {
+	statement = strings.TrimSpace(statement)
+	return implicitCommitRegexp.MatchString(statement) && !temporaryTableRegexp.MatchString(statement)
+}
+
+// BackslashEscapes reports that MySQL strings use backslash escapes, e.g. 'it\'s'
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// SessionID returns the connection ID of the session
+func (drv *Driver) SessionID(db dbutil.Transaction) (string, error) {
+	return dbutil.QueryValue(db, "select connection_id()")
+}
+
+// CancelQuery kills the statement running on the connection with the given ID, leaving
+// the connection open
+func (drv *Driver) CancelQuery(db *sql.DB, id string) error {
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return fmt.Errorf("invalid connection id: %s", id)
+	}
+
+	_, err := db.Exec("kill query " + id)
+	return err
+}
+
+// CheckSyntax checks statement by preparing it on the server, which parses it and
+// resolves the names used by DML statements without executing it. Statements which
+// MySQL cannot prepare are not checked.
+func (drv *Driver) CheckSyntax(db dbutil.Transaction, statement string) error {
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	if _, err := db.Exec("set @dbmate_check = ?", statement); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("prepare dbmate_check from @dbmate_check")
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1295 {
+		// ER_UNSUPPORTED_PS: the statement cannot be prepared
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("deallocate prepare dbmate_check")
+	return err
+}
+
+// lockName returns the lock name for the database and migrations table. Lock names are
+// global to the server and limited to 64 characters, so the names are hashed.
+func (drv *Driver) lockName() string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(dbutil.DatabaseName(drv.databaseURL) + "." + drv.migrationsTableName))
+	return fmt.Sprintf("dbmate:%x", h.Sum64())
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv *Driver) Ping() error {
@@ -316,3 +719,17 @@ func (drv *Driver) Ping() error {
 func (drv *Driver) quotedMigrationsTableName() string {
 	return drv.quoteIdentifier(drv.migrationsTableName)
 }
+
+func (drv *Driver) quotedChecksumsTableName() string {
+	return drv.quoteIdentifier(drv.migrationsTableName + "_checksums")
+}
+
+func (drv *Driver) quotedLockTableName() string {
+	return drv.quoteIdentifier(drv.migrationsTableName + "_lock")
+}
+
+// internalTableNames returns the unquoted names of the tables which dbmate creates
+// alongside the migrations table, and which are left out of schema dumps
+func (drv *Driver) internalTableNames() []string {
+	return []string{drv.migrationsTableName + "_checksums", drv.migrationsTableName + "_lock"}
+}
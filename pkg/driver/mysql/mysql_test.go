@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
@@ -155,6 +157,8 @@ func TestMySQLDumpArgs(t *testing.T) {
 		"--skip-dump-date",
 		"--skip-add-drop-table",
 		"--host=bob",
+		"--ignore-table=mydb.schema_migrations_checksums",
+		"--ignore-table=mydb.schema_migrations_lock",
 		"mydb"}, drv.mysqldumpArgs())
 
 	drv.databaseURL = dbutil.MustParseURL("mysql://alice:pw@bob:5678/mydb")
@@ -167,6 +171,8 @@ func TestMySQLDumpArgs(t *testing.T) {
 		"--port=5678",
 		"--user=alice",
 		"--password=pw",
+		"--ignore-table=mydb.schema_migrations_checksums",
+		"--ignore-table=mydb.schema_migrations_lock",
 		"mydb"}, drv.mysqldumpArgs())
 
 	drv.databaseURL = dbutil.MustParseURL("mysql://alice:pw@bob:5678/mydb?socket=/var/run/mysqld/mysqld.sock")
@@ -178,7 +184,48 @@ func TestMySQLDumpArgs(t *testing.T) {
 		"--socket=/var/run/mysqld/mysqld.sock",
 		"--user=alice",
 		"--password=pw",
+		"--ignore-table=mydb.schema_migrations_checksums",
+		"--ignore-table=mydb.schema_migrations_lock",
 		"mydb"}, drv.mysqldumpArgs())
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://bob/mydb")
+	drv.includeSchemas = []string{"mydb", "shared"}
+	require.Equal(t, []string{"--opt",
+		"--routines",
+		"--no-data",
+		"--skip-dump-date",
+		"--skip-add-drop-table",
+		"--host=bob",
+		"--ignore-table=mydb.schema_migrations_checksums",
+		"--ignore-table=mydb.schema_migrations_lock",
+		"--databases",
+		"shared",
+		"mydb"}, drv.mysqldumpArgs())
+}
+
+func TestMySQLConsoleArgs(t *testing.T) {
+	drv := testMySQLDriver(t)
+	drv.databaseURL = dbutil.MustParseURL("mysql://bob/mydb")
+
+	args, env := drv.consoleArgs()
+	require.Equal(t, []string{"--host=bob", "mydb"}, args)
+	require.Empty(t, env)
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://alice:pw@bob:5678/mydb?tls=true")
+	args, env = drv.consoleArgs()
+	require.Equal(t, []string{"--host=bob",
+		"--port=5678",
+		"--ssl-mode=VERIFY_IDENTITY",
+		"--user=alice",
+		"mydb"}, args)
+	require.Equal(t, []string{"MYSQL_PWD=pw"}, env)
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://alice:pw@bob:5678/mydb?socket=/var/run/mysqld/mysqld.sock")
+	args, env = drv.consoleArgs()
+	require.Equal(t, []string{"--socket=/var/run/mysqld/mysqld.sock",
+		"--user=alice",
+		"mydb"}, args)
+	require.Equal(t, []string{"MYSQL_PWD=pw"}, env)
 }
 
 func TestMySQLDumpSchema(t *testing.T) {
@@ -197,10 +244,15 @@ func TestMySQLDumpSchema(t *testing.T) {
 	err = drv.InsertMigration(db, "abc2")
 	require.NoError(t, err)
 
+	// the checksums table is left out of the dump
+	err = drv.CreateChecksumsTable(db)
+	require.NoError(t, err)
+
 	// DumpSchema should return schema
 	schema, err := drv.DumpSchema(db)
 	require.NoError(t, err)
 	require.Contains(t, string(schema), "CREATE TABLE `test_migrations`")
+	require.NotContains(t, string(schema), "test_migrations_checksums")
 	require.Contains(t, string(schema), "\n-- Dump completed\n\n"+
 		"--\n"+
 		"-- Dbmate schema migrations\n"+
@@ -305,6 +357,47 @@ func TestMySQLCreateMigrationsTable(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestMySQLCreateMigrationsTableLegacy(t *testing.T) {
+	drv := testMySQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+	log := &strings.Builder{}
+	drv.log = log
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+
+	// a table created without a primary key is given a unique index on its version column
+	_, err := db.Exec("create table test_migrations (version varchar(128))")
+	require.NoError(t, err)
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Indexing: ")
+
+	var indexes int
+	err = db.QueryRow(`select count(*) from information_schema.statistics
+		where table_schema = database() and table_name = 'test_migrations'`).Scan(&indexes)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexes)
+
+	// the index is only added once
+	log.Reset()
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Empty(t, log.String())
+
+	// duplicate versions prevent the index from being added, which is only a warning
+	_, err = db.Exec("drop table test_migrations")
+	require.NoError(t, err)
+	_, err = db.Exec("create table test_migrations (version varchar(128))")
+	require.NoError(t, err)
+	_, err = db.Exec("insert into test_migrations (version) values ('abc1'), ('abc1')")
+	require.NoError(t, err)
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Warning: could not index the version column of ")
+	require.Contains(t, log.String(), "Duplicate entry")
+}
+
 func TestMySQLSelectMigrations(t *testing.T) {
 	drv := testMySQLDriver(t)
 	drv.migrationsTableName = "test_migrations"
@@ -381,6 +474,186 @@ func TestMySQLDeleteMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestMySQLLock(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+
+	// each transaction holds its own session
+	tx1, err := db.Begin()
+	require.NoError(t, err)
+	defer func() { _ = tx1.Rollback() }()
+	tx2, err := db.Begin()
+	require.NoError(t, err)
+	defer func() { _ = tx2.Rollback() }()
+
+	err = drv.Lock(tx1)
+	require.NoError(t, err)
+
+	free, err := dbutil.QueryValue(tx2, "select is_free_lock(?)", drv.lockName())
+	require.NoError(t, err)
+	require.Equal(t, "0", free)
+
+	err = drv.Unlock(tx1)
+	require.NoError(t, err)
+
+	free, err = dbutil.QueryValue(tx2, "select is_free_lock(?)", drv.lockName())
+	require.NoError(t, err)
+	require.Equal(t, "1", free)
+}
+
+func TestMySQLCheckSyntax(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+	_, err := db.Exec("create table users (id integer)")
+	require.NoError(t, err)
+
+	// a single connection, so that the user variable and prepared statement share a session
+	db.SetMaxOpenConns(1)
+
+	require.NoError(t, drv.CheckSyntax(db, "drop table users;"))
+	require.NoError(t, drv.CheckSyntax(db, "delete from users where id = 1"))
+
+	err = drv.CheckSyntax(db, "drop tabel users;")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "You have an error in your SQL syntax")
+
+	err = drv.CheckSyntax(db, "delete from posts")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't exist")
+
+	// the statements were not executed
+	_, err = db.Exec("insert into users (id) values (1)")
+	require.NoError(t, err)
+}
+
+func TestMySQLLockHolder(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+
+	// each pool has a single connection, so holds its own session
+	session1, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(session1)
+	session1.SetMaxOpenConns(1)
+	session2, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(session2)
+	session2.SetMaxOpenConns(1)
+
+	holder, err := drv.LockHolder(db)
+	require.NoError(t, err)
+	require.Nil(t, holder)
+
+	acquired, err := drv.TryLock(session1)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	startedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = drv.SetLockHolder(session1, dbmate.LockHolder{Host: "web-1", PID: 1234, StartedAt: startedAt})
+	require.NoError(t, err)
+
+	acquired, err = drv.TryLock(session2)
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	holder, err = drv.LockHolder(db)
+	require.NoError(t, err)
+	require.NotNil(t, holder)
+	require.Equal(t, "web-1", holder.Host)
+	require.Equal(t, 1234, holder.PID)
+	require.True(t, startedAt.Equal(holder.StartedAt))
+
+	// the lock table is left out of schema dumps
+	require.NoError(t, drv.CreateMigrationsTable(db))
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "schema_migrations")
+	require.NotContains(t, string(schema), "schema_migrations_lock")
+
+	require.NoError(t, drv.ForceUnlock(db, holder.Session))
+	require.Eventually(t, func() bool {
+		acquired, err := drv.TryLock(session2)
+		return err == nil && acquired
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestMySQLCommitsImplicitly(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	require.True(t, drv.CommitsImplicitly("create table users (id int);"))
+	require.True(t, drv.CommitsImplicitly("ALTER TABLE users ADD COLUMN name text;"))
+	require.True(t, drv.CommitsImplicitly("drop index users_name on users;"))
+	require.True(t, drv.CommitsImplicitly("rename table users to people;"))
+	require.True(t, drv.CommitsImplicitly("truncate table users;"))
+	require.True(t, drv.CommitsImplicitly("lock tables users write;"))
+	require.False(t, drv.CommitsImplicitly("create temporary table tmp (id int);"))
+	require.False(t, drv.CommitsImplicitly("DROP TEMPORARY TABLE tmp;"))
+	require.False(t, drv.CommitsImplicitly("insert into users (id) values (1);"))
+	require.False(t, drv.CommitsImplicitly("update users set created = now();"))
+}
+
+func TestMySQLCancelQuery(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := drv.SessionID(tx)
+	require.NoError(t, err)
+
+	done := make(chan string)
+	go func() {
+		// sleep returns 1 when it is interrupted
+		result, _ := dbutil.QueryValue(tx, "select sleep(30)")
+		done <- result
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, drv.CancelQuery(db, id))
+	require.Equal(t, "1", <-done)
+
+	require.Error(t, drv.CancelQuery(db, "1; drop database x"))
+}
+
+func TestMySQLSwitchSchema(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec("create database if not exists dbmate_test_analytics")
+	require.NoError(t, err)
+	defer func() { _, _ = db.Exec("drop database if exists dbmate_test_analytics") }()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer func() { _ = tx.Rollback() }()
+
+	restore, err := drv.SwitchSchema(tx, "dbmate_test_analytics")
+	require.NoError(t, err)
+
+	name, err := dbutil.QueryValue(tx, "select database()")
+	require.NoError(t, err)
+	require.Equal(t, "dbmate_test_analytics", name)
+
+	// restore previous database
+	err = restore()
+	require.NoError(t, err)
+
+	name, err = dbutil.QueryValue(tx, "select database()")
+	require.NoError(t, err)
+	require.Equal(t, dbutil.DatabaseName(drv.databaseURL), name)
+}
+
 func TestMySQLPing(t *testing.T) {
 	drv := testMySQLDriver(t)
 
@@ -3,11 +3,16 @@ package postgres
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/url"
+	"os"
+	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
@@ -25,6 +30,8 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	schemaFormat        string
+	includeSchemas      []string
 }
 
 // NewDriver initializes the driver
@@ -33,6 +40,8 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		schemaFormat:        config.SchemaFormat,
+		includeSchemas:      config.IncludeSchemas,
 	}
 }
 
@@ -81,19 +90,21 @@ func connectionString(u *url.URL) string {
 	return out.String()
 }
 
-func connectionArgsForDump(u *url.URL) []string {
+func connectionArgsForDump(u *url.URL, includeSchemas []string) []string {
 	u = dbutil.MustParseURL(connectionString(u))
 
-	// find schemas from search_path
+	// find schemas from search_path, followed by any additional schemas
 	query := u.Query()
-	schemas := strings.Split(query.Get("search_path"), ",")
+	schemas := append(strings.Split(query.Get("search_path"), ","), includeSchemas...)
 	query.Del("search_path")
 	u.RawQuery = query.Encode()
 
 	out := []string{}
+	seen := map[string]bool{}
 	for _, schema := range schemas {
 		schema = strings.TrimSpace(schema)
-		if schema != "" {
+		if schema != "" && !seen[schema] {
+			seen[schema] = true
 			out = append(out, "--schema", schema)
 		}
 	}
@@ -102,6 +113,35 @@ func connectionArgsForDump(u *url.URL) []string {
 	return out
 }
 
+func connectionArgsForConsole(u *url.URL) (args []string, env []string) {
+	u = dbutil.MustParseURL(connectionString(u))
+
+	// pass password via environment so it is not visible in the process list
+	if password, set := u.User.Password(); set {
+		env = append(env, "PGPASSWORD="+password)
+		u.User = url.User(u.User.Username())
+	}
+
+	// search_path is not a libpq connection parameter
+	query := u.Query()
+	if searchPath := query.Get("search_path"); searchPath != "" {
+		env = append(env, "PGOPTIONS=-c search_path="+strings.ReplaceAll(searchPath, " ", ""))
+		query.Del("search_path")
+		u.RawQuery = query.Encode()
+	}
+
+	return []string{u.String()}, env
+}
+
+// ConsoleCommand returns a psql command connected to the database
+func (drv *Driver) ConsoleCommand() (*exec.Cmd, error) {
+	args, env := connectionArgsForConsole(drv.databaseURL)
+	cmd := exec.Command("psql", args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	return cmd, nil
+}
+
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
 	return sql.Open("postgres", connectionString(drv.databaseURL))
@@ -137,6 +177,23 @@ func (drv *Driver) CreateDatabase() error {
 	return err
 }
 
+// CreateDatabaseFromTemplate creates the specified database as a copy of template
+func (drv *Driver) CreateDatabaseFromTemplate(template string) error {
+	name := dbutil.DatabaseName(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Creating: %s (from template %s)\n", name, template)
+
+	db, err := drv.openPostgresDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("create database %s template %s",
+		pq.QuoteIdentifier(name), pq.QuoteIdentifier(template)))
+
+	return err
+}
+
 // DropDatabase drops the specified database (if it exists)
 func (drv *Driver) DropDatabase() error {
 	name := dbutil.DatabaseName(drv.databaseURL)
@@ -180,12 +237,129 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ListDatabases returns the databases on the server which accept connections, excluding
+// templates
+func (drv *Driver) ListDatabases() ([]string, error) {
+	db, err := drv.openPostgresDB()
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryColumn(db, "select datname from pg_database "+
+		"where not datistemplate and datallowconn order by datname")
+}
+
+// Tables returns the tables in the schemas on the search_path, qualified with their schema
+func (drv *Driver) Tables(db *sql.DB) ([]string, error) {
+	return dbutil.QueryColumn(db, "select quote_ident(table_schema) || '.' || quote_ident(table_name) "+
+		"from information_schema.tables "+
+		"where table_type = 'BASE TABLE' and table_schema = any(current_schemas(false)) "+
+		"order by table_schema, table_name")
+}
+
+// DescribeTable returns the columns, indexes, foreign keys and comments of table
+func (drv *Driver) DescribeTable(db *sql.DB, table string) (dbmate.TableDescription, error) {
+	description := dbmate.TableDescription{Name: table}
+
+	err := db.QueryRow("select coalesce(obj_description($1::regclass, 'pg_class'), '')", table).
+		Scan(&description.Comment)
+	if err != nil {
+		return description, err
+	}
+
+	rows, err := db.Query("select a.attname, format_type(a.atttypid, a.atttypmod), not a.attnotnull, "+
+		"coalesce(pg_get_expr(d.adbin, d.adrelid), ''), coalesce(col_description(a.attrelid, a.attnum), '') "+
+		"from pg_attribute a "+
+		"left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum "+
+		"where a.attrelid = $1::regclass and a.attnum > 0 and not a.attisdropped "+
+		"order by a.attnum", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var column dbmate.ColumnDescription
+		if err := rows.Scan(&column.Name, &column.Type, &column.Nullable, &column.Default, &column.Comment); err != nil {
+			return description, err
+		}
+		description.Columns = append(description.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select i.relname, x.indisunique, "+
+		"array_to_string(array(select pg_get_indexdef(x.indexrelid, k + 1, true) "+
+		"from generate_subscripts(x.indkey, 1) as k order by k), ',') "+
+		"from pg_index x join pg_class i on i.oid = x.indexrelid "+
+		"where x.indrelid = $1::regclass "+
+		"order by i.relname", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var index dbmate.IndexDescription
+		var columns string
+		if err := rows.Scan(&index.Name, &index.Unique, &columns); err != nil {
+			return description, err
+		}
+		index.Columns = strings.Split(columns, ",")
+		description.Indexes = append(description.Indexes, index)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select c.conname, c.confrelid::regclass::text, "+
+		"(select string_agg(a.attname, ',' order by k.n) from unnest(c.conkey) with ordinality k(attnum, n) "+
+		"join pg_attribute a on a.attrelid = c.conrelid and a.attnum = k.attnum), "+
+		"(select string_agg(a.attname, ',' order by k.n) from unnest(c.confkey) with ordinality k(attnum, n) "+
+		"join pg_attribute a on a.attrelid = c.confrelid and a.attnum = k.attnum) "+
+		"from pg_constraint c "+
+		"where c.conrelid = $1::regclass and c.contype = 'f' "+
+		"order by c.conname", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var fk dbmate.ForeignKeyDescription
+		var columns, refColumns string
+		if err := rows.Scan(&fk.Name, &fk.ReferencedTable, &columns, &refColumns); err != nil {
+			return description, err
+		}
+		fk.Columns = strings.Split(columns, ",")
+		fk.ReferencedColumns = strings.Split(refColumns, ",")
+		description.ForeignKeys = append(description.ForeignKeys, fk)
+	}
+
+	return description, rows.Err()
+}
+
+// DumpCommand returns the command used to dump the schema
+func (drv *Driver) DumpCommand() string {
+	return "pg_dump"
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	// load schema
-	args := append([]string{"--format=plain", "--encoding=UTF8", "--schema-only",
-		"--no-privileges", "--no-owner"}, connectionArgsForDump(drv.databaseURL)...)
-	schema, err := dbutil.RunCommand("pg_dump", args...)
+	args := []string{"--format=plain", "--encoding=UTF8", "--schema-only"}
+	if drv.schemaFormat != dbmate.SchemaFormatFull {
+		args = append(args, "--no-privileges", "--no-owner")
+	}
+	// the tables which record checksums and the lock holder are not part of the schema
+	internalTables, err := drv.quotedInternalTableNames(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range internalTables {
+		args = append(args, "--exclude-table="+table)
+	}
+	args = append(args, connectionArgsForDump(drv.databaseURL, drv.includeSchemas)...)
+	schema, err := dbutil.RunCommand(drv.DumpCommand(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -254,7 +428,7 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	_, err = db.Exec(createTableStmt)
 	if err == nil {
 		// table exists or created successfully
-		return nil
+		return drv.indexMigrationsTable(db, schema+"."+migrationsTable)
 	}
 
 	// catch 'schema does not exist' error
@@ -277,6 +451,53 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	return err
 }
 
+// CheckCreateMigrationsTable returns an error if the session is not permitted to create
+// the migrations table, using privilege functions rather than creating it
+func (drv *Driver) CheckCreateMigrationsTable(db *sql.DB) error {
+	schema, _, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	// CreateMigrationsTable also creates the schema if it does not exist, which requires
+	// the create privilege on the database instead
+	permitted, err := dbutil.QueryValue(db, `select case
+			when exists (select 1 from pg_namespace where nspname = $1) then has_schema_privilege($1, 'CREATE')
+			else has_database_privilege(current_database(), 'CREATE')
+		end`, schema)
+	if err != nil {
+		return err
+	}
+	if permitted != "true" {
+		return fmt.Errorf("permission denied to create tables in schema %s", schema)
+	}
+
+	return nil
+}
+
+// indexMigrationsTable adds a unique index on the version column of a migrations table
+// which was created without a primary key (e.g. by another tool), so that selecting
+// migrations stays fast as versions accumulate. If the table contains duplicate versions
+// the index cannot be added, which is reported as a warning rather than an error.
+func (drv *Driver) indexMigrationsTable(db *sql.DB, table string) error {
+	indexed, err := dbutil.QueryValue(db, `select exists (
+			select 1 from pg_index i
+			join pg_attribute a on a.attrelid = i.indrelid and a.attnum = i.indkey[0]
+			where i.indrelid = $1::regclass and a.attname = 'version'
+		)`, table)
+	if err != nil || indexed == "true" {
+		return err
+	}
+
+	fmt.Fprintf(drv.log, "Indexing: %s\n", table)
+	_, err = db.Exec(fmt.Sprintf("create unique index on %s (version)", table))
+	if err != nil {
+		fmt.Fprintf(drv.log, "Warning: could not index the version column of %s: %s\n", table, err)
+	}
+
+	return nil
+}
+
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
@@ -337,6 +558,207 @@ func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// CreateChecksumsTable creates the table which records the checksum of each migration
+func (drv *Driver) CreateChecksumsTable(db *sql.DB) error {
+	checksumsTable, err := drv.quotedChecksumsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version varchar(128) primary key, checksum varchar(64) not null)",
+		checksumsTable))
+
+	return err
+}
+
+// SelectChecksums returns the recorded checksum of each migration version
+func (drv *Driver) SelectChecksums(db *sql.DB) (map[string]string, error) {
+	checksumsTable, err := drv.quotedChecksumsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbutil.QueryMap(db, "select version, checksum from "+checksumsTable)
+}
+
+// InsertChecksum records the checksum of a migration version
+func (drv *Driver) InsertChecksum(db dbutil.Transaction, version, checksum string) error {
+	checksumsTable, err := drv.quotedChecksumsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into "+checksumsTable+" (version, checksum) values ($1, $2)", version, checksum)
+
+	return err
+}
+
+// DeleteChecksum removes the checksum of a migration version
+func (drv *Driver) DeleteChecksum(db dbutil.Transaction, version string) error {
+	checksumsTable, err := drv.quotedChecksumsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("delete from "+checksumsTable+" where version = $1", version)
+
+	return err
+}
+
+// SwitchSchema sets the search_path to the specified schema, and returns a function
+// which restores the previous search_path
+func (drv *Driver) SwitchSchema(db dbutil.Transaction, schema string) (func() error, error) {
+	searchPath, err := dbutil.QueryValue(db, "show search_path")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("select set_config('search_path', $1, false)", pq.QuoteIdentifier(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := db.Exec("select set_config('search_path', $1, false)", searchPath)
+		return err
+	}, nil
+}
+
+// SetTimeout sets lock_timeout or statement_timeout, and returns a function which
+// restores the previous value
+func (drv *Driver) SetTimeout(db dbutil.Transaction, setting string, timeout time.Duration) (func() error, error) {
+	if setting != dbmate.TimeoutLock && setting != dbmate.TimeoutStatement {
+		return nil, fmt.Errorf("unsupported timeout: %s", setting)
+	}
+
+	previous, err := dbutil.QueryValue(db, "select current_setting($1)", setting)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("select set_config($1, $2, false)", setting, fmt.Sprintf("%dms", timeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := db.Exec("select set_config($1, $2, false)", setting, previous)
+		return err
+	}, nil
+}
+
+// Lock acquires a session level advisory lock, blocking until no other session holds
+// the lock for this migrations table
+func (drv *Driver) Lock(db dbutil.Transaction) error {
+	_, err := db.Exec("select pg_advisory_lock($1)", drv.lockKey())
+	return err
+}
+
+// Unlock releases the advisory lock acquired by Lock
+func (drv *Driver) Unlock(db dbutil.Transaction) error {
+	_, err := db.Exec("select pg_advisory_unlock($1)", drv.lockKey())
+	return err
+}
+
+// TryLock acquires the advisory lock if no other session holds it
+func (drv *Driver) TryLock(db dbutil.Transaction) (bool, error) {
+	result, err := dbutil.QueryValue(db, "select pg_try_advisory_lock($1)", drv.lockKey())
+	return result == "true", err
+}
+
+// SetLockHolder records the holder of the advisory lock held by the session db
+func (drv *Driver) SetLockHolder(db dbutil.Transaction, holder dbmate.LockHolder) error {
+	lockTable, err := drv.quotedLockTableName(db)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("create table if not exists " + lockTable + " (session varchar(64) primary key, " +
+		"host varchar(255) not null, pid integer not null, started_at timestamptz not null)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("delete from " + lockTable); err != nil {
+		return err
+	}
+	_, err = db.Exec("insert into "+lockTable+" (session, host, pid, started_at) "+
+		"values (pg_backend_pid()::text, $1, $2, $3)", holder.Host, holder.PID, holder.StartedAt)
+
+	return err
+}
+
+// LockHolder returns the holder of the advisory lock, or nil if it is not held
+func (drv *Driver) LockHolder(db *sql.DB) (*dbmate.LockHolder, error) {
+	// a bigint advisory lock key is split into classid and objid, with objsubid 1
+	key := uint64(drv.lockKey())
+	session, err := dbutil.QueryValue(db, "select pid from pg_locks where locktype = 'advisory' and granted "+
+		"and database = (select oid from pg_database where datname = current_database()) "+
+		"and classid = $1::oid and objid = $2::oid and objsubid = 1", key>>32, key&0xffffffff)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	holder := &dbmate.LockHolder{Session: session}
+	lockTable, err := drv.quotedLockTableName(db)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow("select host, pid, started_at from "+lockTable+" where session = $1", session).
+		Scan(&holder.Host, &holder.PID, &holder.StartedAt)
+	var pqErr *pq.Error
+	if err == sql.ErrNoRows || (errors.As(err, &pqErr) && pqErr.Code == "42P01") {
+		// the holder was not recorded, or the table does not exist
+		return holder, nil
+	}
+
+	return holder, err
+}
+
+// ForceUnlock terminates the backend with the given process ID, which releases the
+// advisory lock it holds
+func (drv *Driver) ForceUnlock(db *sql.DB, session string) error {
+	_, err := db.Exec("select pg_terminate_backend($1)", session)
+	return err
+}
+
+// SessionID returns the process ID of the backend serving the session
+func (drv *Driver) SessionID(db dbutil.Transaction) (string, error) {
+	return dbutil.QueryValue(db, "select pg_backend_pid()")
+}
+
+// CancelQuery cancels the statement running on the backend with the given process ID
+func (drv *Driver) CancelQuery(db *sql.DB, id string) error {
+	_, err := db.Exec("select pg_cancel_backend($1)", id)
+	return err
+}
+
+// IsRetryable reports whether err is a serialization failure (SQLSTATE 40001), which
+// CockroachDB returns as a "restart transaction" error when a transaction conflicts with
+// another, and which the client is expected to retry
+func (drv *Driver) IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// CheckSyntax checks statement by compiling it in the body of an anonymous PL/pgSQL
+// block which returns before reaching it, which parses the statement without executing
+// it. Names are not resolved, so only syntax errors are found.
+func (drv *Driver) CheckSyntax(db dbutil.Transaction, statement string) error {
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	_, err := db.Exec("do $dbmate_check$ begin return; " + statement + "; end $dbmate_check$")
+	return err
+}
+
+// lockKey returns the advisory lock key for the migrations table
+func (drv *Driver) lockKey() int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("dbmate:" + drv.migrationsTableName))
+	return int64(h.Sum64())
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv *Driver) Ping() error {
@@ -372,6 +794,51 @@ func (drv *Driver) quotedMigrationsTableName(db dbutil.Transaction) (string, err
 	return schema + "." + name, nil
 }
 
+// quotedChecksumsTableName returns the name of the checksums table, which is stored
+// alongside the migrations table
+func (drv *Driver) quotedChecksumsTableName(db dbutil.Transaction) (string, error) {
+	return drv.quotedSiblingTableName(db, "_checksums")
+}
+
+// quotedLockTableName returns the name of the table which records the lock holder, which
+// is stored alongside the migrations table
+func (drv *Driver) quotedLockTableName(db dbutil.Transaction) (string, error) {
+	return drv.quotedSiblingTableName(db, "_lock")
+}
+
+// quotedInternalTableNames returns the names of the tables which dbmate creates alongside
+// the migrations table, and which are left out of schema dumps. Quoted names are also
+// valid pg_dump patterns.
+func (drv *Driver) quotedInternalTableNames(db dbutil.Transaction) ([]string, error) {
+	checksumsTable, err := drv.quotedChecksumsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+	lockTable, err := drv.quotedLockTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{checksumsTable, lockTable}, nil
+}
+
+// quotedSiblingTableName returns the name of the migrations table with suffix appended
+func (drv *Driver) quotedSiblingTableName(db dbutil.Transaction, suffix string) (string, error) {
+	schema, tableNameParts, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return "", err
+	}
+
+	nameParts := append([]string{schema}, tableNameParts...)
+	nameParts[len(nameParts)-1] += suffix
+	quotedNameParts, err := dbutil.QueryColumn(db, "select quote_ident(unnest($1::text[]))", pq.Array(nameParts))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(quotedNameParts, "."), nil
+}
+
 func (drv *Driver) migrationsTableNameParts(db dbutil.Transaction) (string, []string, error) {
 	schema := ""
 	tableNameParts := strings.Split(drv.migrationsTableName, ".")
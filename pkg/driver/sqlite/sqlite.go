@@ -10,6 +10,8 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -88,6 +90,30 @@ func (drv *Driver) CreateDatabase() error {
 	return db.Ping()
 }
 
+// CreateDatabaseFromTemplate creates the specified database as a copy of the template
+// database file, which must be in the same directory
+func (drv *Driver) CreateDatabaseFromTemplate(template string) error {
+	path, _, _ := strings.Cut(ConnectionString(drv.databaseURL), "?")
+	fmt.Fprintf(drv.log, "Creating: %s (from template %s)\n", path, template)
+
+	src, err := os.Open(filepath.Join(filepath.Dir(path), template))
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(src)
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
 // DropDatabase drops the specified database (if it exists)
 func (drv *Driver) DropDatabase() error {
 	path := ConnectionString(drv.databaseURL)
@@ -128,14 +154,122 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ListDatabases returns the files in the same directory as the database file
+func (drv *Driver) ListDatabases() ([]string, error) {
+	path, _, _ := strings.Cut(ConnectionString(drv.databaseURL), "?")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Tables returns the tables in the database, excluding internal sqlite tables
+func (drv *Driver) Tables(db *sql.DB) ([]string, error) {
+	return dbutil.QueryColumn(db, "select name from sqlite_master "+
+		"where type = 'table' and name not like 'sqlite_%' "+
+		"order by name")
+}
+
+// DescribeTable returns the columns, indexes and foreign keys of table. SQLite does not
+// support comments, or names for foreign keys.
+func (drv *Driver) DescribeTable(db *sql.DB, table string) (dbmate.TableDescription, error) {
+	description := dbmate.TableDescription{Name: table}
+
+	rows, err := db.Query("select name, type, \"notnull\", coalesce(dflt_value, ''), pk "+
+		"from pragma_table_info(?) order by cid", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var column dbmate.ColumnDescription
+		var notNull, pk bool
+		if err := rows.Scan(&column.Name, &column.Type, &notNull, &column.Default, &pk); err != nil {
+			return description, err
+		}
+		column.Nullable = !notNull && !pk
+		description.Columns = append(description.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select l.name, l.\"unique\", i.name "+
+		"from pragma_index_list(?) l, pragma_index_info(l.name) i "+
+		"order by l.name, i.seqno", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	for rows.Next() {
+		var name, column string
+		var unique bool
+		if err := rows.Scan(&name, &unique, &column); err != nil {
+			return description, err
+		}
+		n := len(description.Indexes)
+		if n == 0 || description.Indexes[n-1].Name != name {
+			description.Indexes = append(description.Indexes, dbmate.IndexDescription{Name: name, Unique: unique})
+			n++
+		}
+		description.Indexes[n-1].Columns = append(description.Indexes[n-1].Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return description, err
+	}
+
+	rows, err = db.Query("select id, \"table\", \"from\", coalesce(\"to\", '') "+
+		"from pragma_foreign_key_list(?) order by id, seq", table)
+	if err != nil {
+		return description, err
+	}
+	defer dbutil.MustClose(rows)
+	lastID := -1
+	for rows.Next() {
+		var id int
+		var refTable, column, refColumn string
+		if err := rows.Scan(&id, &refTable, &column, &refColumn); err != nil {
+			return description, err
+		}
+		if id != lastID {
+			description.ForeignKeys = append(description.ForeignKeys,
+				dbmate.ForeignKeyDescription{ReferencedTable: refTable})
+			lastID = id
+		}
+		fk := &description.ForeignKeys[len(description.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, column)
+		fk.ReferencedColumns = append(fk.ReferencedColumns, refColumn)
+	}
+
+	return description, rows.Err()
+}
+
+// DumpCommand returns the command used to dump the schema
+func (drv *Driver) DumpCommand() string {
+	return "sqlite3"
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	path := ConnectionString(drv.databaseURL)
-	schema, err := dbutil.RunCommand("sqlite3", path, ".schema --nosys")
+	schema, err := dbutil.RunCommand(drv.DumpCommand(), path, ".schema --nosys")
 	if err != nil {
 		return nil, err
 	}
 
+	// the table which records checksums is not part of the schema, and the sqlite3 shell
+	// has no option to exclude it
+	schema = drv.checksumsTableRegexp().ReplaceAll(schema, nil)
+
 	migrations, err := drv.schemaMigrationsDump(db)
 	if err != nil {
 		return nil, err
@@ -145,6 +279,11 @@ func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	return dbutil.TrimLeadingSQLComments(schema)
 }
 
+// ConsoleCommand returns a sqlite3 command connected to the database
+func (drv *Driver) ConsoleCommand() (*exec.Cmd, error) {
+	return exec.Command("sqlite3", ConnectionString(drv.databaseURL)), nil
+}
+
 // DatabaseExists determines whether the database exists
 func (drv *Driver) DatabaseExists() (bool, error) {
 	_, err := os.Stat(ConnectionString(drv.databaseURL))
@@ -174,13 +313,58 @@ func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 
 // CreateMigrationsTable creates the schema migrations table
 func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec(fmt.Sprintf(
-		"create table if not exists %s (version varchar(128) primary key)",
-		drv.quotedMigrationsTableName()))
+	_, err := db.Exec(drv.createMigrationsTableStatement())
+	if err != nil {
+		return err
+	}
+
+	return drv.indexMigrationsTable(db)
+}
+
+// CheckCreateMigrationsTable returns an error if the migrations table cannot be created.
+// SQLite has no privileges to check, but its DDL is transactional, so the table is
+// created in a transaction which is then rolled back.
+func (drv *Driver) CheckCreateMigrationsTable(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(drv.createMigrationsTableStatement())
+	if rollbackErr := tx.Rollback(); err == nil {
+		err = rollbackErr
+	}
 
 	return err
 }
 
+func (drv *Driver) createMigrationsTableStatement() string {
+	return fmt.Sprintf("create table if not exists %s (version varchar(128) primary key)",
+		drv.quotedMigrationsTableName())
+}
+
+// indexMigrationsTable adds a unique index on the version column of a migrations table
+// which was created without a primary key (e.g. by another tool), so that selecting
+// migrations stays fast as versions accumulate. If the table contains duplicate versions
+// the index cannot be added, which is reported as a warning rather than an error.
+func (drv *Driver) indexMigrationsTable(db *sql.DB) error {
+	indexed, err := dbutil.QueryValue(db, `select count(*) from pragma_index_list(?) l, pragma_index_info(l.name) i
+		where i.seqno = 0 and i.name = 'version'`, drv.migrationsTableName)
+	if err != nil || indexed != "0" {
+		return err
+	}
+
+	table := drv.quotedMigrationsTableName()
+	fmt.Fprintf(drv.log, "Indexing: %s\n", table)
+	_, err = db.Exec(fmt.Sprintf("create unique index if not exists %s on %s (version)",
+		drv.quoteIdentifier(drv.migrationsTableName+"_version_idx"), table))
+	if err != nil {
+		fmt.Fprintf(drv.log, "Warning: could not index the version column of %s: %s\n", table, err)
+	}
+
+	return nil
+}
+
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
@@ -230,6 +414,49 @@ func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// CreateChecksumsTable creates the table which records the checksum of each migration
+func (drv *Driver) CreateChecksumsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version varchar(128) primary key, checksum varchar(64) not null)",
+		drv.quotedChecksumsTableName()))
+
+	return err
+}
+
+// SelectChecksums returns the recorded checksum of each migration version
+func (drv *Driver) SelectChecksums(db *sql.DB) (map[string]string, error) {
+	return dbutil.QueryMap(db, fmt.Sprintf("select version, checksum from %s", drv.quotedChecksumsTableName()))
+}
+
+// InsertChecksum records the checksum of a migration version
+func (drv *Driver) InsertChecksum(db dbutil.Transaction, version, checksum string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, checksum) values (?, ?)", drv.quotedChecksumsTableName()),
+		version, checksum)
+
+	return err
+}
+
+// DeleteChecksum removes the checksum of a migration version
+func (drv *Driver) DeleteChecksum(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("delete from %s where version = ?", drv.quotedChecksumsTableName()),
+		version)
+
+	return err
+}
+
+// CheckSyntax checks statement by compiling it with EXPLAIN, which parses it and resolves
+// the tables and columns it uses without executing it
+func (drv *Driver) CheckSyntax(db dbutil.Transaction, statement string) error {
+	rows, err := db.Query("explain " + statement)
+	if err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
 // Ping verifies a connection to the database. Due to the way SQLite works, by
 // testing whether the database is valid, it will automatically create the database
 // if it does not already exist.
@@ -247,6 +474,17 @@ func (drv *Driver) quotedMigrationsTableName() string {
 	return drv.quoteIdentifier(drv.migrationsTableName)
 }
 
+func (drv *Driver) quotedChecksumsTableName() string {
+	return drv.quoteIdentifier(drv.migrationsTableName + "_checksums")
+}
+
+// checksumsTableRegexp matches the statement which creates the checksums table in the
+// output of .schema
+func (drv *Driver) checksumsTableRegexp() *regexp.Regexp {
+	name := regexp.QuoteMeta(drv.migrationsTableName + "_checksums")
+	return regexp.MustCompile(`(?ims)^CREATE TABLE (IF NOT EXISTS )?("` + name + `"|` + name + `)\s*\(.*?\);\n`)
+}
+
 // quoteIdentifier quotes a table or column name
 // we fall back to lib/pq implementation since both use ansi standard (double quotes)
 // and mattn/go-sqlite3 doesn't provide a sqlite-specific equivalent
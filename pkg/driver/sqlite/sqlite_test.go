@@ -6,6 +6,8 @@ package sqlite
 import (
 	"database/sql"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
@@ -164,6 +166,38 @@ func TestSQLiteCreateDropDatabase(t *testing.T) {
 	require.Equal(t, true, os.IsNotExist(err))
 }
 
+func TestSQLiteCreateDatabaseFromTemplate(t *testing.T) {
+	template := testSQLiteDriver(t)
+	db := prepTestSQLiteDB(t)
+	_, err := db.Exec("create table users (id integer)")
+	require.NoError(t, err)
+	dbutil.MustClose(db)
+
+	u := dbmate.TenantURL(template.databaseURL, "dbmate_clone.sqlite3")
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+	clone := drv.(*Driver)
+	err = clone.DropDatabase()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, clone.DropDatabase()) }()
+
+	// create the clone from the template
+	err = clone.CreateDatabaseFromTemplate(filepath.Base(ConnectionString(template.databaseURL)))
+	require.NoError(t, err)
+
+	// check that the clone contains the template's tables
+	cloneDB, err := clone.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(cloneDB)
+	tables, err := clone.Tables(cloneDB)
+	require.NoError(t, err)
+	require.Equal(t, []string{"users"}, tables)
+
+	// an existing database is not overwritten
+	err = clone.CreateDatabaseFromTemplate(filepath.Base(ConnectionString(template.databaseURL)))
+	require.ErrorIs(t, err, os.ErrExist)
+}
+
 func TestSQLiteDumpSchema(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	drv.migrationsTableName = "test_migrations"
@@ -184,6 +218,10 @@ func TestSQLiteDumpSchema(t *testing.T) {
 	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)")
 	require.NoError(t, err)
 
+	// the checksums table is left out of the dump
+	err = drv.CreateChecksumsTable(db)
+	require.NoError(t, err)
+
 	// DumpSchema should return schema
 	schema, err := drv.DumpSchema(db)
 	require.NoError(t, err)
@@ -194,6 +232,8 @@ func TestSQLiteDumpSchema(t *testing.T) {
 		"  ('abc1'),\n"+
 		"  ('abc2');\n")
 
+	require.NotContains(t, string(schema), "test_migrations_checksums")
+
 	// sqlite_* tables should not be present in the dump (.schema --nosys)
 	require.NotContains(t, string(schema), "sqlite_")
 
@@ -279,6 +319,69 @@ func TestSQLiteCreateMigrationsTable(t *testing.T) {
 	})
 }
 
+func TestSQLiteCheckCreateMigrationsTable(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CheckCreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// the migrations table should not be created
+	exists, err := drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// a read-only database cannot create it
+	readOnly := testSQLiteDriver(t)
+	readOnly.databaseURL.RawQuery = "_query_only=true"
+	roDB, err := readOnly.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(roDB)
+	err = readOnly.CheckCreateMigrationsTable(roDB)
+	require.ErrorContains(t, err, "readonly database")
+}
+
+func TestSQLiteCreateMigrationsTableLegacy(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+	log := &strings.Builder{}
+	drv.log = log
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	// a table created without a primary key is given a unique index on its version column
+	_, err := db.Exec("create table test_migrations (version varchar(128))")
+	require.NoError(t, err)
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Indexing: ")
+
+	var indexes int
+	err = db.QueryRow(`select count(*) from pragma_index_list('test_migrations')`).Scan(&indexes)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexes)
+
+	// the index is only added once
+	log.Reset()
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Empty(t, log.String())
+
+	// duplicate versions prevent the index from being added, which is only a warning
+	_, err = db.Exec("drop table test_migrations")
+	require.NoError(t, err)
+	_, err = db.Exec("create table test_migrations (version varchar(128))")
+	require.NoError(t, err)
+	_, err = db.Exec("insert into test_migrations (version) values ('abc1'), ('abc1')")
+	require.NoError(t, err)
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Warning: could not index the version column of ")
+	require.Contains(t, log.String(), "UNIQUE constraint failed")
+}
+
 func TestSQLiteSelectMigrations(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	drv.migrationsTableName = "test_migrations"
@@ -355,6 +458,58 @@ func TestSQLiteDeleteMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestSQLiteChecksums(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateChecksumsTable(db)
+	require.NoError(t, err)
+
+	err = drv.InsertChecksum(db, "abc1", "sum1")
+	require.NoError(t, err)
+	err = drv.InsertChecksum(db, "abc2", "sum2")
+	require.NoError(t, err)
+	err = drv.DeleteChecksum(db, "abc2")
+	require.NoError(t, err)
+
+	checksums, err := drv.SelectChecksums(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"abc1": "sum1"}, checksums)
+
+	// the table is named after the migrations table
+	count := 0
+	err = db.QueryRow("select count(*) from test_migrations_checksums").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestSQLiteCheckSyntax(t *testing.T) {
+	drv := testSQLiteDriver(t)
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+	_, err := db.Exec("create table users (id integer)")
+	require.NoError(t, err)
+
+	require.NoError(t, drv.CheckSyntax(db, "drop table users;"))
+	require.NoError(t, drv.CheckSyntax(db, "delete from users where id = 1"))
+
+	err = drv.CheckSyntax(db, "drop tabel users;")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "syntax error")
+
+	err = drv.CheckSyntax(db, "delete from posts")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no such table: posts")
+
+	// the statements were not executed
+	_, err = db.Exec("insert into users (id) values (1)")
+	require.NoError(t, err)
+}
+
 func TestSQLitePing(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	path := ConnectionString(drv.databaseURL)
@@ -0,0 +1,289 @@
+// Package service implements the gRPC migration service defined in dbmate.proto, so that
+// deployment orchestrators can apply and inspect migrations over the network.
+//
+// gRPC runs over HTTP/2, so the Server must be served by an http.Server with HTTP/2
+// enabled, which net/http does for TLS connections (see ListenAndServeTLS). The service
+// can change the database, so ListenAndServeTLS only accepts clients which present a
+// certificate signed by a trusted CA.
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// MigrationEvent phases
+const (
+	phaseStarted  = 1
+	phaseFinished = 2
+	phaseFailed   = 3
+)
+
+// Server serves the Migrations gRPC service for a database
+type Server struct {
+	db *dbmate.DB
+	// RollbackRefused, if set, is returned by Rollback calls instead of rolling back, e.g.
+	// because the database is in a production environment
+	RollbackRefused error
+	// mu allows only one Migrate or Rollback call to run at a time
+	mu sync.Mutex
+}
+
+// NewServer returns a Server which manages db. db must not be modified while the server
+// is running.
+func NewServer(db *dbmate.DB) *Server {
+	return &Server{db: db}
+}
+
+// ServeHTTP handles a gRPC call
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2 POST requests", http.StatusBadRequest)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/grpc" && contentType != "application/grpc+proto" {
+		http.Error(w, "unsupported content type: "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	methods := map[string]func(context.Context, http.ResponseWriter) error{
+		"/dbmate.v1.Migrations/Migrate":    s.migrate,
+		"/dbmate.v1.Migrations/Rollback":   s.rollback,
+		"/dbmate.v1.Migrations/Status":     s.status,
+		"/dbmate.v1.Migrations/DumpSchema": s.dumpSchema,
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	method, ok := methods[r.URL.Path]
+	if !ok {
+		writeStatus(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	ctx := r.Context()
+	if value := r.Header.Get("Grpc-Timeout"); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			writeStatus(w, codeInvalidArgument, err.Error())
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// every request is a single message, which has no fields
+	if _, err := readMessage(r.Body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err := method(ctx, w)
+	if err == nil {
+		writeStatus(w, codeOK, "")
+		return
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	writeError(w, err)
+}
+
+// writeError ends a response with the gRPC status which best describes err
+func writeError(w http.ResponseWriter, err error) {
+	var statusErr statusError
+	var parseErr *dbmate.ParseError
+	var migrationErr *dbmate.MigrationError
+	var opErr *net.OpError
+
+	message := err.Error()
+	if errors.As(err, &migrationErr) {
+		message = migrationErr.FileName + ": " + message
+	}
+
+	code := codeUnknown
+	switch {
+	case errors.As(err, &statusErr):
+		code = statusErr.code
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		code = codeInvalidArgument
+	case errors.Is(err, context.Canceled):
+		code = codeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codeDeadlineExceeded
+	case errors.Is(err, dbmate.ErrCantConnect), errors.As(err, &opErr):
+		code = codeUnavailable
+	case errors.Is(err, dbmate.ErrLocked), errors.Is(err, dbmate.ErrLockFailed):
+		code = codeAborted
+	case errors.As(err, &parseErr), errors.Is(err, dbmate.ErrNoRollback):
+		code = codeFailedPrecondition
+	}
+
+	writeStatus(w, code, dbmate.RedactString(message))
+}
+
+// streamEvents returns a copy of the server's database which sends a MigrationEvent to w
+// as each migration starts and finishes, in addition to calling its own hooks
+func (s *Server) streamEvents(w http.ResponseWriter) *dbmate.DB {
+	send := func(phase uint64, event dbmate.MigrationEvent, err error) {
+		msg := protoBuffer{}
+		msg.uint64(1, phase)
+		msg.string(2, event.Version)
+		msg.string(3, event.FileName)
+		msg.string(4, event.Direction)
+		msg.int64(5, event.Duration.Milliseconds())
+		if err != nil {
+			msg.string(6, dbmate.RedactString(err.Error()))
+		}
+		// the client may have gone away, in which case the request context is cancelled
+		_ = writeMessage(w, msg.b)
+	}
+
+	db := *s.db
+	db.Hooks = dbmate.MultiHooks(s.db.Hooks, dbmate.Hooks{
+		BeforeMigration: func(event dbmate.MigrationEvent) { send(phaseStarted, event, nil) },
+		AfterMigration:  func(event dbmate.MigrationEvent) { send(phaseFinished, event, nil) },
+		OnError:         func(event dbmate.MigrationEvent, err error) { send(phaseFailed, event, err) },
+	})
+
+	return &db
+}
+
+func (s *Server) migrate(ctx context.Context, w http.ResponseWriter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.streamEvents(w).MigrateWithResult(ctx)
+	return err
+}
+
+func (s *Server) rollback(ctx context.Context, w http.ResponseWriter) error {
+	if s.RollbackRefused != nil {
+		return statusError{codeFailedPrecondition, s.RollbackRefused.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.streamEvents(w).RollbackWithResult(ctx)
+	return err
+}
+
+func (s *Server) status(_ context.Context, w http.ResponseWriter) error {
+	report, err := s.db.StatusExtended()
+	if err != nil {
+		return err
+	}
+
+	msg := protoBuffer{}
+	for _, m := range report.Migrations {
+		migration := protoBuffer{}
+		migration.string(1, m.Version)
+		migration.string(2, m.FileName)
+		migration.bool(3, m.Applied)
+		migration.string(4, m.Description)
+		migration.string(5, report.Checksums[m.Version])
+		msg.bytes(1, migration.b)
+	}
+	for _, version := range report.Missing {
+		msg.bytes(2, []byte(version))
+	}
+
+	return writeMessage(w, msg.b)
+}
+
+func (s *Server) dumpSchema(_ context.Context, w http.ResponseWriter) error {
+	schema, err := s.db.CurrentSchema()
+	if err != nil {
+		return err
+	}
+
+	msg := protoBuffer{}
+	if len(schema) > 0 {
+		msg.bytes(1, schema)
+	}
+
+	return writeMessage(w, msg.b)
+}
+
+// TLSFiles are the PEM files which secure the service
+type TLSFiles struct {
+	// CertFile and KeyFile are the server's certificate and private key
+	CertFile string
+	KeyFile  string
+	// ClientCAFile contains the CA certificates which client certificates must be signed by
+	ClientCAFile string
+}
+
+// ListenAndServeTLS serves s on addr using TLS (which enables HTTP/2), until ctx is done.
+// Clients must present a certificate signed by one of the CAs in files.ClientCAFile. Calls
+// in progress are given up to shutdownTimeout to finish before the server closes.
+func ListenAndServeTLS(ctx context.Context, addr string, files TLSFiles, s *Server,
+	shutdownTimeout time.Duration,
+) error {
+	tlsConfig, err := serverTLSConfig(files.ClientCAFile)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServeTLS(files.CertFile, files.KeyFile)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); errors.Is(err, context.DeadlineExceeded) {
+		// cancel the calls which are still running
+		return server.Close()
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// serverTLSConfig returns a TLS config which requires clients to present a certificate
+// signed by one of the PEM encoded CA certificates in clientCAFile
+func serverTLSConfig(clientCAFile string) (*tls.Config, error) {
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}, nil
+}
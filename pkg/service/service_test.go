@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
+
+	"github.com/stretchr/testify/require"
+)
+
+// protoField is a decoded protobuf field
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeFields decodes the fields of a protobuf message, which only uses the varint and
+// length-delimited wire types
+func decodeFields(t *testing.T, msg []byte) []protoField {
+	fields := []protoField{}
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		require.Positive(t, n)
+		msg = msg[n:]
+
+		field := protoField{num: int(tag >> 3)}
+		value, n := binary.Uvarint(msg)
+		require.Positive(t, n)
+		msg = msg[n:]
+		switch tag & 7 {
+		case wireVarint:
+			field.varint = value
+		case wireBytes:
+			field.bytes = msg[:value]
+			msg = msg[value:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// event is a decoded MigrationEvent
+type event struct {
+	phase     uint64
+	fileName  string
+	direction string
+	err       string
+}
+
+func decodeEvent(t *testing.T, msg []byte) event {
+	e := event{}
+	for _, f := range decodeFields(t, msg) {
+		switch f.num {
+		case 1:
+			e.phase = f.varint
+		case 3:
+			e.fileName = string(f.bytes)
+		case 4:
+			e.direction = string(f.bytes)
+		case 6:
+			e.err = string(f.bytes)
+		}
+	}
+
+	return e
+}
+
+// callResult is the response to a gRPC call
+type callResult struct {
+	messages [][]byte
+	status   string
+	message  string
+}
+
+func call(t *testing.T, server *httptest.Server, method string) callResult {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/dbmate.v1.Migrations/"+method,
+		bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	result := callResult{messages: [][]byte{}}
+	for len(body) > 0 {
+		require.GreaterOrEqual(t, len(body), 5)
+		size := binary.BigEndian.Uint32(body[1:5])
+		result.messages = append(result.messages, body[5:5+size])
+		body = body[5+size:]
+	}
+	result.status = resp.Trailer.Get("Grpc-Status")
+	result.message = resp.Trailer.Get("Grpc-Message")
+
+	return result
+}
+
+func TestServer(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"db/migrations/001_create_users.sql": {
+			Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"),
+		},
+		"db/migrations/002_create_posts.sql": {
+			Data: []byte("-- migrate:description Adds posts\n-- migrate:up\ncreate table posts (id integer);\n" +
+				"-- migrate:down\ndrop table posts;\n"),
+		},
+	}
+
+	db := dbmate.New(dbutil.MustParseURL("sqlite:" + filepath.Join(t.TempDir(), "service.sqlite3")))
+	db.FS = mapFS
+	db.AutoDumpSchema = false
+	db.Log = io.Discard
+
+	s := NewServer(db)
+	server := httptest.NewUnstartedServer(s)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	t.Run("migrate", func(t *testing.T) {
+		result := call(t, server, "Migrate")
+		require.Equal(t, "0", result.status)
+		require.Len(t, result.messages, 4)
+		require.Equal(t, event{phase: phaseStarted, fileName: "001_create_users.sql", direction: "up"},
+			decodeEvent(t, result.messages[0]))
+		require.Equal(t, event{phase: phaseFinished, fileName: "001_create_users.sql", direction: "up"},
+			decodeEvent(t, result.messages[1]))
+		require.Equal(t, event{phase: phaseFinished, fileName: "002_create_posts.sql", direction: "up"},
+			decodeEvent(t, result.messages[3]))
+	})
+
+	t.Run("status", func(t *testing.T) {
+		result := call(t, server, "Status")
+		require.Equal(t, "0", result.status)
+		require.Len(t, result.messages, 1)
+
+		migrations := decodeFields(t, result.messages[0])
+		require.Len(t, migrations, 2)
+		require.Equal(t, []protoField{
+			{num: 1, bytes: []byte("002")},
+			{num: 2, bytes: []byte("002_create_posts.sql")},
+			{num: 3, varint: 1},
+			{num: 4, bytes: []byte("Adds posts")},
+			{num: 5, bytes: []byte(dbmate.ChecksumUnknown)},
+		}, decodeFields(t, migrations[1].bytes))
+	})
+
+	t.Run("dump schema", func(t *testing.T) {
+		result := call(t, server, "DumpSchema")
+		require.Equal(t, "0", result.status)
+		fields := decodeFields(t, result.messages[0])
+		require.Len(t, fields, 1)
+		require.Contains(t, string(fields[0].bytes), "CREATE TABLE posts")
+	})
+
+	t.Run("rollback refused", func(t *testing.T) {
+		s.RollbackRefused = errors.New("production is a production environment")
+		defer func() { s.RollbackRefused = nil }()
+
+		result := call(t, server, "Rollback")
+		require.Equal(t, "9", result.status)
+		require.Equal(t, "production is a production environment", result.message)
+		require.Empty(t, result.messages)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		result := call(t, server, "Rollback")
+		require.Equal(t, "0", result.status)
+		require.Len(t, result.messages, 2)
+		require.Equal(t, event{phase: phaseFinished, fileName: "002_create_posts.sql", direction: "down"},
+			decodeEvent(t, result.messages[1]))
+	})
+
+	t.Run("failed migration", func(t *testing.T) {
+		mapFS["db/migrations/003_invalid.sql"] = &fstest.MapFile{
+			Data: []byte("-- migrate:up\ncreate table;\n-- migrate:down\n"),
+		}
+		defer delete(mapFS, "db/migrations/003_invalid.sql")
+
+		result := call(t, server, "Migrate")
+		require.Equal(t, "2", result.status)
+		require.Contains(t, result.message, "003_invalid.sql")
+		last := decodeEvent(t, result.messages[len(result.messages)-1])
+		require.Equal(t, uint64(phaseFailed), last.phase)
+		require.Equal(t, "003_invalid.sql", last.fileName)
+		require.Contains(t, last.err, "syntax error")
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		result := call(t, server, "Drop")
+		require.Equal(t, "12", result.status)
+		require.Empty(t, result.messages)
+	})
+
+	t.Run("not grpc", func(t *testing.T) {
+		resp, err := server.Client().Post(server.URL+"/dbmate.v1.Migrations/Status", "application/json",
+			strings.NewReader("{}"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+}
+
+// newCertificate returns a certificate for name signed by parent, or a self-signed CA
+// certificate if parent is nil
+func newCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca := newCertificate(t, "dbmate test CA", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600))
+
+	tlsConfig, err := serverTLSConfig(caFile)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(certificates ...tls.Certificate) error {
+		client := server.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certificates
+		client.Transport = transport
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	t.Run("signed client certificate", func(t *testing.T) {
+		require.NoError(t, get(newCertificate(t, "client", &ca)))
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		require.Error(t, get())
+	})
+
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		other := newCertificate(t, "other CA", nil)
+		require.Error(t, get(newCertificate(t, "client", &other)))
+	})
+
+	t.Run("no CA certificates", func(t *testing.T) {
+		emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+		require.NoError(t, os.WriteFile(emptyFile, nil, 0o600))
+		_, err := serverTLSConfig(emptyFile)
+		require.EqualError(t, err, "no certificates found in "+emptyFile)
+	})
+}
+
+func TestParseTimeout(t *testing.T) {
+	timeout, err := parseTimeout("30S")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, timeout)
+
+	timeout, err = parseTimeout("250m")
+	require.NoError(t, err)
+	require.Equal(t, 250*time.Millisecond, timeout)
+
+	_, err = parseTimeout("10x")
+	require.Error(t, err)
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	require.Equal(t, "100%25 done%0Aok", encodeGRPCMessage("100% done\nok"))
+}
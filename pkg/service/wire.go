@@ -0,0 +1,174 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gRPC status codes used by the service
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeFailedPrecondition = 9
+	codeAborted            = 10
+	codeUnimplemented      = 12
+	codeUnavailable        = 14
+)
+
+// maxRequestSize limits the size of request messages, which are all empty
+const maxRequestSize = 4096
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// protoBuffer encodes a protobuf message. The service only has a few small messages, so
+// they are encoded by hand rather than with generated code. Fields with their default
+// value are omitted, as in proto3.
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) tag(field, wireType int) {
+	p.b = binary.AppendUvarint(p.b, uint64(field<<3|wireType))
+}
+
+func (p *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, wireVarint)
+	p.b = binary.AppendUvarint(p.b, v)
+}
+
+func (p *protoBuffer) int64(field int, v int64) {
+	p.uint64(field, uint64(v))
+}
+
+func (p *protoBuffer) bool(field int, v bool) {
+	if v {
+		p.uint64(field, 1)
+	}
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	if s != "" {
+		p.bytes(field, []byte(s))
+	}
+}
+
+// bytes encodes a length-delimited field, even if it is empty, since elements of
+// repeated fields must always be encoded
+func (p *protoBuffer) bytes(field int, b []byte) {
+	p.tag(field, wireBytes)
+	p.b = binary.AppendUvarint(p.b, uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+// readMessage reads a length-prefixed gRPC message from r
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, statusError{codeUnimplemented, "compressed messages are not supported"}
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxRequestSize {
+		return nil, statusError{codeInvalidArgument, fmt.Sprintf("message of %d bytes is too large", size)}
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeMessage writes a length-prefixed gRPC message to w, and flushes it to the client
+func writeMessage(w http.ResponseWriter, msg []byte) error {
+	header := [5]byte{}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := w.Write(append(header[:], msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// statusError is an error with a gRPC status code
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e statusError) Error() string {
+	return e.message
+}
+
+// writeStatus ends a response by sending its gRPC status in the trailers
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// encodeGRPCMessage percent-encodes a status message, as required by the gRPC protocol
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// parseTimeout parses the grpc-timeout request header, e.g. "30S"
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, errors.New("invalid grpc-timeout")
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid grpc-timeout")
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, errors.New("invalid grpc-timeout")
+	}
+
+	return time.Duration(n) * unit, nil
+}
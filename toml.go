@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses a TOML config file into the same structure as a YAML config file:
+// tables become maps of keys to values, and arrays become slices. It supports the parts of
+// TOML which a config file needs (tables, dotted keys, strings, integers, floats,
+// booleans, arrays and inline tables), and reports an error for the rest, such as arrays
+// of tables, multi-line strings and dates.
+func parseTOML(data string) (map[string]interface{}, error) {
+	p := &tomlParser{s: data, line: 1}
+	root := map[string]interface{}{}
+	current := root
+	defined := map[string]bool{}
+
+	for {
+		p.skip(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.s[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skip(false)
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skip(false)
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+
+			name := strings.Join(keys, ".")
+			if defined[name] {
+				return nil, p.errorf("table %s is defined more than once", name)
+			}
+			defined[name] = true
+			if current, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		} else if err := p.keyValue(current); err != nil {
+			return nil, err
+		}
+
+		p.skip(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("expected a new line, found %q", p.peek())
+		}
+	}
+}
+
+// tomlParser holds the position of parseTOML within the file
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.s[p.pos]
+}
+
+// skip skips whitespace and comments, and new lines if newlines is set
+func (p *tomlParser) skip(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) expect(c byte) error {
+	if p.eof() {
+		return p.errorf("expected %q, found end of file", c)
+	}
+	if p.peek() != c {
+		return p.errorf("expected %q, found %q", c, p.peek())
+	}
+	p.pos++
+
+	return nil
+}
+
+// table returns the table named by keys within parent, creating any tables which do not
+// exist yet
+func (p *tomlParser) table(parent map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		if parent[key] == nil {
+			parent[key] = map[string]interface{}{}
+		}
+		table, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return nil, p.errorf("%s is already defined as a value, not a table", key)
+		}
+		parent = table
+	}
+
+	return parent, nil
+}
+
+// keyValue parses a "key = value" pair into table
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if err := p.expect('='); err != nil {
+		return err
+	}
+	p.skip(false)
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+
+	parent, err := p.table(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, ok := parent[key]; ok {
+		return p.errorf("%s is defined more than once", strings.Join(keys, "."))
+	}
+	parent[key] = value
+
+	return nil
+}
+
+// key parses a bare, quoted or dotted key
+func (p *tomlParser) key() ([]string, error) {
+	keys := []string{}
+	for {
+		var key string
+		var err error
+		switch {
+		case p.eof():
+			return nil, p.errorf("expected a key, found end of file")
+		case p.peek() == '"':
+			key, err = p.basicString()
+		case p.peek() == '\'':
+			key, err = p.literalString()
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key, found %q", p.peek())
+			}
+			key = p.s[start:p.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		p.skip(false)
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.skip(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// value parses a value, which may be an array or inline table
+func (p *tomlParser) value() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("expected a value, found end of file")
+	}
+
+	switch p.peek() {
+	case '"':
+		if strings.HasPrefix(p.s[p.pos:], `"""`) {
+			return nil, p.errorf("multi-line strings are not supported")
+		}
+		return p.basicString()
+	case '\'':
+		if strings.HasPrefix(p.s[p.pos:], "'''") {
+			return nil, p.errorf("multi-line strings are not supported")
+		}
+		return p.literalString()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n#,]}", rune(p.peek())) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+
+	return nil, p.errorf("unsupported value %q (strings must be quoted)", token)
+}
+
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skip(true)
+		if !p.eof() && p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skip(true)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := map[string]interface{}{}
+	p.skip(false)
+	if !p.eof() && p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+
+	for {
+		p.skip(false)
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect('}'); err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+}
+
+// basicString parses a double quoted string, which may contain escape sequences
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.peek()
+			p.pos++
+			simple := map[byte]byte{'b': '\b', 't': '\t', 'n': '\n', 'f': '\f', 'r': '\r', '"': '"', '\\': '\\'}
+			if r, ok := simple[escape]; ok {
+				b.WriteByte(r)
+				continue
+			}
+
+			size := map[byte]int{'u': 4, 'U': 8}[escape]
+			if size == 0 {
+				return "", p.errorf("invalid escape sequence \\%c", escape)
+			}
+			if p.pos+size > len(p.s) {
+				return "", p.errorf("unterminated string")
+			}
+			code, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", p.errorf("invalid escape sequence \\%c%s", escape, p.s[p.pos:p.pos+size])
+			}
+			b.WriteRune(rune(code))
+			p.pos += size
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// literalString parses a single quoted string, which has no escape sequences
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.s[p.pos:], "'\n")
+	if end < 0 || p.s[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+
+	return s, nil
+}